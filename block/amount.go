@@ -0,0 +1,43 @@
+package block
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// FixedToken is a token amount the way it is signed and stored in blocks:
+// an integer number of the smallest unit of the token together with the
+// number of decimals of the token.
+type FixedToken struct {
+	TokenName string `json:"token_name"`
+	Amount    string `json:"amount"`
+	Decimals  uint8  `json:"decimals"`
+}
+
+// NewFixedToken converts token to units of 10^-decimals. Amounts more
+// precise than that are truncated, validation rejects them beforehand.
+func NewFixedToken(token Token, decimals uint8) FixedToken {
+	return FixedToken{
+		TokenName: token.TokenName,
+		Amount:    token.TokenValue.Shift(int32(decimals)).Truncate(0).String(),
+		Decimals:  decimals,
+	}
+}
+
+func (ft FixedToken) Token() (Token, error) {
+	units, err := decimal.NewFromString(ft.Amount)
+	if err != nil {
+		return Token{}, fmt.Errorf("token amount: %v", err)
+	}
+	if !fitsDecimals(units, 0) {
+		return Token{}, fmt.Errorf("token amount %s is not an integer", ft.Amount)
+	}
+	return Token{TokenName: ft.TokenName, TokenValue: units.Shift(-int32(ft.Decimals))}, nil
+}
+
+// fitsDecimals reports whether value has no more than decimals digits after
+// the decimal point.
+func fitsDecimals(value decimal.Decimal, decimals uint8) bool {
+	return value.Equal(value.Truncate(int32(decimals)))
+}
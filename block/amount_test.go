@@ -0,0 +1,85 @@
+package block
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestFixedToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		decimals uint8
+		amount   string
+	}{
+		{"whole", "12", 0, "12"},
+		{"cents", "1.5", 2, "150"},
+		{"native", "0.00000001", NativeTokenDecimals, "1"},
+		{"max decimals", "1", MaxTokenDecimals, "1000000000000000000"},
+		{"zero", "0", 8, "0"},
+		{"too precise", "1.005", 2, "100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ft := NewFixedToken(Token{TokenName: "GOLD", TokenValue: decimal.RequireFromString(tt.value)}, tt.decimals)
+			if ft.Amount != tt.amount {
+				t.Fatalf("amount %s, want %s", ft.Amount, tt.amount)
+			}
+			token, err := ft.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := decimal.RequireFromString(tt.value).Truncate(int32(tt.decimals)); !token.TokenValue.Equal(want) {
+				t.Errorf("round trip %s, want %s", token.TokenValue, want)
+			}
+		})
+	}
+
+	for _, amount := range []string{"1.5", "one", ""} {
+		if _, err := (FixedToken{TokenName: "GOLD", Amount: amount}).Token(); err == nil {
+			t.Errorf("Token() of amount %q succeeded", amount)
+		}
+	}
+}
+
+func TestTransferChecksDecimals(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		decimals uint8
+		want     error
+	}{
+		{"whole", "3", 2, nil},
+		{"cents", "0.25", 2, nil},
+		{"too precise", "0.255", 2, ErrWrongDecimals},
+		{"signed with other decimals", "3", 8, ErrWrongDecimals},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 1)
+			if err := bc.AddTransaction(issue(bc, alice, "GOLD", "10", &TokenIssue{Decimals: 2}, 1)); err != nil {
+				t.Fatal(err)
+			}
+			mine(t, bc, 1)
+
+			tx := NewTransaction(alice.address, bob.address, Token{TokenName: "GOLD", TokenValue: decimal.RequireFromString(tt.value)})
+			tx.SetDecimals(tt.decimals)
+			sign(bc, alice, tx, 2)
+			if err := bc.AddTransaction(tx); !errors.Is(err, tt.want) {
+				t.Fatalf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+			mine(t, bc, 1)
+			if got := bc.CalculateTotalAmount(bob.address, "GOLD"); !got.Equal(decimal.RequireFromString(tt.value)) {
+				t.Errorf("recipient holds %s, want %s", got, tt.value)
+			}
+		})
+	}
+}
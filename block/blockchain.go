@@ -6,11 +6,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"main/config"
 	"main/utils"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -19,23 +21,53 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+var (
+	ErrReservedSender       = errors.New("sender address is reserved for mining rewards")
+	ErrDuplicateTransaction = errors.New("transaction already in pool")
+	ErrAlreadyIncluded      = errors.New("transaction already included in the chain")
+	ErrTransactionExpired   = errors.New("transaction expired")
+	ErrInsufficientBalance  = errors.New("not enough balance in a wallet")
+	ErrImmatureReward       = errors.New("not enough balance in a wallet until mining rewards mature")
+	ErrInvalidSignature     = errors.New("invalid transaction signature")
+	ErrSenderMismatch       = errors.New("sender address does not belong to the public key")
+	ErrWrongChain           = errors.New("transaction belongs to another chain")
+)
+
 type Block struct {
-	timestamp    int64
-	nonce        int
-	previousHash [32]byte
-	transactions []*Transaction
+	timestamp        int64
+	nonce            int
+	previousHash     [32]byte
+	transactionsRoot [32]byte
+	stateRoot        [32]byte
+	transactions     []*Transaction
+	pruned           bool
 }
 
+// Blockchain state (chain, snapshot, tipContracts, transactionPool,
+// evictions) is guarded by mux. Exported methods take the lock themselves; their unexported
+// counterparts expect the caller to hold it. muxMining serializes block
+// production so the proof of work can run without holding mux.
 type Blockchain struct {
 	transactionPool   []*Transaction
 	chain             []*Block
 	blockchainAddress string
 	port              uint16
-	mux               sync.Mutex
+	mux               sync.RWMutex
+	muxMining         sync.Mutex
 	db                *badger.DB
 	nodes             []string
-	muxNodes          sync.Mutex
+	prunedHeights     map[string]int64
+	muxNodes          sync.RWMutex
 	conf              config.Blockchain
+	network           config.Network
+	genesis           *Genesis
+	genesisHash       [32]byte
+	evictions         []*Eviction
+	events            eventBus
+	snapshot          *snapshot
+	// tipContracts is the contract registry of chain, kept up to date by
+	// rebuildState and executeBlock.
+	tipContracts map[string]*ContractInfo
 }
 
 // func NewBlockchain(blockchainAddress string, port uint16) *Blockchain {
@@ -48,8 +80,40 @@ type Blockchain struct {
 // }
 
 func CreateBlockchain(blockchainAddress string, conf config.Blockchain) (*Blockchain, error) {
-	var lastHash [32]byte
-	b := &Block{}
+	network, err := config.GetNetwork(conf.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	if blockchainAddress != "" {
+		if err := utils.ValidateAddress(blockchainAddress, network.AddressVersion); err != nil {
+			return nil, fmt.Errorf("miner address: %v", err)
+		}
+	}
+
+	genesis := DefaultGenesis(conf, network)
+	if conf.GenesisPath != "" {
+		genesis, err = LoadGenesis(conf.GenesisPath)
+	} else if m, _ := network.Genesis(); m != nil {
+		genesis, err = ParseGenesis(m)
+	} else {
+		err = genesis.Validate()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := genesis.CheckAddresses(network.AddressVersion); err != nil {
+		return nil, err
+	}
+	if conf.PruneKeepBlocks < 0 {
+		return nil, fmt.Errorf("prune keep blocks must not be negative, got %d", conf.PruneKeepBlocks)
+	}
+	if conf.PruneKeepBlocks > 0 && conf.SnapshotInterval <= 0 {
+		return nil, fmt.Errorf("snapshot interval must be positive, got %d", conf.SnapshotInterval)
+	}
+	genesisBlock := genesis.Block()
+	genesisHash := genesisBlock.Hash()
+
 	bc := new(Blockchain)
 	bc.blockchainAddress = blockchainAddress
 	opts := badger.DefaultOptions(conf.DbSavePath)
@@ -59,42 +123,52 @@ func CreateBlockchain(blockchainAddress string, conf config.Blockchain) (*Blockc
 		panic(err)
 	}
 	err = db.Update(func(txn *badger.Txn) error {
-		if _, err := txn.Get([]byte("lh")); err == badger.ErrKeyNotFound {
+		item, err := txn.Get([]byte("gh"))
+		if err == badger.ErrKeyNotFound {
 			fmt.Println("no existing blockchain found")
-			lastHash = b.Hash()
 			fmt.Println("genesis created")
-			err = txn.Set([]byte("lh"), lastHash[:])
-			if err != nil {
-				return fmt.Errorf("error occured while getting hash: %v", err)
-			}
-			return err
-		} else {
-			item, err := txn.Get([]byte("lh"))
-			if err != nil {
-				return fmt.Errorf("error occured while getting lasted hash: %v", err)
-			}
-			item.Value(func(val []byte) error {
-				copy(lastHash[:], val)
-				return nil
-			})
-			return err
+			return txn.Set([]byte("gh"), genesisHash[:])
+		}
+		if err != nil {
+			return fmt.Errorf("error occured while getting genesis hash: %v", err)
 		}
+		return item.Value(func(val []byte) error {
+			if !bytes.Equal(val, genesisHash[:]) {
+				return fmt.Errorf("database %s belongs to another network (genesis %x)", conf.DbSavePath, val)
+			}
+			return nil
+		})
 	})
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 	bc.db = db
+	bc.conf = conf
+	bc.network = network
+	bc.genesis = genesis
+	bc.genesisHash = genesisHash
 	bc.port = conf.BlockChainPort
-	fmt.Println(fmt.Sprintf("last hash: %x", lastHash))
-	bc.CreateBlock(0, lastHash)
+	fmt.Println(fmt.Sprintf("network %s, genesis hash: %x", genesis.NetworkID, genesisHash))
+	bc.chain = []*Block{genesisBlock}
+	if err := bc.loadSnapshot(); err != nil {
+		log.Printf("ERROR: could not load the state snapshot: %v", err)
+	}
+	bc.restoreChain()
+	bc.updateLastHash()
+	bc.rebuildState(0)
 	return bc, nil
 }
 
-func NewBlock(nonce int, previousHash [32]byte, transactions []*Transaction) *Block {
+// NewBlock builds a block on top of previousHash. stateRoot is the root of
+// the state tree once transactions are applied.
+func NewBlock(nonce int, previousHash [32]byte, stateRoot [32]byte, transactions []*Transaction) *Block {
 	b := new(Block)
 	b.timestamp = time.Now().UnixNano()
 	b.nonce = nonce
 	b.previousHash = previousHash
+	b.transactionsRoot = TransactionsRoot(transactions)
+	b.stateRoot = stateRoot
 	b.transactions = transactions
 	return b
 }
@@ -111,18 +185,38 @@ func (b *Block) Transactions() []*Transaction {
 	return b.transactions
 }
 
+func (b *Block) StateRoot() [32]byte {
+	return b.stateRoot
+}
+
+// Pruned reports whether the body of the block has been discarded, leaving
+// its header.
+func (b *Block) Pruned() bool {
+	return b.pruned
+}
+
+func (b *Block) Header() *BlockHeader {
+	return &BlockHeader{
+		Timestamp:        b.timestamp,
+		Nonce:            b.nonce,
+		PreviousHash:     fmt.Sprintf("%x", b.previousHash),
+		TransactionsRoot: fmt.Sprintf("%x", b.transactionsRoot),
+		StateRoot:        fmt.Sprintf("%x", b.stateRoot),
+	}
+}
+
 func (b *Block) Print() {
 	fmt.Printf("timestamp       %d\n", b.timestamp)
 	fmt.Printf("nonce           %d\n", b.nonce)
 	fmt.Printf("previous_hash   %x\n", b.previousHash)
+	fmt.Printf("state_root      %x\n", b.stateRoot)
 	for _, t := range b.transactions {
 		t.Print()
 	}
 }
 
 func (b *Block) Hash() [32]byte {
-	m, _ := json.Marshal(b)
-	return sha256.Sum256([]byte(m))
+	return b.Header().Hash()
 }
 
 func (b *Block) MarshalJSON() ([]byte, error) {
@@ -130,30 +224,42 @@ func (b *Block) MarshalJSON() ([]byte, error) {
 		Timestamp int64 `json:"timestamp"`
 		Nonce     int   `json:"nonce"`
 		// PreviousHash [32]byte       `json:"previous_hash"`
-		PreviousHash string         `json:"previous_hash"`
-		Transactions []*Transaction `json:"transactions"`
+		PreviousHash     string         `json:"previous_hash"`
+		TransactionsRoot string         `json:"transactions_root"`
+		StateRoot        string         `json:"state_root"`
+		Transactions     []*Transaction `json:"transactions"`
+		Pruned           bool           `json:"pruned,omitempty"`
 	}{
-		Timestamp:    b.timestamp,
-		Nonce:        b.nonce,
-		PreviousHash: fmt.Sprintf("%x", b.previousHash),
-		Transactions: b.transactions,
+		Timestamp:        b.timestamp,
+		Nonce:            b.nonce,
+		PreviousHash:     fmt.Sprintf("%x", b.previousHash),
+		TransactionsRoot: fmt.Sprintf("%x", b.transactionsRoot),
+		StateRoot:        fmt.Sprintf("%x", b.stateRoot),
+		Transactions:     b.transactions,
+		Pruned:           b.pruned,
 	})
 }
 
 func (b *Block) UnmarshalJSON(data []byte) error {
 
-	var previousHash string
+	var previousHash, transactionsRoot, stateRoot string
 
 	v := &struct {
-		Timestamp    *int64          `json:"timestamp"`
-		Nonce        *int            `json:"nonce"`
-		PreviousHash *string         `json:"previous_hash"`
-		Transactions *[]*Transaction `json:"transactions"`
+		Timestamp        *int64          `json:"timestamp"`
+		Nonce            *int            `json:"nonce"`
+		PreviousHash     *string         `json:"previous_hash"`
+		TransactionsRoot *string         `json:"transactions_root"`
+		StateRoot        *string         `json:"state_root"`
+		Transactions     *[]*Transaction `json:"transactions"`
+		Pruned           *bool           `json:"pruned"`
 	}{
-		Timestamp:    &b.timestamp,
-		Nonce:        &b.nonce,
-		PreviousHash: &previousHash,
-		Transactions: &b.transactions,
+		Timestamp:        &b.timestamp,
+		Nonce:            &b.nonce,
+		PreviousHash:     &previousHash,
+		TransactionsRoot: &transactionsRoot,
+		StateRoot:        &stateRoot,
+		Transactions:     &b.transactions,
+		Pruned:           &b.pruned,
 	}
 
 	if err := json.Unmarshal(data, &v); err != nil {
@@ -161,18 +267,44 @@ func (b *Block) UnmarshalJSON(data []byte) error {
 	}
 
 	ph, _ := hex.DecodeString(*v.PreviousHash)
-	copy(b.previousHash[:], ph[:32])
+	copy(b.previousHash[:], ph)
+	tr, _ := hex.DecodeString(transactionsRoot)
+	copy(b.transactionsRoot[:], tr)
+	sr, _ := hex.DecodeString(stateRoot)
+	copy(b.stateRoot[:], sr)
 
 	return nil
 }
 
+// Chain returns a snapshot of the chain. Blocks are never modified once
+// appended, so the snapshot can be read without holding the lock.
 func (bc *Blockchain) Chain() []*Block {
-	return bc.chain
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	chain := make([]*Block, len(bc.chain))
+	copy(chain, bc.chain)
+	return chain
+}
+
+// Genesis returns the genesis configuration of the network. It must not be
+// modified.
+func (bc *Blockchain) Genesis() *Genesis {
+	return bc.genesis
+}
+
+func (bc *Blockchain) GenesisHash() [32]byte {
+	return bc.genesisHash
+}
+
+func (bc *Blockchain) Network() config.Network {
+	return bc.network
 }
 
 func (bc *Blockchain) Run() {
 	bc.StartSyncNodes()
 	bc.ResolveConflicts() //when connected it should be resolved
+	bc.LoadTransactionPool()
+	bc.StartMempoolJanitor()
 	bc.StartMining()
 }
 
@@ -182,87 +314,125 @@ func (bc *Blockchain) SetNodes() {
 	// 	NODE_IP_RANGE_START, NODE_IP_RANGE_END,
 	// 	BLOCKCHAIN_PORT_RANGE_START, BLOCKCHAIN_PORT_RANGE_END)
 	// log.Printf("%v", bc.nodes)
-	bc.nodes = utils.FindP2P(
+	nodes := utils.FindP2P(
 		"127.0.0.1", bc.port,
 		bc.conf.IpRangeStart, bc.conf.IpRangeEnd,
 		bc.conf.PortRangeStart, bc.conf.PortRangeEnd)
-	log.Printf("%v", bc.nodes)
+
+	self := fmt.Sprintf("127.0.0.1:%d", bc.port)
+	known := make(map[string]bool)
+	for _, n := range nodes {
+		known[n] = true
+	}
+	for _, seed := range append(bc.network.SeedPeers, bc.conf.SeedPeers...) {
+		if seed != "" && seed != self && !known[seed] {
+			known[seed] = true
+			nodes = append(nodes, seed)
+		}
+	}
+
+	// The handshake drops peers of other networks and learns which block
+	// bodies the others still have.
+	peers := make([]string, 0, len(nodes))
+	prunedHeights := make(map[string]int64)
+	for _, n := range nodes {
+		prunedHeight, err := bc.handshake(n)
+		if err != nil {
+			log.Printf("ERROR: handshake with %s: %v", n, err)
+			continue
+		}
+		peers = append(peers, n)
+		prunedHeights[n] = prunedHeight
+	}
+
+	bc.muxNodes.Lock()
+	bc.nodes = peers
+	bc.prunedHeights = prunedHeights
+	bc.muxNodes.Unlock()
+	log.Printf("%v", peers)
 }
 
 func (bc *Blockchain) SyncNodes() {
-	bc.muxNodes.Lock()
-	defer bc.muxNodes.Unlock()
 	bc.SetNodes()
 }
 
+func (bc *Blockchain) Nodes() []string {
+	bc.muxNodes.RLock()
+	defer bc.muxNodes.RUnlock()
+	nodes := make([]string, len(bc.nodes))
+	copy(nodes, bc.nodes)
+	return nodes
+}
+
 func (bc *Blockchain) StartSyncNodes() {
 	bc.SyncNodes()
 	_ = time.AfterFunc(time.Second*bc.conf.NodeSyncTimeSec, bc.StartSyncNodes)
 }
 
 func (bc *Blockchain) TransactionPool() []*Transaction {
-	return bc.transactionPool
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	transactions := make([]*Transaction, len(bc.transactionPool))
+	copy(transactions, bc.transactionPool)
+	return transactions
 }
 
 func (bc *Blockchain) ClearTransactionPool() {
-	bc.transactionPool = bc.transactionPool[:0] //empty
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.transactionPool = []*Transaction{} //empty
+	bc.saveTransactionPool()
 }
 
 func (bc *Blockchain) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Blocks []*Block `json:"chain"`
 	}{
-		Blocks: bc.chain,
+		Blocks: bc.Chain(),
 	})
 }
 
 func (bc *Blockchain) UnmarshalJSON(data []byte) error {
+	var chain []*Block
+
 	v := &struct {
 		Blocks *[]*Block `json:"chain"`
 	}{
-		Blocks: &bc.chain,
+		Blocks: &chain,
 	}
 
 	if err := json.Unmarshal(data, &v); err != nil {
 		return err
 	}
 
+	bc.mux.Lock()
+	bc.chain = chain
+	bc.mux.Unlock()
+
 	return nil
 }
 
-func (bc *Blockchain) CreateBlock(nonce int, previousHash [32]byte) *Block {
-	b := NewBlock(nonce, previousHash, bc.transactionPool)
-	bc.chain = append(bc.chain, b)
-	bc.transactionPool = []*Transaction{}
-	bc.UpdateLastHash()
-
-	for _, n := range bc.nodes {
-
-		endpoint := fmt.Sprintf("http://%s/transactions", n)
-		client := &http.Client{}
-
-		req, err := http.NewRequest("DELETE", endpoint, nil)
-
-		if err != nil {
-			log.Printf("ERROR: %v", err)
-		}
-
-		resp, err := client.Do(req)
-
-		if err != nil {
-			log.Printf("ERROR: %v", err)
-		}
-
-		log.Printf("Response: %v", resp)
-
-	}
+func (bc *Blockchain) AppendBlock(b *Block) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.appendBlock(b)
+}
 
-	return b
+func (bc *Blockchain) appendBlock(b *Block) {
+	bc.chain = append(bc.chain, b)
+	bc.updateLastHash()
+	bc.executeBlock(int64(len(bc.chain) - 1))
 }
 
 func (bc *Blockchain) UpdateLastHash() bool {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.updateLastHash()
+}
+
+func (bc *Blockchain) updateLastHash() bool {
 	err := bc.db.Update(func(txn *badger.Txn) error {
-		lastHash := bc.LastBlock().Hash()
+		lastHash := bc.lastBlock().Hash()
 		fmt.Println("last hash: ", fmt.Sprintf("%x", lastHash))
 		err := txn.Set([]byte("lh"), lastHash[:])
 		if err != nil {
@@ -280,11 +450,17 @@ func (bc *Blockchain) UpdateLastHash() bool {
 }
 
 func (bc *Blockchain) LastBlock() *Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.lastBlock()
+}
+
+func (bc *Blockchain) lastBlock() *Block {
 	return bc.chain[len(bc.chain)-1]
 }
 
 func (bc *Blockchain) Print() {
-	for i, block := range bc.chain {
+	for i, block := range bc.Chain() {
 		fmt.Printf("%s Chain %d %s\n", strings.Repeat("=", 25), i,
 			strings.Repeat("=", 25))
 		block.Print()
@@ -292,117 +468,368 @@ func (bc *Blockchain) Print() {
 	fmt.Printf("%s\n", strings.Repeat("*", 25))
 }
 
-func (bc *Blockchain) Createransaction(sender string, recipient string, token Token,
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	isTransacted := bc.AddTransaction(sender, recipient, token, senderPublicKey, s)
+func (bc *Blockchain) Createransaction(t *Transaction) error {
+	if err := bc.AddTransaction(t); err != nil {
+		return err
+	}
 
-	if isTransacted {
-		for _, n := range bc.nodes {
-			publicKeyStr := fmt.Sprintf("%064x%064x", senderPublicKey.X.Bytes(), senderPublicKey.Y.Bytes())
-			signatureStr := s.String()
-			bt := &TransactionRequest{&sender, &recipient, &publicKeyStr, &token.TokenName, &token.TokenValue, &signatureStr}
-			m, err := json.Marshal(bt)
+	for _, n := range bc.Nodes() {
+		bt := t.Request()
+		m, err := json.Marshal(bt)
 
-			if err != nil {
-				log.Printf("ERROR: %v", err)
-			}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+
+		buf := bytes.NewBuffer(m)
+		endpoint := fmt.Sprintf("http://%s/transactions", n)
+		client := &http.Client{}
+
+		req, err := http.NewRequest("PUT", endpoint, buf)
+
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+		}
 
-			buf := bytes.NewBuffer(m)
-			endpoint := fmt.Sprintf("http://%s/transactions", n)
-			client := &http.Client{}
+		resp, err := client.Do(req)
+
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+		}
 
-			req, err := http.NewRequest("PUT", endpoint, buf)
+		log.Printf("Response: %v", resp)
+	}
 
-			if err != nil {
-				log.Printf("ERROR: %v", err)
+	return nil
+}
+
+func (bc *Blockchain) AddTransaction(t *Transaction) error {
+	if t.addedAt.IsZero() {
+		t.addedAt = time.Now()
+	}
+
+	if t.senderBlockchainAddress == bc.conf.MiningSender || t.senderBlockchainAddress == GenesisSender {
+		log.Printf("ERROR: %v", ErrReservedSender)
+		return ErrReservedSender
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	id := t.ID()
+	for _, p := range bc.transactionPool {
+		if p.ID() == id {
+			log.Printf("ERROR: %v", ErrDuplicateTransaction)
+			return ErrDuplicateTransaction
+		}
+	}
+
+	if err := bc.validateTransaction(t, bc.transactionPool); err != nil {
+		log.Printf("ERROR: %v", err)
+		return err
+	}
+
+	bc.transactionPool = append(bc.transactionPool, t)
+	bc.saveTransactionPool()
+	return nil
+}
+
+// ValidateTransaction checks t against the current tip, counting what the
+// sender already spends in the transaction pool.
+func (bc *Blockchain) ValidateTransaction(t *Transaction) error {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.validateTransaction(t, bc.transactionPool)
+}
+
+// validateTransaction checks t as if it was mined after the pooled
+// transactions.
+func (bc *Blockchain) validateTransaction(t *Transaction, pool []*Transaction) error {
+	if err := bc.verifyTransaction(t); err != nil {
+		return err
+	}
+
+	if t.Expired(int64(len(bc.chain)), time.Now()) {
+		return ErrTransactionExpired
+	}
+
+	if bc.isIncluded(t.ID()) {
+		return ErrAlreadyIncluded
+	}
+
+	if err := bc.validateToken(t, bc.tokens(bc.chain)); err != nil {
+		return err
+	}
+	if t.txType == TxIssueToken {
+		for _, p := range pool {
+			if p.txType == TxIssueToken && p.token.TokenName == t.token.TokenName {
+				return ErrTokenExists
 			}
+		}
+	}
+
+	if err := validateHTLC(t, bc.htlcs(bc.chain), int64(len(bc.chain))); err != nil {
+		return err
+	}
+	if isHTLCSettle(t.txType) {
+		for _, p := range pool {
+			if isHTLCSettle(p.txType) && p.settle.ID == t.settle.ID {
+				return ErrHTLCSettled
+			}
+		}
+	}
 
-			resp, err := client.Do(req)
+	if err := bc.validateEscrow(t, bc.escrows(bc.chain)); err != nil {
+		return err
+	}
+	if isEscrowSettle(t.txType) {
+		for _, p := range pool {
+			if isEscrowSettle(p.txType) && p.escrowID == t.escrowID {
+				return ErrEscrowSettled
+			}
+		}
+	}
 
-			if err != nil {
-				log.Printf("ERROR: %v", err)
+	if isContract(t.txType) {
+		contracts := bc.contracts(bc.chain)
+		if err := validateContract(t, contracts); err != nil {
+			return err
+		}
+		// Calls that would fail at the tip are not accepted. Calls mined
+		// before may still make it fail in its block.
+		if t.txType == TxCall {
+			if res := execute(t, contracts, dbStorage{bc.db}, int64(len(bc.chain))); res.Err != nil {
+				return fmt.Errorf("%w: %v", ErrCallFailed, res.Err)
 			}
+		}
+	}
+
+	if t.nonce <= bc.lastNonce(bc.chain, pool, t.senderBlockchainAddress) {
+		return ErrNonceTooLow
+	}
 
-			log.Printf("Response: %v", resp)
+	pending := bc.pendingSpends(pool)
+	for key, spend := range bc.pendingSpends([]*Transaction{t}) {
+		address, tokenName := splitSpendKey(key)
+		balance := bc.calculateTotalAmount(address, tokenName).Sub(pending[key])
+		if !balance.GreaterThan(spend) {
+			return ErrInsufficientBalance
+		}
+		if !balance.Sub(bc.immature(bc.chain, address, tokenName)).GreaterThan(spend) {
+			return ErrImmatureReward
 		}
 	}
 
-	return isTransacted
+	return nil
 }
 
-func (bc *Blockchain) AddTransaction(sender string, recipient string, token Token,
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	t := NewTransaction(sender, recipient, token)
+// validateAddress accepts single key and multisig addresses of this network.
+func (bc *Blockchain) validateAddress(address string) error {
+	return utils.ValidateAddress(address, bc.network.AddressVersion, bc.network.MultisigVersion)
+}
 
-	if sender == bc.conf.MiningSender {
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
+// verifyTransaction checks the addresses of t against this network and that
+// it is signed by the key behind the sender address, or by enough keys of a
+// multisig sender.
+func (bc *Blockchain) verifyTransaction(t *Transaction) error {
+	switch t.txType {
+	case TxTransfer, TxIssueToken, TxMint:
+		if err := bc.validateAddress(t.recipientBlockchainAddress); err != nil {
+			return fmt.Errorf("recipient: %w", err)
+		}
+	case TxBurn:
+		if t.recipientBlockchainAddress != "" {
+			return errors.New("recipient: a burn has no recipient")
+		}
+	case TxMultiTransfer:
+		if err := bc.verifyOutputs(t); err != nil {
+			return err
+		}
+	case TxSwap:
+		if err := bc.verifySwap(t); err != nil {
+			return err
+		}
+	case TxHTLCLock:
+		if err := bc.validateAddress(t.recipientBlockchainAddress); err != nil {
+			return fmt.Errorf("recipient: %w", err)
+		}
+	case TxEscrowLock, TxEscrowRelease, TxEscrowRefund:
+		if err := bc.verifyEscrow(t); err != nil {
+			return err
+		}
+	case TxDeploy, TxCall:
+		if err := bc.verifyContract(t); err != nil {
+			return err
+		}
+	case TxHTLCClaim, TxHTLCRefund:
+		if t.recipientBlockchainAddress != "" {
+			return errors.New("recipient: the htlc pays out to the sender")
+		}
+		if t.settle == nil {
+			return fmt.Errorf("%w: htlc_id is missing", ErrInvalidHTLC)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownTransactionType, t.txType)
 	}
 
-	if bc.VerifyTransactionSignature(senderPublicKey, s, t) {
+	if t.nonce == 0 {
+		return ErrMissingNonce
+	}
 
-		if !bc.CalculateTotalAmount(sender, token.TokenName).GreaterThan(token.TokenValue) {
-			log.Println("ERROR: Not enough balance in a wallet")
-			return false
-		}
+	if err := verifyLock(t); err != nil {
+		return err
+	}
 
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
-	} else {
-		log.Println("ERROR: Verify Transaction")
+	if t.multisig != nil {
+		return bc.verifyMultisig(t)
+	}
+
+	if t.senderPublicKey == nil || t.signature == nil {
+		return ErrInvalidSignature
 	}
-	return false
 
+	if utils.AddressFromPublicKey(t.senderPublicKey, bc.network.AddressVersion) != t.senderBlockchainAddress {
+		return ErrSenderMismatch
+	}
+
+	return bc.VerifyTransactionSignature(t.senderPublicKey, t.signature, t)
 }
 
+// VerifyTransactionSignature checks that t was signed by senderPublicKey for
+// the chain this node runs.
 func (bc *Blockchain) VerifyTransactionSignature(
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *Transaction) bool {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
-	return ecdsa.Verify(senderPublicKey, h[:], s.R, s.S)
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *Transaction) error {
+	if t.chainID != bc.network.ChainID {
+		return fmt.Errorf("%w: transaction is signed for chain %d, this node runs %s (chain %d)",
+			ErrWrongChain, t.chainID, bc.network.Name, bc.network.ChainID)
+	}
+	if !s.IsLowS() {
+		return fmt.Errorf("%w: S must be in the lower half of the curve order", ErrInvalidSignature)
+	}
+	h := t.SigningHash()
+	if !ecdsa.Verify(senderPublicKey, h[:], s.R, s.S) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// IsIncluded reports whether a transaction with the given ID is already part
+// of the chain.
+func (bc *Blockchain) IsIncluded(id string) bool {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.isIncluded(id)
+}
+
+func (bc *Blockchain) isIncluded(id string) bool {
+	_, start := bc.base(bc.chain)
+	for _, b := range bc.chain[start:] {
+		for _, t := range b.transactions {
+			if t.ID() == id {
+				return true
+			}
+		}
+	}
+	if start == 0 {
+		return false
+	}
+	// Below the snapshot the receipt index records the mined transactions.
+	height, err := bc.receiptHeight(id)
+	return err == nil && height < int64(start)
 }
 
 func (bc *Blockchain) CopyTransactionPool() []*Transaction {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
 	transactions := make([]*Transaction, 0)
 	for _, t := range bc.transactionPool {
-		transactions = append(transactions, NewTransaction(t.senderBlockchainAddress, t.recipientBlockchainAddress, t.token))
+		c := *t
+		transactions = append(transactions, &c)
 	}
 	return transactions
 }
 
-func (bc *Blockchain) ValidProof(nonce int, previousHash [32]byte, transactions []*Transaction, difficulty int) bool {
-	zeros := strings.Repeat("0", difficulty)
-	guessBlock := Block{0, nonce, previousHash, transactions}
-	guessHashStr := fmt.Sprintf("%x", guessBlock.Hash())
-	return guessHashStr[:difficulty] == zeros
-}
-
-func (bc *Blockchain) ProofOfWork() int {
-	transactions := bc.CopyTransactionPool()
-	previousHash := bc.LastBlock().Hash()
-	nonce := 0
-	for !bc.ValidProof(nonce, previousHash, transactions, bc.conf.Difficulty) {
-		nonce += 1
+// ProofOfWork finds the nonce that makes the header of b valid.
+func (bc *Blockchain) ProofOfWork(b *Block) int {
+	h := b.Header()
+	for !h.ValidProof(bc.genesis.Difficulty) {
+		h.Nonce++
 	}
-	return nonce
+	return h.Nonce
 }
 
 func (bc *Blockchain) Mining() bool {
 
-	bc.mux.Lock()
-	defer bc.mux.Unlock()
+	bc.muxMining.Lock()
+	defer bc.muxMining.Unlock()
+
+	if bc.blockchainAddress == "" {
+		log.Println("ERROR: no miner reward address configured, refusing to mine")
+		return false
+	}
 
 	// if len(bc.transactionPool) == 0 {
 	// 	return false
 	// }
 
-	bc.AddTransaction(bc.conf.MiningSender, bc.blockchainAddress, Token{TokenName: bc.conf.DefaultRewardToken, TokenValue: utils.FloatToDecimal(bc.conf.MiningReward)}, nil, nil)
-	nonce := bc.ProofOfWork()
-	previousHash := bc.LastBlock().Hash()
-	bc.CreateBlock(nonce, previousHash)
+	bc.EvictTransactions()
+
+	// The work is done on a snapshot so that the pool and the chain stay
+	// available while mining. Transactions arriving meanwhile wait for the
+	// next block.
+	transactions := bc.CopyTransactionPool()
+	schedule := bc.genesis.RewardSchedule
+
+	bc.mux.RLock()
+	timestamp := blockTime(bc.lastBlock(), time.Now())
+	// Time-locked transactions stay in the pool until they can be mined.
+	transactions = unlocked(transactions, int64(len(bc.chain)), time.Unix(0, timestamp))
+	transactions = withinGasLimit(transactions)
+	previousHash := bc.lastBlock().Hash()
+	height := int64(len(bc.chain))
+	reward := schedule.Reward(height, bc.supply(bc.chain)[schedule.Token])
+	balances := bc.balances(bc.chain)
+	contracts := bc.contracts(bc.chain)
+	storage, err := bc.loadStorage()
+	bc.mux.RUnlock()
+	if err != nil {
+		log.Printf("ERROR: could not read contract storage: %v", err)
+		return false
+	}
+
+	// Calls are run first, the coinbase collects the fees for the gas they
+	// used. The transactions are copies, the pool is left untouched.
+	fees := decimal.Zero
+	for _, t := range transactions {
+		if res := bc.applyContract(contracts, storage, t, height); res != nil {
+			t.gasUsed = res.GasUsed
+			fees = fees.Add(bc.genesis.Fee(res.GasUsed))
+		}
+	}
+	if reward.IsPositive() || fees.IsPositive() {
+		coinbase := NewTransaction(bc.conf.MiningSender, bc.blockchainAddress, Token{TokenName: schedule.Token, TokenValue: reward})
+		coinbase.chainID = bc.network.ChainID
+		coinbase.decimals = NativeTokenDecimals
+		coinbase.fee = fees
+		transactions = append(transactions, coinbase)
+	}
+	bc.applyBalances(balances, transactions)
+	b := NewBlock(0, previousHash, newStateTree(balances, contracts, storage.writes).root(), transactions)
+	b.timestamp = timestamp
+	b.nonce = bc.ProofOfWork(b)
+
+	bc.mux.Lock()
+	if bc.lastBlock().Hash() != previousHash {
+		bc.mux.Unlock()
+		log.Println("action=mining, status=stale")
+		return false
+	}
+	bc.appendBlock(b)
+	bc.reconcileTransactionPool([]*Block{b}, nil)
+	bc.mux.Unlock()
 	log.Println("action=mining, status=success")
 
-	for _, n := range bc.nodes {
+	for _, n := range bc.Nodes() {
 		endpoint := fmt.Sprintf("http://%s/consensus", n)
 		client := &http.Client{}
 		req, err := http.NewRequest("PUT", endpoint, nil)
@@ -430,40 +857,93 @@ func (bc *Blockchain) StartMining() {
 }
 
 func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string, tokenName string) decimal.Decimal {
-	totalAmount := decimal.New(0.0, 0)
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.calculateTotalAmount(blockchainAddress, tokenName)
+}
+
+func (bc *Blockchain) calculateTotalAmount(blockchainAddress string, tokenName string) decimal.Decimal {
+	s, start := bc.base(bc.chain)
+	totalAmount := decimal.New(0.0, 0).Add(s.Balances[spendKey(blockchainAddress, tokenName)])
 
-	for _, b := range bc.chain {
+	for _, b := range bc.chain[start:] {
 		for _, t := range b.transactions {
-			if blockchainAddress == t.recipientBlockchainAddress {
-				if tokenName == t.token.TokenName {
-					totalAmount = totalAmount.Add(t.token.TokenValue)
+			for _, e := range bc.entries(t) {
+				if e.address == blockchainAddress && e.tokenName == tokenName {
+					totalAmount = totalAmount.Add(e.amount)
 				}
 			}
+		}
+	}
 
-			if blockchainAddress == t.senderBlockchainAddress {
-				if tokenName == t.token.TokenName {
-					totalAmount = totalAmount.Sub(t.token.TokenValue)
-				}
-			}
+	return totalAmount
+}
+
+// immature sums the mining rewards and fees paid to blockchainAddress that
+// cannot be spent yet in the block following chain.
+func (bc *Blockchain) immature(chain []*Block, blockchainAddress string, tokenName string) decimal.Decimal {
+	amount := decimal.Zero
+	start := int64(len(chain)) - bc.genesis.CoinbaseMaturity + 1
+	if start < 1 {
+		start = 1
+	}
+	if start > int64(len(chain)) {
+		return amount
+	}
 
+	for _, b := range chain[start:] {
+		for _, t := range b.transactions {
+			if t.senderBlockchainAddress == bc.conf.MiningSender &&
+				t.recipientBlockchainAddress == blockchainAddress && t.token.TokenName == tokenName {
+				amount = amount.Add(t.token.TokenValue).Add(t.fee)
+			}
 		}
 	}
+	return amount
+}
 
-	return totalAmount
+// Balance splits the amount of a token held by an address into what can be
+// spent in the next block and mining rewards that have not matured yet.
+type Balance struct {
+	TokenName  string          `json:"token_name"`
+	TokenValue decimal.Decimal `json:"token_value"`
+	Spendable  decimal.Decimal `json:"spendable"`
+	Immature   decimal.Decimal `json:"immature"`
 }
 
-func (bc *Blockchain) CalculateAllAmounts(blockchainAddress string) []*Token {
+func (bc *Blockchain) CalculateAllAmounts(blockchainAddress string) []*Balance {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
 
-	tokens := make(map[string]*Token, 0)
+	tokens := make(map[string]*Balance, 0)
+	add := func(tokenName string) {
+		if _, ok := tokens[tokenName]; ok {
+			return
+		}
+		totalAmount := bc.calculateTotalAmount(blockchainAddress, tokenName)
+		immature := bc.immature(bc.chain, blockchainAddress, tokenName)
+		tokens[tokenName] = &Balance{
+			TokenName:  tokenName,
+			TokenValue: totalAmount,
+			Spendable:  totalAmount.Sub(immature),
+			Immature:   immature,
+		}
+	}
 
-	for _, b := range bc.chain {
+	s, start := bc.base(bc.chain)
+	for key := range s.Balances {
+		_, tokenName := splitSpendKey(key)
+		add(tokenName)
+	}
+	for _, b := range bc.chain[start:] {
 		for _, t := range b.transactions {
-			totalAmount := bc.CalculateTotalAmount(blockchainAddress, t.token.TokenName)
-			tokens[t.token.TokenName] = &Token{TokenName: t.token.TokenName, TokenValue: totalAmount}
+			for _, e := range bc.entries(t) {
+				add(e.tokenName)
+			}
 		}
 	}
 
-	tokenSlice := make([]*Token, len(tokens))
+	tokenSlice := make([]*Balance, len(tokens))
 	i := 0
 	for _, v := range tokens {
 		tokenSlice[i] = v
@@ -473,20 +953,252 @@ func (bc *Blockchain) CalculateAllAmounts(blockchainAddress string) []*Token {
 	return tokenSlice
 }
 
+// Supply is the amount of a token in circulation. Cap and Remaining are only
+// set for tokens with a limited supply.
+type Supply struct {
+	TokenName   string           `json:"token_name"`
+	Circulating decimal.Decimal  `json:"circulating"`
+	Cap         *decimal.Decimal `json:"cap,omitempty"`
+	Remaining   *decimal.Decimal `json:"remaining,omitempty"`
+}
+
+// supply sums the balance changes of chain per token. Transfers cancel out,
+// leaving what was allocated in genesis, mined and issued.
+func (bc *Blockchain) supply(chain []*Block) map[string]decimal.Decimal {
+	supply := make(map[string]decimal.Decimal)
+	s, start := bc.base(chain)
+	for key, balance := range s.Balances {
+		_, tokenName := splitSpendKey(key)
+		supply[tokenName] = supply[tokenName].Add(balance)
+	}
+	for _, b := range chain[start:] {
+		for _, t := range b.transactions {
+			for _, e := range bc.entries(t) {
+				supply[e.tokenName] = supply[e.tokenName].Add(e.amount)
+			}
+		}
+	}
+	return supply
+}
+
+// Supply reports every registered token, ordered by name. Apart from the
+// reward token only tokens with a mint authority can grow, the supply of the
+// others is fixed.
+func (bc *Blockchain) Supply() []*Supply {
+	bc.mux.RLock()
+	circulating := bc.supply(bc.chain)
+	tokens := bc.tokens(bc.chain)
+	bc.mux.RUnlock()
+
+	schedule := bc.genesis.RewardSchedule
+	names := make([]string, 0, len(tokens))
+	for name := range tokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	supplies := make([]*Supply, 0, len(names))
+	for _, name := range names {
+		s := &Supply{TokenName: name, Circulating: circulating[name]}
+		switch {
+		case name != schedule.Token && tokens[name].MintAuthority == "":
+			remaining := decimal.Zero
+			s.Cap, s.Remaining = &s.Circulating, &remaining
+		case schedule.SupplyCap.IsPositive():
+			remaining := decimal.Max(schedule.SupplyCap.Sub(s.Circulating), decimal.Zero)
+			s.Cap, s.Remaining = &schedule.SupplyCap, &remaining
+		}
+		supplies = append(supplies, s)
+	}
+	return supplies
+}
+
 func (bc *Blockchain) ValidChain(chain []*Block) bool { //what if later on?
-	preBlock := chain[0]
-	currentIndex := 1
+	if len(chain) == 0 || chain[0].Hash() != bc.genesisHash {
+		log.Println("ERROR: chain does not start with our genesis block")
+		return false
+	}
+
+	// A chain that contains the block of our snapshot is validated from
+	// there, the blocks below only by their headers.
+	schedule := bc.genesis.RewardSchedule
+	bc.mux.RLock()
+	s, start := bc.base(chain)
+	circulating := bc.supply(chain[:start])[schedule.Token]
+	bc.mux.RUnlock()
+
+	tokens := cloneInfos(s.Tokens)
+	htlcs := cloneInfos(s.HTLCs)
+	escrows := cloneInfos(s.Escrows)
+	contracts := cloneInfos(s.Contracts)
+	storage := s.storage()
+	nonces := copyMap(s.Nonces)
+	// Transactions below start cannot come again, their nonces are spent.
+	included := make(map[string]bool)
+	balances := copyMap(s.Balances)
+	if start == 0 {
+		circulating = bc.genesis.Allocated(schedule.Token)
+		for _, t := range chain[0].transactions {
+			key := spendKey(t.recipientBlockchainAddress, t.token.TokenName)
+			balances[key] = balances[key].Add(t.token.TokenValue)
+		}
+		if chain[0].transactionsRoot != TransactionsRoot(chain[0].transactions) {
+			log.Println("ERROR: genesis block does not match its transactions root")
+			return false
+		}
+		start = 1
+	} else if newStateTree(balances, contracts, storage.writes).root() != chain[start-1].stateRoot {
+		log.Printf("ERROR: state snapshot does not match block %d", start-1)
+		return false
+	}
+
+	for currentIndex := 1; currentIndex < len(chain); currentIndex++ {
+		b := chain[currentIndex]
+		if b.previousHash != chain[currentIndex-1].Hash() {
+			return false
+		}
+
+		if !b.Header().ValidProof(bc.genesis.Difficulty) {
+			return false
+		}
+
+		if err := verifyTimestamp(b, chain[currentIndex-1], time.Now()); err != nil {
+			log.Printf("ERROR: block %d: %v", currentIndex, err)
+			return false
+		}
+	}
+
+	currentIndex := start
 	for currentIndex < len(chain) { //TODO: last longest chain in given time
 		b := chain[currentIndex]
-		if b.previousHash != preBlock.Hash() {
+		if b.pruned {
+			log.Printf("ERROR: block %d: %v", currentIndex, ErrPruned)
+			return false
+		}
+
+		if b.transactionsRoot != TransactionsRoot(b.transactions) {
+			log.Printf("ERROR: block %d does not match its transactions root", currentIndex)
+			return false
+		}
+
+		if blockGas(b.transactions) > BlockGasLimit {
+			log.Printf("ERROR: block %d: %v", currentIndex, ErrBlockGasLimit)
+			return false
+		}
+
+		expectedReward := schedule.Reward(int64(currentIndex), circulating)
+		rewarded := false
+		// The coinbase has to pay out exactly the fees of the calls.
+		fees, paid := decimal.Zero, decimal.Zero
+		for _, t := range b.transactions {
+			if t.Expired(int64(currentIndex), time.Unix(0, b.timestamp)) {
+				log.Printf("ERROR: block %d contains an expired transaction", currentIndex)
+				return false
+			}
+			if t.Locked(int64(currentIndex), time.Unix(0, b.timestamp)) {
+				log.Printf("ERROR: block %d contains a time-locked transaction", currentIndex)
+				return false
+			}
+
+			if t.senderBlockchainAddress == GenesisSender {
+				log.Printf("ERROR: block %d contains a genesis allocation", currentIndex)
+				return false
+			}
+
+			if t.senderBlockchainAddress == bc.conf.MiningSender {
+				if rewarded || t.token.TokenName != schedule.Token || t.decimals != NativeTokenDecimals ||
+					t.token.TokenValue.IsNegative() || t.token.TokenValue.GreaterThan(expectedReward) ||
+					t.gasUsed != 0 || t.fee.IsNegative() || (t.token.TokenValue.IsZero() && !t.fee.IsPositive()) {
+					log.Printf("ERROR: block %d mints more than the reward of %s %s", currentIndex, expectedReward, schedule.Token)
+					return false
+				}
+				rewarded = true
+				paid = t.fee
+				circulating = circulating.Add(t.token.TokenValue)
+
+				if err := utils.ValidateAddress(t.recipientBlockchainAddress, bc.network.AddressVersion); err != nil {
+					log.Printf("ERROR: block %d pays its reward to an invalid address: %v", currentIndex, err)
+					return false
+				}
+				continue
+			}
+
+			if err := bc.verifyTransaction(t); err != nil {
+				log.Printf("ERROR: block %d contains an invalid transaction: %v", currentIndex, err)
+				return false
+			}
+			id := t.ID()
+			if included[id] {
+				log.Printf("ERROR: block %d contains an invalid transaction: %v", currentIndex, ErrAlreadyIncluded)
+				return false
+			}
+			included[id] = true
+
+			if err := bc.validateToken(t, tokens); err != nil {
+				log.Printf("ERROR: block %d contains an invalid transaction: %v", currentIndex, err)
+				return false
+			}
+			bc.applyToken(tokens, t, int64(currentIndex))
+
+			if err := validateHTLC(t, htlcs, int64(currentIndex)); err != nil {
+				log.Printf("ERROR: block %d contains an invalid transaction: %v", currentIndex, err)
+				return false
+			}
+			applyHTLC(htlcs, t, int64(currentIndex))
+
+			if err := bc.validateEscrow(t, escrows); err != nil {
+				log.Printf("ERROR: block %d contains an invalid transaction: %v", currentIndex, err)
+				return false
+			}
+			applyEscrow(escrows, t, int64(currentIndex))
+
+			if err := validateContract(t, contracts); err != nil {
+				log.Printf("ERROR: block %d contains an invalid transaction: %v", currentIndex, err)
+				return false
+			}
+			var gasUsed uint64
+			if res := bc.applyContract(contracts, storage, t, int64(currentIndex)); res != nil {
+				gasUsed = res.GasUsed
+			}
+			if t.gasUsed != gasUsed || !t.fee.IsZero() {
+				log.Printf("ERROR: block %d contains a transaction that does not report the gas it used", currentIndex)
+				return false
+			}
+			fees = fees.Add(bc.genesis.Fee(gasUsed))
+
+			if t.nonce <= nonces[t.senderBlockchainAddress] {
+				log.Printf("ERROR: block %d contains an invalid transaction: %v", currentIndex, ErrNonceTooLow)
+				return false
+			}
+			nonces[t.senderBlockchainAddress] = t.nonce
+
+			for _, d := range bc.debits(t) {
+				from := spendKey(d.address, d.tokenName)
+				balances[from] = balances[from].Add(d.amount)
+				if balances[from].Sub(bc.immature(chain[:currentIndex], d.address, d.tokenName)).IsNegative() {
+					log.Printf("ERROR: block %d spends more than %s can spend", currentIndex, d.address)
+					return false
+				}
+			}
+		}
+		if !paid.Equal(fees) {
+			log.Printf("ERROR: block %d pays out %s %s in fees instead of %s", currentIndex, paid, schedule.Token, fees)
 			return false
 		}
 
-		if !bc.ValidProof(b.Nonce(), b.previousHash, b.transactions, bc.conf.Difficulty) {
+		for _, t := range b.transactions {
+			for _, e := range bc.entries(t) {
+				if e.amount.IsPositive() {
+					to := spendKey(e.address, e.tokenName)
+					balances[to] = balances[to].Add(e.amount)
+				}
+			}
+		}
+		if newStateTree(balances, contracts, storage.writes).root() != b.stateRoot {
+			log.Printf("ERROR: block %d does not match its state root", currentIndex)
 			return false
 		}
 
-		preBlock = b
 		currentIndex += 1
 	}
 
@@ -495,11 +1207,15 @@ func (bc *Blockchain) ValidChain(chain []*Block) bool { //what if later on?
 
 func (bc *Blockchain) ResolveConflicts() bool {
 	var longestChain []*Block = nil
-	maxLength := len(bc.chain)
+	maxLength := len(bc.Chain())
 
-	for _, n := range bc.nodes {
+	for _, n := range bc.Nodes() {
 		endpoint := fmt.Sprintf("http://%s/chain", n)
-		resp, _ := http.Get(endpoint)
+		resp, err := http.Get(endpoint)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			continue
+		}
 		if resp.StatusCode == 200 {
 			var bcResp Blockchain
 			decoder := json.NewDecoder(resp.Body)
@@ -507,15 +1223,42 @@ func (bc *Blockchain) ResolveConflicts() bool {
 
 			chain := bcResp.Chain()
 
-			if len(chain) > maxLength && bc.ValidChain(chain) {
-				maxLength = len(chain)
-				longestChain = chain
+			if len(chain) > maxLength {
+				if err := bc.fillBodies(chain); err != nil {
+					log.Printf("ERROR: chain of %s: %v", n, err)
+				} else if bc.ValidChain(chain) {
+					maxLength = len(chain)
+					longestChain = chain
+				}
 			}
 		}
+		resp.Body.Close()
 	}
 
-	if longestChain != nil {
-		bc.chain = longestChain
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	// The chain may have grown while peers were being queried.
+	if longestChain != nil && len(longestChain) > len(bc.chain) {
+		fork := 0
+		for fork < len(bc.chain) && fork < len(longestChain) &&
+			bc.chain[fork].Hash() == longestChain[fork].Hash() {
+			fork++
+		}
+		orphaned := bc.chain[fork:]
+		if _, start := bc.base(bc.chain); fork < start {
+			// The fork leaves the snapshot behind and the state is replayed
+			// from genesis, over the bodies fillBodies took for longestChain.
+			bc.chain = longestChain
+			bc.snapshot = nil
+			fork = 0
+		} else {
+			// Below the fork our blocks are kept, pruned as they are.
+			bc.chain = append(bc.chain[:fork:fork], longestChain[fork:]...)
+		}
+		bc.updateLastHash()
+		bc.rebuildState(fork)
+		bc.reconcileTransactionPool(longestChain[fork:], orphaned)
 		log.Printf("Resovle confilicts replaced")
 		return true
 	}
@@ -546,10 +1289,106 @@ type Transaction struct {
 	senderBlockchainAddress    string
 	recipientBlockchainAddress string
 	token                      Token
+	decimals                   uint8
+	txType                     string
+	issue                      *TokenIssue
+	outputs                    []*Output
+	swap                       *Swap
+	htlc                       *HTLC
+	settle                     *HTLCSettle
+	escrow                     *Escrow
+	escrowID                   string
+	deploy                     *ContractDeploy
+	call                       *ContractCall
+	nonce                      uint64
+	chainID                    uint64
+	expiryHeight               int64
+	expiresAt                  int64
+	lockHeight                 int64
+	lockedUntil                int64
+	senderPublicKey            *ecdsa.PublicKey
+	signature                  *utils.Signature
+	counterpartyPublicKey      *ecdsa.PublicKey
+	counterpartySignature      *utils.Signature
+	multisig                   *Multisig
+	signatures                 []*utils.Signature
+	// The miner fills in the gas a call used and, on the coinbase, the fees
+	// of its block. Neither is signed, both are covered by the transactions
+	// root.
+	gasUsed uint64
+	fee     decimal.Decimal
+	addedAt time.Time
 }
 
 func NewTransaction(sender string, recipient string, token Token) *Transaction {
-	return &Transaction{sender, recipient, token}
+	return &Transaction{senderBlockchainAddress: sender, recipientBlockchainAddress: recipient, token: token}
+}
+
+// SetExpiry sets the last block height and the unix time after which the
+// transaction can no longer be mined. Zero disables either limit. Both values
+// are part of the signed payload.
+func (t *Transaction) SetExpiry(height int64, timestamp int64) {
+	t.expiryHeight = height
+	t.expiresAt = timestamp
+}
+
+// SetIssue turns t into an issue_token transaction registering its token.
+func (t *Transaction) SetIssue(issue *TokenIssue) {
+	t.txType = TxIssueToken
+	t.issue = issue
+}
+
+// SetDecimals sets the number of decimals of the token, which fixes the unit
+// the amount is signed and stored in.
+func (t *Transaction) SetDecimals(decimals uint8) {
+	t.decimals = decimals
+}
+
+// SetOutputs turns t into a multi_transfer paying outputs. The token of t is
+// not used.
+func (t *Transaction) SetOutputs(outputs []*Output) {
+	t.txType = TxMultiTransfer
+	t.outputs = outputs
+}
+
+// SetNonce sets the sender nonce. Nonces of a sender must increase.
+func (t *Transaction) SetNonce(nonce uint64) {
+	t.nonce = nonce
+}
+
+// fixedToken is the token of t as signed, multi_transfers and contract
+// transactions have none.
+func (t *Transaction) fixedToken() *FixedToken {
+	if !hasToken(t.txType) {
+		return nil
+	}
+	ft := NewFixedToken(t.token, t.decimals)
+	return &ft
+}
+
+// hasToken reports whether transactions of a type move their own token.
+// multi_transfers carry theirs in the outputs.
+func hasToken(txType string) bool {
+	return txType != TxMultiTransfer && !isContract(txType)
+}
+
+// SetType sets the transaction type. Use SetIssue for issue_token.
+func (t *Transaction) SetType(txType string) {
+	t.txType = txType
+}
+
+func (t *Transaction) Type() string {
+	return t.txType
+}
+
+func (t *Transaction) Expired(height int64, now time.Time) bool {
+	if t.expiryHeight > 0 && height > t.expiryHeight {
+		return true
+	}
+	if t.expiresAt > 0 && now.Unix() >= t.expiresAt {
+		return true
+	}
+	return false
 }
 
 func (tk *Token) Print() {
@@ -561,36 +1400,271 @@ func (t *Transaction) Print() {
 	fmt.Printf(" sender_blockchain_address   = %s\n", t.senderBlockchainAddress)
 	fmt.Printf(" recipient_blockchain_address   = %s\n", t.recipientBlockchainAddress)
 	fmt.Printf(" token %s\n value = %s\n", t.token.TokenName, t.token.TokenValue)
+	if t.expiryHeight > 0 || t.expiresAt > 0 {
+		fmt.Printf(" expiry_height = %d\n expires_at = %d\n", t.expiryHeight, t.expiresAt)
+	}
+	if t.lockHeight > 0 || t.lockedUntil > 0 {
+		fmt.Printf(" lock_height = %d\n locked_until = %d\n", t.lockHeight, t.lockedUntil)
+	}
 	//fmt.Printf(" token value = %.1f\n", t.token)
 }
 
+// signedPayload is the message the sender signs, the wallet signs it through
+// SigningHash as well.
+func (t *Transaction) signedPayload() []byte {
+	m, _ := json.Marshal(struct {
+		ChainID      uint64          `json:"chain_id"`
+		Type         string          `json:"type,omitempty"`
+		Nonce        uint64          `json:"nonce,omitempty"`
+		Sender       string          `json:"sender_blockchain_address"`
+		Recipient    string          `json:"recipient_blockchain_address"`
+		Token        *FixedToken     `json:"token,omitempty"`
+		Outputs      []FixedOutput   `json:"outputs,omitempty"`
+		Swap         *FixedToken     `json:"swap,omitempty"`
+		HTLC         *HTLC           `json:"htlc,omitempty"`
+		Settle       *HTLCSettle     `json:"htlc_settle,omitempty"`
+		Escrow       *Escrow         `json:"escrow,omitempty"`
+		EscrowID     string          `json:"escrow_id,omitempty"`
+		Deploy       *ContractDeploy `json:"deploy,omitempty"`
+		Call         *ContractCall   `json:"call,omitempty"`
+		Issue        *TokenIssue     `json:"issue,omitempty"`
+		ExpiryHeight int64           `json:"expiry_height,omitempty"`
+		ExpiresAt    int64           `json:"expires_at,omitempty"`
+		LockHeight   int64           `json:"lock_height,omitempty"`
+		LockedUntil  int64           `json:"locked_until,omitempty"`
+	}{
+		ChainID:      t.chainID,
+		Type:         t.txType,
+		Nonce:        t.nonce,
+		Sender:       t.senderBlockchainAddress,
+		Recipient:    t.recipientBlockchainAddress,
+		Token:        t.fixedToken(),
+		Outputs:      FixedOutputs(t.outputs),
+		Swap:         t.swap.Fixed(),
+		HTLC:         t.htlc,
+		Settle:       t.settle,
+		Escrow:       t.escrow,
+		EscrowID:     t.escrowID,
+		Deploy:       t.deploy,
+		Call:         t.call,
+		Issue:        t.issue,
+		ExpiryHeight: t.expiryHeight,
+		ExpiresAt:    t.expiresAt,
+		LockHeight:   t.lockHeight,
+		LockedUntil:  t.lockedUntil,
+	})
+	return m
+}
+
+func (t *Transaction) SigningHash() [32]byte {
+	return sha256.Sum256(t.signedPayload())
+}
+
+// ID identifies a transaction across nodes. It covers the signature as well,
+// so two separately signed but otherwise equal transfers are distinct. The
+// counterparty signature of a swap is left out, the sender signature already
+// makes the swap unique. Multisig transactions are identified by their
+// payload alone, their nonce keeps them apart.
+func (t *Transaction) ID() string {
+	h := sha256.New()
+	h.Write(t.signedPayload())
+	if t.signature != nil {
+		h.Write([]byte(t.signature.String()))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 func (t *Transaction) MarshalJSON() ([]byte, error) {
+	var publicKeyStr, signatureStr string
+	if t.senderPublicKey != nil {
+		publicKeyStr = fmt.Sprintf("%064x%064x", t.senderPublicKey.X.Bytes(), t.senderPublicKey.Y.Bytes())
+	}
+	if t.signature != nil {
+		signatureStr = t.signature.String()
+	}
+	var counterpartyPublicKeyStr, counterpartySignatureStr string
+	if t.counterpartyPublicKey != nil {
+		counterpartyPublicKeyStr = fmt.Sprintf("%064x%064x", t.counterpartyPublicKey.X.Bytes(), t.counterpartyPublicKey.Y.Bytes())
+	}
+	if t.counterpartySignature != nil {
+		counterpartySignatureStr = t.counterpartySignature.String()
+	}
+	var fee *decimal.Decimal
+	if !t.fee.IsZero() {
+		fee = &t.fee
+	}
+
 	return json.Marshal(struct {
-		Sender    string `json:"sender_blockchain_address"`
-		Recipient string `json:"recipient_blockchain_address"`
-		Token     Token  `json:"token"`
+		ID              string          `json:"id"`
+		ChainID         uint64          `json:"chain_id"`
+		Type            string          `json:"type,omitempty"`
+		Nonce           uint64          `json:"nonce,omitempty"`
+		Sender          string          `json:"sender_blockchain_address"`
+		Recipient       string          `json:"recipient_blockchain_address"`
+		Token           *FixedToken     `json:"token,omitempty"`
+		Outputs         []FixedOutput   `json:"outputs,omitempty"`
+		Swap            *FixedToken     `json:"swap,omitempty"`
+		HTLC            *HTLC           `json:"htlc,omitempty"`
+		Settle          *HTLCSettle     `json:"htlc_settle,omitempty"`
+		Escrow          *Escrow         `json:"escrow,omitempty"`
+		EscrowID        string          `json:"escrow_id,omitempty"`
+		Deploy          *ContractDeploy `json:"deploy,omitempty"`
+		Call            *ContractCall   `json:"call,omitempty"`
+		Issue           *TokenIssue     `json:"issue,omitempty"`
+		ExpiryHeight    int64           `json:"expiry_height,omitempty"`
+		ExpiresAt       int64           `json:"expires_at,omitempty"`
+		LockHeight      int64           `json:"lock_height,omitempty"`
+		LockedUntil     int64           `json:"locked_until,omitempty"`
+		SenderPublicKey string          `json:"sender_public_key,omitempty"`
+		Signature       string          `json:"signature,omitempty"`
+
+		CounterpartyPublicKey string    `json:"counterparty_public_key,omitempty"`
+		CounterpartySignature string    `json:"counterparty_signature,omitempty"`
+		Multisig              *Multisig `json:"multisig,omitempty"`
+		Signatures            []string  `json:"signatures,omitempty"`
+
+		GasUsed uint64           `json:"gas_used,omitempty"`
+		Fee     *decimal.Decimal `json:"fee,omitempty"`
 	}{
-		Sender:    t.senderBlockchainAddress,
-		Recipient: t.recipientBlockchainAddress,
-		Token:     t.token,
+		ID:              t.ID(),
+		ChainID:         t.chainID,
+		Type:            t.txType,
+		Nonce:           t.nonce,
+		Sender:          t.senderBlockchainAddress,
+		Recipient:       t.recipientBlockchainAddress,
+		Token:           t.fixedToken(),
+		Outputs:         FixedOutputs(t.outputs),
+		Swap:            t.swap.Fixed(),
+		HTLC:            t.htlc,
+		Settle:          t.settle,
+		Escrow:          t.escrow,
+		EscrowID:        t.escrowID,
+		Deploy:          t.deploy,
+		Call:            t.call,
+		Issue:           t.issue,
+		ExpiryHeight:    t.expiryHeight,
+		ExpiresAt:       t.expiresAt,
+		LockHeight:      t.lockHeight,
+		LockedUntil:     t.lockedUntil,
+		SenderPublicKey: publicKeyStr,
+		Signature:       signatureStr,
+
+		CounterpartyPublicKey: counterpartyPublicKeyStr,
+		CounterpartySignature: counterpartySignatureStr,
+		Multisig:              t.multisig,
+		Signatures:            signaturesToStrings(t.signatures),
+
+		GasUsed: t.gasUsed,
+		Fee:     fee,
 	})
 }
 
 func (t *Transaction) UnmarshalJSON(data []byte) error {
+	var publicKeyStr, signatureStr string
+	var counterpartyPublicKeyStr, counterpartySignatureStr string
+	var token, swap *FixedToken
+	var outputs []FixedOutput
+	var signatures []string
+
 	v := &struct {
-		Sender    *string `json:"sender_blockchain_address"`
-		Recipient *string `json:"recipient_blockchain_address"`
-		Token     *Token  `json:"token"`
+		ChainID         *uint64          `json:"chain_id"`
+		Type            *string          `json:"type"`
+		Nonce           *uint64          `json:"nonce"`
+		Sender          *string          `json:"sender_blockchain_address"`
+		Recipient       *string          `json:"recipient_blockchain_address"`
+		Token           **FixedToken     `json:"token"`
+		Outputs         *[]FixedOutput   `json:"outputs"`
+		Swap            **FixedToken     `json:"swap"`
+		HTLC            **HTLC           `json:"htlc"`
+		Settle          **HTLCSettle     `json:"htlc_settle"`
+		Escrow          **Escrow         `json:"escrow"`
+		EscrowID        *string          `json:"escrow_id"`
+		Deploy          **ContractDeploy `json:"deploy"`
+		Call            **ContractCall   `json:"call"`
+		Issue           **TokenIssue     `json:"issue"`
+		ExpiryHeight    *int64           `json:"expiry_height"`
+		ExpiresAt       *int64           `json:"expires_at"`
+		LockHeight      *int64           `json:"lock_height"`
+		LockedUntil     *int64           `json:"locked_until"`
+		SenderPublicKey *string          `json:"sender_public_key"`
+		Signature       *string          `json:"signature"`
+
+		CounterpartyPublicKey *string    `json:"counterparty_public_key"`
+		CounterpartySignature *string    `json:"counterparty_signature"`
+		Multisig              **Multisig `json:"multisig"`
+		Signatures            *[]string  `json:"signatures"`
+
+		GasUsed *uint64          `json:"gas_used"`
+		Fee     *decimal.Decimal `json:"fee"`
 	}{
-		Sender:    &t.senderBlockchainAddress,
-		Recipient: &t.recipientBlockchainAddress,
-		Token:     &t.token,
+		ChainID:         &t.chainID,
+		Type:            &t.txType,
+		Nonce:           &t.nonce,
+		Sender:          &t.senderBlockchainAddress,
+		Recipient:       &t.recipientBlockchainAddress,
+		Token:           &token,
+		Outputs:         &outputs,
+		Swap:            &swap,
+		HTLC:            &t.htlc,
+		Settle:          &t.settle,
+		Escrow:          &t.escrow,
+		EscrowID:        &t.escrowID,
+		Deploy:          &t.deploy,
+		Call:            &t.call,
+		Issue:           &t.issue,
+		ExpiryHeight:    &t.expiryHeight,
+		ExpiresAt:       &t.expiresAt,
+		LockHeight:      &t.lockHeight,
+		LockedUntil:     &t.lockedUntil,
+		SenderPublicKey: &publicKeyStr,
+		Signature:       &signatureStr,
+
+		CounterpartyPublicKey: &counterpartyPublicKeyStr,
+		CounterpartySignature: &counterpartySignatureStr,
+		Multisig:              &t.multisig,
+		Signatures:            &signatures,
+
+		GasUsed: &t.gasUsed,
+		Fee:     &t.fee,
 	}
 
 	if err := json.Unmarshal(data, &v); err != nil {
 		return err
 	}
 
+	if token != nil {
+		var err error
+		if t.token, err = token.Token(); err != nil {
+			return err
+		}
+		t.decimals = token.Decimals
+	}
+	for _, fo := range outputs {
+		o, err := fo.Output()
+		if err != nil {
+			return err
+		}
+		t.outputs = append(t.outputs, o)
+	}
+	var err error
+	if t.swap, err = swapFromFixed(swap); err != nil {
+		return err
+	}
+
+	if len(publicKeyStr) == 128 {
+		t.senderPublicKey = utils.PublicKeyFromString(publicKeyStr)
+	}
+	if len(signatureStr) == 128 {
+		t.signature = utils.SignatureFromString(signatureStr)
+	}
+	if len(counterpartyPublicKeyStr) == 128 {
+		t.counterpartyPublicKey = utils.PublicKeyFromString(counterpartyPublicKeyStr)
+	}
+	if len(counterpartySignatureStr) == 128 {
+		t.counterpartySignature = utils.SignatureFromString(counterpartySignatureStr)
+	}
+	t.signatures = signaturesFromStrings(signatures)
+
 	return nil
 }
 
@@ -600,29 +1674,376 @@ type TransactionRequest struct {
 	SenderPublicKey            *string          `json:"sender_public_key"`
 	TokenName                  *string          `json:"token_name"`
 	TokenValue                 *decimal.Decimal `json:"token_value"`
+	TokenDecimals              *uint8           `json:"token_decimals"`
 	Signature                  *string          `json:"signature"`
+	ChainID                    *uint64          `json:"chain_id"`
+	Type                       *string          `json:"type,omitempty"`
+	Issue                      *TokenIssue      `json:"issue,omitempty"`
+	Outputs                    []*Output        `json:"outputs,omitempty"`
+	Swap                       *Swap            `json:"swap,omitempty"`
+	HTLC                       *HTLC            `json:"htlc,omitempty"`
+	Settle                     *HTLCSettle      `json:"htlc_settle,omitempty"`
+	Escrow                     *Escrow          `json:"escrow,omitempty"`
+	EscrowID                   *string          `json:"escrow_id,omitempty"`
+	Deploy                     *ContractDeploy  `json:"deploy,omitempty"`
+	Call                       *ContractCall    `json:"call,omitempty"`
+	CounterpartyPublicKey      *string          `json:"counterparty_public_key,omitempty"`
+	CounterpartySignature      *string          `json:"counterparty_signature,omitempty"`
+	Multisig                   *Multisig        `json:"multisig,omitempty"`
+	Signatures                 []string         `json:"signatures,omitempty"`
+	Nonce                      *uint64          `json:"nonce,omitempty"`
+	ExpiryHeight               *int64           `json:"expiry_height,omitempty"`
+	ExpiresAt                  *int64           `json:"expires_at,omitempty"`
+	LockHeight                 *int64           `json:"lock_height,omitempty"`
+	LockedUntil                *int64           `json:"locked_until,omitempty"`
 }
 
-func (tr *TransactionRequest) Validate() bool {
+// Validate checks the form of the request. Whether the transaction is
+// acceptable is decided by the blockchain.
+func (tr *TransactionRequest) Validate() error {
+	errs := tr.validate()
+	if tr.Type != nil && (*tr.Type == TxSwap || isEscrowSettle(*tr.Type)) {
+		if tr.CounterpartyPublicKey == nil || len(*tr.CounterpartyPublicKey) != 128 {
+			errs.Add("counterparty_public_key", "must be 128 hex characters")
+		}
+		if tr.CounterpartySignature == nil || len(*tr.CounterpartySignature) != 128 {
+			errs.Add("counterparty_signature", "must be 128 hex characters")
+		}
+	}
+	if tr.Multisig != nil && tr.SignatureCount() < int(tr.Multisig.Threshold) {
+		errs.Add("signatures", fmt.Sprintf("%d of %d required signatures", tr.SignatureCount(), tr.Multisig.Threshold))
+	}
+	return errs.Err()
+}
 
-	log.Println(tr)
-	if tr.SenderBlockchainAddress == nil ||
-		tr.RecipientBlockchainAddress == nil ||
-		tr.SenderPublicKey == nil ||
-		tr.TokenName == nil || tr.TokenValue == nil {
-		return false
+// ValidateProposal checks a request that still lacks signatures of other
+// parties: a swap its recipient has not signed yet, an escrow settlement
+// without its second party, or a multisig transaction below its threshold.
+func (tr *TransactionRequest) ValidateProposal() error {
+	errs := tr.validate()
+	cosigned := tr.Type != nil && (*tr.Type == TxSwap || isEscrowSettle(*tr.Type))
+	if !cosigned && tr.Multisig == nil {
+		errs.Add("type", "must be swap, escrow_release, escrow_refund or carry a multisig")
 	}
+	return errs.Err()
+}
 
-	return true
+func (tr *TransactionRequest) validate() utils.ValidationErrors {
+	var errs utils.ValidationErrors
+
+	if tr.SenderBlockchainAddress == nil {
+		errs.Add("sender_blockchain_address", "is required")
+	}
+	if tr.RecipientBlockchainAddress == nil {
+		errs.Add("recipient_blockchain_address", "is required")
+	}
+	if tr.Nonce == nil || *tr.Nonce == 0 {
+		errs.Add("nonce", "is required")
+	}
+	if tr.Multisig != nil {
+		validateMultisigRequest(&errs, tr)
+	} else if tr.SenderPublicKey == nil {
+		errs.Add("sender_public_key", "is required")
+	} else if len(*tr.SenderPublicKey) != 128 {
+		errs.Add("sender_public_key", "must be 128 hex characters")
+	}
+	isIssue := tr.Type != nil && *tr.Type == TxIssueToken
+	if tr.Type != nil && *tr.Type == TxMultiTransfer {
+		validateOutputRequests(&errs, tr.Outputs)
+	} else if tr.Type != nil && isContract(*tr.Type) {
+		validateContractRequest(&errs, tr)
+	} else {
+		if tr.TokenName == nil || *tr.TokenName == "" {
+			errs.Add("token_name", "is required")
+		}
+		if tr.TokenDecimals == nil {
+			errs.Add("token_decimals", "is required")
+		} else if *tr.TokenDecimals > MaxTokenDecimals {
+			errs.Add("token_decimals", fmt.Sprintf("must be at most %d", MaxTokenDecimals))
+		}
+		switch {
+		case tr.TokenValue == nil:
+			errs.Add("token_value", "is required")
+		case tr.TokenValue.IsNegative(), tr.TokenValue.IsZero() && !isIssue:
+			errs.Add("token_value", "must be positive")
+		case tr.TokenDecimals != nil && !fitsDecimals(*tr.TokenValue, *tr.TokenDecimals):
+			errs.Add("token_value", fmt.Sprintf("has more than %d decimals", *tr.TokenDecimals))
+		}
+	}
+	if tr.Multisig != nil {
+		// Signed by the keys of the multisig instead.
+	} else if tr.Signature == nil {
+		errs.Add("signature", "is required")
+	} else if len(*tr.Signature) != 128 {
+		errs.Add("signature", "must be 128 hex characters")
+	}
+	if tr.ChainID == nil {
+		errs.Add("chain_id", "is required")
+	}
+	if isIssue && tr.Issue == nil {
+		errs.Add("issue", "is required for issue_token")
+	}
+	if tr.Type != nil && *tr.Type == TxSwap {
+		validateSwapRequest(&errs, tr.Swap)
+	}
+	if tr.Type != nil && *tr.Type == TxHTLCLock && tr.HTLC == nil {
+		errs.Add("htlc", "is required for htlc_lock")
+	}
+	if tr.Type != nil && isHTLCSettle(*tr.Type) && (tr.Settle == nil || tr.Settle.ID == "") {
+		errs.Add("htlc_settle.htlc_id", "is required for "+*tr.Type)
+	}
+	if tr.Type != nil && *tr.Type == TxEscrowLock && tr.Escrow == nil {
+		errs.Add("escrow", "is required for escrow_lock")
+	}
+	if tr.Type != nil && isEscrowSettle(*tr.Type) && (tr.EscrowID == nil || *tr.EscrowID == "") {
+		errs.Add("escrow_id", "is required for "+*tr.Type)
+	}
+	if tr.LockHeight != nil && *tr.LockHeight < 0 {
+		errs.Add("lock_height", "must not be negative")
+	}
+	if tr.LockedUntil != nil && *tr.LockedUntil < 0 {
+		errs.Add("locked_until", "must not be negative")
+	}
+
+	return errs
+}
+
+// validateSwapRequest checks the counter leg of a swap request.
+func validateSwapRequest(errs *utils.ValidationErrors, swap *Swap) {
+	switch {
+	case swap == nil:
+		errs.Add("swap", "is required for swap")
+	case swap.TokenName == "":
+		errs.Add("swap.token_name", "is required")
+	case swap.TokenDecimals > MaxTokenDecimals:
+		errs.Add("swap.token_decimals", fmt.Sprintf("must be at most %d", MaxTokenDecimals))
+	case !swap.TokenValue.IsPositive():
+		errs.Add("swap.token_value", "must be positive")
+	case !fitsDecimals(swap.TokenValue, swap.TokenDecimals):
+		errs.Add("swap.token_value", fmt.Sprintf("has more than %d decimals", swap.TokenDecimals))
+	}
+}
+
+// validateOutputRequests checks the outputs of a multi_transfer request.
+func validateOutputRequests(errs *utils.ValidationErrors, outputs []*Output) {
+	if len(outputs) == 0 || len(outputs) > MaxOutputs {
+		errs.Add("outputs", fmt.Sprintf("must contain between 1 and %d outputs", MaxOutputs))
+		return
+	}
+	for i, o := range outputs {
+		field := fmt.Sprintf("outputs[%d].", i)
+		if o == nil {
+			errs.Add(fmt.Sprintf("outputs[%d]", i), "is required")
+			continue
+		}
+		if o.RecipientBlockchainAddress == "" {
+			errs.Add(field+"recipient_blockchain_address", "is required")
+		}
+		if o.TokenName == "" {
+			errs.Add(field+"token_name", "is required")
+		}
+		if o.TokenDecimals > MaxTokenDecimals {
+			errs.Add(field+"token_decimals", fmt.Sprintf("must be at most %d", MaxTokenDecimals))
+		}
+		if !o.TokenValue.IsPositive() {
+			errs.Add(field+"token_value", "must be positive")
+		} else if !fitsDecimals(o.TokenValue, o.TokenDecimals) {
+			errs.Add(field+"token_value", fmt.Sprintf("has more than %d decimals", o.TokenDecimals))
+		}
+	}
+}
+
+// Transaction builds the signed transaction described by a validated request.
+func (tr *TransactionRequest) Transaction() *Transaction {
+	t := NewTransaction(*tr.SenderBlockchainAddress, *tr.RecipientBlockchainAddress, Token{})
+	if tr.TokenName != nil && tr.TokenValue != nil && tr.TokenDecimals != nil {
+		t.token = Token{TokenName: *tr.TokenName, TokenValue: *tr.TokenValue}
+		t.decimals = *tr.TokenDecimals
+	}
+	if tr.Outputs != nil {
+		t.outputs = make([]*Output, len(tr.Outputs))
+		for i, o := range tr.Outputs {
+			c := *o
+			t.outputs[i] = &c
+		}
+	}
+	if tr.Swap != nil {
+		swap := *tr.Swap
+		t.swap = &swap
+	}
+	if tr.HTLC != nil {
+		htlc := *tr.HTLC
+		t.htlc = &htlc
+	}
+	if tr.Settle != nil {
+		settle := *tr.Settle
+		t.settle = &settle
+	}
+	if tr.Escrow != nil {
+		escrow := *tr.Escrow
+		t.escrow = &escrow
+	}
+	if tr.EscrowID != nil {
+		t.escrowID = *tr.EscrowID
+	}
+	if tr.Deploy != nil {
+		deploy := *tr.Deploy
+		t.deploy = &deploy
+	}
+	if tr.Call != nil {
+		call := *tr.Call
+		call.Args = append([]uint64(nil), tr.Call.Args...)
+		t.call = &call
+	}
+	if tr.Nonce != nil {
+		t.nonce = *tr.Nonce
+	}
+	t.chainID = *tr.ChainID
+	if tr.Type != nil {
+		t.txType = *tr.Type
+	}
+	if tr.Issue != nil {
+		issue := *tr.Issue
+		t.issue = &issue
+	}
+	if tr.ExpiryHeight != nil {
+		t.expiryHeight = *tr.ExpiryHeight
+	}
+	if tr.ExpiresAt != nil {
+		t.expiresAt = *tr.ExpiresAt
+	}
+	if tr.LockHeight != nil {
+		t.lockHeight = *tr.LockHeight
+	}
+	if tr.LockedUntil != nil {
+		t.lockedUntil = *tr.LockedUntil
+	}
+	if tr.SenderPublicKey != nil && len(*tr.SenderPublicKey) == 128 {
+		t.senderPublicKey = utils.PublicKeyFromString(*tr.SenderPublicKey)
+	}
+	if tr.Signature != nil && len(*tr.Signature) == 128 {
+		t.signature = utils.SignatureFromString(*tr.Signature)
+	}
+	if tr.Multisig != nil {
+		multisig := *tr.Multisig
+		t.multisig = &multisig
+		t.signatures = signaturesFromStrings(tr.Signatures)
+	}
+	if tr.CounterpartyPublicKey != nil && len(*tr.CounterpartyPublicKey) == 128 {
+		t.counterpartyPublicKey = utils.PublicKeyFromString(*tr.CounterpartyPublicKey)
+	}
+	if tr.CounterpartySignature != nil && len(*tr.CounterpartySignature) == 128 {
+		t.counterpartySignature = utils.SignatureFromString(*tr.CounterpartySignature)
+	}
+	return t
+}
+
+// Request is the inverse of TransactionRequest.Transaction and is used to
+// relay a pooled transaction to peers or to persist it.
+func (t *Transaction) Request() *TransactionRequest {
+	sender := t.senderBlockchainAddress
+	recipient := t.recipientBlockchainAddress
+	token := t.token
+	chainID := t.chainID
+	decimals := t.decimals
+	tr := &TransactionRequest{
+		ChainID:                    &chainID,
+		SenderBlockchainAddress:    &sender,
+		RecipientBlockchainAddress: &recipient,
+	}
+	if t.senderPublicKey != nil && t.signature != nil {
+		publicKeyStr := fmt.Sprintf("%064x%064x", t.senderPublicKey.X.Bytes(), t.senderPublicKey.Y.Bytes())
+		signatureStr := t.signature.String()
+		tr.SenderPublicKey = &publicKeyStr
+		tr.Signature = &signatureStr
+	}
+	if t.multisig != nil {
+		multisig := *t.multisig
+		tr.Multisig = &multisig
+		tr.Signatures = signaturesToStrings(t.signatures)
+	}
+	if hasToken(t.txType) {
+		tr.TokenName = &token.TokenName
+		tr.TokenValue = &token.TokenValue
+		tr.TokenDecimals = &decimals
+	}
+	for _, o := range t.outputs {
+		c := *o
+		tr.Outputs = append(tr.Outputs, &c)
+	}
+	if t.swap != nil {
+		swap := *t.swap
+		tr.Swap = &swap
+	}
+	if t.htlc != nil {
+		htlc := *t.htlc
+		tr.HTLC = &htlc
+	}
+	if t.settle != nil {
+		settle := *t.settle
+		tr.Settle = &settle
+	}
+	if t.escrow != nil {
+		escrow := *t.escrow
+		tr.Escrow = &escrow
+	}
+	if t.escrowID != "" {
+		escrowID := t.escrowID
+		tr.EscrowID = &escrowID
+	}
+	if t.deploy != nil {
+		deploy := *t.deploy
+		tr.Deploy = &deploy
+	}
+	if t.call != nil {
+		call := *t.call
+		call.Args = append([]uint64(nil), t.call.Args...)
+		tr.Call = &call
+	}
+	if t.counterpartyPublicKey != nil && t.counterpartySignature != nil {
+		counterpartyPublicKeyStr := fmt.Sprintf("%064x%064x", t.counterpartyPublicKey.X.Bytes(), t.counterpartyPublicKey.Y.Bytes())
+		counterpartySignatureStr := t.counterpartySignature.String()
+		tr.CounterpartyPublicKey = &counterpartyPublicKeyStr
+		tr.CounterpartySignature = &counterpartySignatureStr
+	}
+	if t.nonce > 0 {
+		nonce := t.nonce
+		tr.Nonce = &nonce
+	}
+	if t.txType != TxTransfer {
+		txType := t.txType
+		tr.Type = &txType
+	}
+	if t.issue != nil {
+		issue := *t.issue
+		tr.Issue = &issue
+	}
+	if t.expiryHeight > 0 {
+		expiryHeight := t.expiryHeight
+		tr.ExpiryHeight = &expiryHeight
+	}
+	if t.expiresAt > 0 {
+		expiresAt := t.expiresAt
+		tr.ExpiresAt = &expiresAt
+	}
+	if t.lockHeight > 0 {
+		lockHeight := t.lockHeight
+		tr.LockHeight = &lockHeight
+	}
+	if t.lockedUntil > 0 {
+		lockedUntil := t.lockedUntil
+		tr.LockedUntil = &lockedUntil
+	}
+	return tr
 }
 
 type AmountResponse struct {
-	Amount []*Token `json:"amount"`
+	Amount []*Balance `json:"amount"`
 }
 
 func (ar *AmountResponse) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Amount []*Token `json:"amount"`
+		Amount []*Balance `json:"amount"`
 	}{
 		Amount: ar.Amount,
 	})
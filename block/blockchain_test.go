@@ -0,0 +1,261 @@
+package block
+
+import (
+	"crypto/elliptic"
+	"encoding/json"
+	"errors"
+	"main/config"
+	"main/utils"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// TestConcurrentAccess runs the writers of the chain and the pool against
+// each other and against the read endpoints. Run it with -race.
+func TestConcurrentAccess(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+	bc.blockchainAddress = alice.address
+	mine(t, bc, 3)
+
+	// A longer fork makes the first ResolveConflicts replace the chain.
+	fork := newTestChain(t, bob.address, 0, 0)
+	syncFrom(fork, bc)
+	mine(t, fork, 6)
+	serveChain(t, fork, bc)
+
+	var writers, readers sync.WaitGroup
+	stop := make(chan struct{})
+	run := func(wg *sync.WaitGroup, n int, f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				f(i)
+			}
+		}()
+	}
+	read := func(f func()) {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					f()
+				}
+			}
+		}()
+	}
+
+	run(&writers, 20, func(i int) {
+		// Rejections are expected once the chain moves under the sender.
+		bc.AddTransaction(transfer(bc, alice, bob.address, 1, bc.NextNonce(alice.address)))
+	})
+	run(&writers, 10, func(int) { bc.Mining() })
+	run(&writers, 3, func(int) { bc.ResolveConflicts() })
+	run(&writers, 20, func(int) { bc.EvictTransactions() })
+
+	read(func() { bc.CalculateAllAmounts(alice.address) })
+	read(func() { bc.Supply() })
+	read(func() { bc.Tokens() })
+	read(func() { json.Marshal(bc.TransactionPool()) })
+	read(func() { bc.MarshalJSON() })
+	read(func() { bc.Headers(0) })
+	read(func() { bc.Filters(0, 0) })
+	read(func() { bc.ProveBalance(alice.address, "DNZ") })
+	read(func() {
+		chain := bc.Chain()
+		for _, tx := range chain[len(chain)-1].transactions {
+			bc.Receipt(tx.ID())
+			bc.IsIncluded(tx.ID())
+		}
+	})
+
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+
+	if !bc.ValidChain(bc.Chain()) {
+		t.Fatal("chain is not valid after concurrent use")
+	}
+}
+
+func TestAddTransactionChecksChainID(t *testing.T) {
+	mainnet := config.Networks["mainnet"].ChainID
+	tests := []struct {
+		name      string
+		signedFor uint64
+		sentAs    uint64
+		want      error
+	}{
+		{"this chain", 0, 0, nil},
+		{"signed for another chain", mainnet, mainnet, ErrWrongChain},
+		{"replayed from another chain", mainnet, 0, ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 3)
+			tx := transfer(bc, alice, bob.address, 1, 1)
+			if tt.signedFor != 0 {
+				tx.chainID = tt.signedFor
+				h := tx.SigningHash()
+				tx.signature = utils.Sign(alice.privateKey, h[:])
+			}
+			tx.chainID = bc.network.ChainID
+			if tt.sentAs != 0 {
+				tx.chainID = tt.sentAs
+			}
+
+			if err := bc.AddTransaction(tx); !errors.Is(err, tt.want) {
+				t.Errorf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	tests := []struct {
+		name     string
+		blocks   int
+		value    int64
+		immature int64
+		want     error
+	}{
+		{"only immature rewards", 1, 1, 8, ErrImmatureReward},
+		{"matured reward", 2, 7, 8, nil},
+		{"beyond the matured reward", 2, 8, 8, ErrImmatureReward},
+		{"beyond the balance", 2, 16, 8, ErrInsufficientBalance},
+		{"all but the last reward matured", 5, 31, 8, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, tt.blocks)
+
+			for _, b := range bc.CalculateAllAmounts(alice.address) {
+				if b.TokenName == "DNZ" && !b.Immature.Equal(decimal.NewFromInt(tt.immature)) {
+					t.Errorf("immature %s, want %d", b.Immature, tt.immature)
+				}
+			}
+			if err := bc.AddTransaction(transfer(bc, alice, bob.address, tt.value, 1)); err != tt.want {
+				t.Errorf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddTransactionChecksSignatureAndNonce(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare runs before the transfer of alice with nonce 2 is added.
+		prepare func(t *testing.T, bc *Blockchain, alice, bob *testKey)
+		edit    func(tx *Transaction)
+		want    error
+	}{
+		{"valid", nil, nil, nil},
+		{"nonce gap", nil, func(tx *Transaction) { tx.nonce = 5 }, nil},
+		{"high S", nil, func(tx *Transaction) {
+			tx.signature.S = new(big.Int).Sub(elliptic.P256().Params().N, tx.signature.S)
+		}, ErrInvalidSignature},
+		{"no nonce", nil, func(tx *Transaction) { tx.nonce = 0 }, ErrMissingNonce},
+		{"nonce used in the pool", func(t *testing.T, bc *Blockchain, alice, bob *testKey) {
+			if err := bc.AddTransaction(transfer(bc, alice, bob.address, 2, 2)); err != nil {
+				t.Fatal(err)
+			}
+		}, nil, ErrNonceTooLow},
+		{"nonce used in the chain", func(t *testing.T, bc *Blockchain, alice, bob *testKey) {
+			if err := bc.AddTransaction(transfer(bc, alice, bob.address, 2, 3)); err != nil {
+				t.Fatal(err)
+			}
+			mine(t, bc, 1)
+		}, nil, ErrNonceTooLow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 3)
+			if err := bc.AddTransaction(transfer(bc, alice, bob.address, 1, 1)); err != nil {
+				t.Fatal(err)
+			}
+			if tt.prepare != nil {
+				tt.prepare(t, bc, alice, bob)
+			}
+
+			tx := transfer(bc, alice, bob.address, 1, 2)
+			if tt.edit != nil {
+				tt.edit(tx)
+				if tx.signature.IsLowS() {
+					sign(bc, alice, tx, tx.nonce)
+				}
+			}
+			if err := bc.AddTransaction(tx); !errors.Is(err, tt.want) {
+				t.Errorf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// reseal returns chain with its tip replaced by a block of transactions
+// that carries the matching state root and proof of work.
+func reseal(bc *Blockchain, chain []*Block, transactions []*Transaction) []*Block {
+	tip := chain[len(chain)-1]
+	forged := append([]*Block{}, chain[:len(chain)-1]...)
+	b := NewBlock(0, tip.previousHash, [32]byte{}, transactions)
+	b.stateRoot = newStateTree(bc.balances(append(forged, b)), bc.contracts(forged), nil).root()
+	b.timestamp = tip.timestamp
+	b.nonce = bc.ProofOfWork(b)
+	return append(forged, b)
+}
+
+func TestValidChainRejectsDuplicates(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+	bc.blockchainAddress = alice.address
+	mine(t, bc, 3)
+	first := transfer(bc, alice, bob.address, 1, 1)
+	if err := bc.AddTransaction(first); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, 1)
+	if err := bc.AddTransaction(transfer(bc, alice, bob.address, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, 1)
+	chain := bc.Chain()
+	tip := chain[len(chain)-1].transactions
+	payment, coinbase := tip[0], tip[len(tip)-1]
+
+	malleated := *first
+	malleated.signature = &utils.Signature{R: first.signature.R, S: new(big.Int).Sub(elliptic.P256().Params().N, first.signature.S)}
+
+	tests := []struct {
+		name         string
+		transactions []*Transaction
+		want         bool
+	}{
+		{"resealed", []*Transaction{payment, coinbase}, true},
+		{"twice in a block", []*Transaction{payment, payment, coinbase}, false},
+		{"again in a later block", []*Transaction{payment, first, coinbase}, false},
+		{"malleated copy", []*Transaction{payment, &malleated, coinbase}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bc.ValidChain(reseal(bc, chain, tt.transactions)); got != tt.want {
+				t.Errorf("ValidChain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,392 @@
+package block
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"main/utils"
+	"sort"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// A deploy transaction registers the code of a contract under an address
+// derived from its transaction ID. A call transaction runs that code with
+// the arguments and gas limit of the call. Calls are replayed by every node
+// in block order; a failed call stays in its block but changes nothing.
+// Deploys use DeployGasPerByte gas per byte of code. Contracts hold no
+// tokens, deploys and calls move none apart from their fee.
+const (
+	TxDeploy = "deploy"
+	TxCall   = "call"
+)
+
+// contractStoragePrefix prefixes the Badger keys of the contract storage at
+// the tip of the chain.
+const contractStoragePrefix = "cs/"
+
+var (
+	ErrInvalidContract = errors.New("invalid contract")
+	ErrUnknownContract = errors.New("contract does not exist")
+	ErrCallFailed      = errors.New("contract call failed")
+	ErrBlockGasLimit   = errors.New("block exceeds the gas limit")
+)
+
+// ContractDeploy holds the hex encoded code of a deploy transaction.
+type ContractDeploy struct {
+	Code string `json:"code"`
+}
+
+// ContractCall holds the arguments and the gas limit of a call transaction.
+// The contract is its recipient.
+type ContractCall struct {
+	Args     []uint64 `json:"args,omitempty"`
+	GasLimit uint64   `json:"gas_limit"`
+}
+
+// ContractInfo is a deployed contract. Storage is only filled by
+// Blockchain.Contract.
+type ContractInfo struct {
+	Address       string            `json:"address"`
+	Creator       string            `json:"creator"`
+	Code          string            `json:"code"`
+	Height        int64             `json:"height"`
+	TransactionID string            `json:"transaction_id"`
+	Storage       map[uint64]uint64 `json:"storage,omitempty"`
+}
+
+// SetDeploy turns t into a deploy transaction.
+func (t *Transaction) SetDeploy(deploy *ContractDeploy) {
+	t.txType = TxDeploy
+	t.deploy = deploy
+}
+
+// SetCall turns t into a call of the contract at its recipient address.
+func (t *Transaction) SetCall(call *ContractCall) {
+	t.txType = TxCall
+	t.call = call
+}
+
+func isContract(txType string) bool {
+	return txType == TxDeploy || txType == TxCall
+}
+
+// verifyContract checks the form of deploy and call transactions.
+func (bc *Blockchain) verifyContract(t *Transaction) error {
+	if t.txType == TxDeploy {
+		if t.recipientBlockchainAddress != "" {
+			return errors.New("recipient: a deploy has no recipient")
+		}
+		if t.deploy == nil {
+			return fmt.Errorf("%w: code is missing", ErrInvalidContract)
+		}
+		code, err := hex.DecodeString(t.deploy.Code)
+		if err != nil {
+			return fmt.Errorf("%w: code must be hex encoded", ErrInvalidContract)
+		}
+		return ValidateCode(code)
+	}
+
+	if err := utils.ValidateAddress(t.recipientBlockchainAddress, bc.network.ContractVersion); err != nil {
+		return fmt.Errorf("contract: %w", err)
+	}
+	if t.call == nil {
+		return fmt.Errorf("%w: call is missing", ErrInvalidContract)
+	}
+	if t.call.GasLimit == 0 || t.call.GasLimit > MaxCallGas {
+		return fmt.Errorf("%w: gas limit must be between 1 and %d", ErrInvalidContract, MaxCallGas)
+	}
+	if len(t.call.Args) > MaxCallArgs {
+		return fmt.Errorf("%w: at most %d arguments are allowed", ErrInvalidContract, MaxCallArgs)
+	}
+	return nil
+}
+
+// validateContract checks that a call targets a deployed contract.
+func validateContract(t *Transaction, contracts map[string]*ContractInfo) error {
+	if t.txType != TxCall {
+		return nil
+	}
+	if _, ok := contracts[t.recipientBlockchainAddress]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownContract, t.recipientBlockchainAddress)
+	}
+	return nil
+}
+
+// applyContract runs t, mined at height, against the contracts and their
+// storage. Deploys register their contract, successful calls write to the
+// storage. It returns the result of a deploy or call and nil for other
+// transactions.
+func (bc *Blockchain) applyContract(contracts map[string]*ContractInfo, storage *storageOverlay, t *Transaction, height int64) *ExecResult {
+	switch t.txType {
+	case TxDeploy:
+		id := t.ID()
+		address := utils.ContractAddress(id, bc.network.ContractVersion)
+		contracts[address] = &ContractInfo{
+			Address:       address,
+			Creator:       t.senderBlockchainAddress,
+			Code:          t.deploy.Code,
+			Height:        height,
+			TransactionID: id,
+		}
+		return &ExecResult{GasUsed: deployGas(t)}
+	case TxCall:
+		res := execute(t, contracts, storage, height)
+		if res.Err == nil {
+			storage.apply(t.recipientBlockchainAddress, res.Writes)
+		}
+		return res
+	}
+	return nil
+}
+
+// execute runs a call mined at height without applying its writes.
+func execute(t *Transaction, contracts map[string]*ContractInfo, storage Storage, height int64) *ExecResult {
+	info, ok := contracts[t.recipientBlockchainAddress]
+	if !ok {
+		return &ExecResult{Err: ErrUnknownContract}
+	}
+	code, _ := hex.DecodeString(info.Code)
+	ctx := &CallContext{
+		Contract: info.Address,
+		Caller:   t.senderBlockchainAddress,
+		Height:   height,
+		Args:     t.call.Args,
+	}
+	return Execute(code, ctx, storage, t.call.GasLimit)
+}
+
+// contracts builds the contracts deployed in chain.
+func (bc *Blockchain) contracts(chain []*Block) map[string]*ContractInfo {
+	s, start := bc.base(chain)
+	contracts := cloneInfos(s.Contracts)
+	for height := start; height < len(chain); height++ {
+		for _, t := range chain[height].transactions {
+			if t.txType == TxDeploy {
+				bc.applyContract(contracts, nil, t, int64(height))
+			}
+		}
+	}
+	return contracts
+}
+
+// deployGas is the gas a deploy uses for its code.
+func deployGas(t *Transaction) uint64 {
+	return uint64(len(t.deploy.Code)/2) * DeployGasPerByte
+}
+
+// gasLimit is the most gas t can use: the gas of a deploy, the limit of a
+// call and zero for other transactions.
+func gasLimit(t *Transaction) uint64 {
+	switch t.txType {
+	case TxDeploy:
+		return deployGas(t)
+	case TxCall:
+		return t.call.GasLimit
+	}
+	return 0
+}
+
+// blockGas sums the gas limits of transactions.
+func blockGas(transactions []*Transaction) uint64 {
+	var gas uint64
+	for _, t := range transactions {
+		gas += gasLimit(t)
+	}
+	return gas
+}
+
+// withinGasLimit drops the deploys and calls that would take transactions
+// over the block gas limit. They wait in the pool for a later block.
+func withinGasLimit(transactions []*Transaction) []*Transaction {
+	var gas uint64
+	fitting := make([]*Transaction, 0, len(transactions))
+	for _, t := range transactions {
+		if limit := gasLimit(t); limit > 0 {
+			if gas+limit > BlockGasLimit {
+				continue
+			}
+			gas += limit
+		}
+		fitting = append(fitting, t)
+	}
+	return fitting
+}
+
+// storageOverlay buffers storage writes on top of base, which is empty when
+// nil.
+type storageOverlay struct {
+	base   Storage
+	writes map[string]map[uint64]uint64
+}
+
+func newStorageOverlay(base Storage) *storageOverlay {
+	return &storageOverlay{base: base, writes: make(map[string]map[uint64]uint64)}
+}
+
+func (s *storageOverlay) Load(contract string, key uint64) uint64 {
+	if v, ok := s.writes[contract][key]; ok {
+		return v
+	}
+	if s.base == nil {
+		return 0
+	}
+	return s.base.Load(contract, key)
+}
+
+func (s *storageOverlay) apply(contract string, writes map[uint64]uint64) {
+	if len(writes) == 0 {
+		return
+	}
+	if s.writes[contract] == nil {
+		s.writes[contract] = make(map[uint64]uint64)
+	}
+	for k, v := range writes {
+		s.writes[contract][k] = v
+	}
+}
+
+// dbStorage reads the contract storage persisted in Badger.
+type dbStorage struct {
+	db *badger.DB
+}
+
+func storageKey(contract string, key uint64) []byte {
+	k := []byte(contractStoragePrefix + contract + "/")
+	return binary.BigEndian.AppendUint64(k, key)
+}
+
+func (s dbStorage) Load(contract string, key uint64) uint64 {
+	var v uint64
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(storageKey(contract, key))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			v = binary.BigEndian.Uint64(val)
+			return nil
+		})
+	})
+	if err != nil && err != badger.ErrKeyNotFound {
+		log.Printf("ERROR: could not read contract storage: %v", err)
+	}
+	return v
+}
+
+// loadStorage reads the complete contract storage persisted in Badger into
+// an overlay without base.
+func (bc *Blockchain) loadStorage() (*storageOverlay, error) {
+	storage := newStorageOverlay(nil)
+	err := bc.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(contractStoragePrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			k := it.Item().Key()
+			if len(k) < len(prefix)+9 {
+				continue
+			}
+			contract := string(k[len(prefix) : len(k)-9])
+			key := binary.BigEndian.Uint64(k[len(k)-8:])
+			err := it.Item().Value(func(val []byte) error {
+				storage.apply(contract, map[uint64]uint64{key: binary.BigEndian.Uint64(val)})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return storage, err
+}
+
+// commitStorage adds the writes buffered in storage to wb.
+func commitStorage(wb *badger.WriteBatch, storage *storageOverlay) error {
+	for contract, writes := range storage.writes {
+		for k, v := range writes {
+			if err := wb.Set(storageKey(contract, k), binary.BigEndian.AppendUint64(nil, v)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Contract returns a deployed contract with its storage.
+func (bc *Blockchain) Contract(address string) (*ContractInfo, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	info, ok := bc.contracts(bc.chain)[address]
+	if !ok {
+		return nil, false
+	}
+
+	info.Storage = make(map[uint64]uint64)
+	prefix := []byte(contractStoragePrefix + address + "/")
+	err := bc.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key := binary.BigEndian.Uint64(item.Key()[len(prefix):])
+			err := item.Value(func(val []byte) error {
+				info.Storage[key] = binary.BigEndian.Uint64(val)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("ERROR: could not read contract storage: %v", err)
+	}
+	return info, true
+}
+
+// Contracts returns the contracts deployed by an address, oldest first.
+func (bc *Blockchain) Contracts(creator string) []*ContractInfo {
+	bc.mux.RLock()
+	contracts := bc.contracts(bc.chain)
+	bc.mux.RUnlock()
+
+	infos := make([]*ContractInfo, 0)
+	for _, info := range contracts {
+		if info.Creator == creator {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Height != infos[j].Height {
+			return infos[i].Height < infos[j].Height
+		}
+		return infos[i].Address < infos[j].Address
+	})
+	return infos
+}
+
+// validateContractRequest checks the contract fields of a deploy or call
+// request.
+func validateContractRequest(errs *utils.ValidationErrors, tr *TransactionRequest) {
+	if *tr.Type == TxDeploy {
+		if tr.Deploy == nil || tr.Deploy.Code == "" {
+			errs.Add("deploy.code", "is required for deploy")
+		}
+		return
+	}
+	if tr.Call == nil {
+		errs.Add("call", "is required for call")
+		return
+	}
+	if tr.Call.GasLimit == 0 || tr.Call.GasLimit > MaxCallGas {
+		errs.Add("call.gas_limit", fmt.Sprintf("must be between 1 and %d", MaxCallGas))
+	}
+	if len(tr.Call.Args) > MaxCallArgs {
+		errs.Add("call.args", fmt.Sprintf("must have at most %d entries", MaxCallArgs))
+	}
+}
@@ -0,0 +1,210 @@
+package block
+
+import (
+	"errors"
+	"fmt"
+	"main/utils"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// An escrow holds the funds of an escrow_lock transaction until two of its
+// three parties, the sender, the recipient and the arbiter, agree. An
+// escrow_release pays the recipient, an escrow_refund pays the sender back.
+// One party sends the settlement and a second one co-signs it.
+const (
+	TxEscrowLock    = "escrow_lock"
+	TxEscrowRelease = "escrow_release"
+	TxEscrowRefund  = "escrow_refund"
+)
+
+const (
+	EscrowLocked   = "locked"
+	EscrowReleased = "released"
+	EscrowRefunded = "refunded"
+)
+
+var (
+	ErrInvalidEscrow = errors.New("invalid escrow")
+	ErrUnknownEscrow = errors.New("escrow does not exist")
+	ErrEscrowSettled = errors.New("escrow is already released or refunded")
+)
+
+// Escrow holds the terms of an escrow_lock transaction.
+type Escrow struct {
+	Arbiter string `json:"arbiter"`
+}
+
+// EscrowInfo is the state of an escrow created by an escrow_lock transaction.
+type EscrowInfo struct {
+	ID        string          `json:"id"`
+	Sender    string          `json:"sender_blockchain_address"`
+	Recipient string          `json:"recipient_blockchain_address"`
+	Arbiter   string          `json:"arbiter"`
+	TokenName string          `json:"token_name"`
+	Amount    decimal.Decimal `json:"token_value"`
+	Decimals  uint8           `json:"token_decimals"`
+	Height    int64           `json:"height"`
+	State     string          `json:"state"`
+	SettledBy string          `json:"settled_by,omitempty"`
+}
+
+// isParty reports whether address is the sender, recipient or arbiter.
+func (info *EscrowInfo) isParty(address string) bool {
+	return address == info.Sender || address == info.Recipient || address == info.Arbiter
+}
+
+// payee returns who a settlement of the given type pays.
+func (info *EscrowInfo) payee(txType string) string {
+	if txType == TxEscrowRefund {
+		return info.Sender
+	}
+	return info.Recipient
+}
+
+// SetEscrow turns t into an escrow_lock transaction.
+func (t *Transaction) SetEscrow(escrow *Escrow) {
+	t.txType = TxEscrowLock
+	t.escrow = escrow
+}
+
+// SetEscrowSettle turns t into an escrow_release or escrow_refund of the
+// escrow created by the transaction with the given ID.
+func (t *Transaction) SetEscrowSettle(txType string, id string) {
+	t.txType = txType
+	t.escrowID = id
+}
+
+func isEscrowSettle(txType string) bool {
+	return txType == TxEscrowRelease || txType == TxEscrowRefund
+}
+
+// verifyEscrow checks the form of escrow transactions. Settlements must be
+// co-signed.
+func (bc *Blockchain) verifyEscrow(t *Transaction) error {
+	if err := bc.validateAddress(t.recipientBlockchainAddress); err != nil {
+		return fmt.Errorf("recipient: %w", err)
+	}
+	if t.txType == TxEscrowLock {
+		if t.escrow == nil {
+			return fmt.Errorf("%w: terms are missing", ErrInvalidEscrow)
+		}
+		if err := bc.validateAddress(t.escrow.Arbiter); err != nil {
+			return fmt.Errorf("arbiter: %w", err)
+		}
+		if t.recipientBlockchainAddress == t.senderBlockchainAddress ||
+			t.escrow.Arbiter == t.senderBlockchainAddress || t.escrow.Arbiter == t.recipientBlockchainAddress {
+			return fmt.Errorf("%w: sender, recipient and arbiter must differ", ErrInvalidEscrow)
+		}
+		return nil
+	}
+
+	if t.escrowID == "" {
+		return fmt.Errorf("%w: escrow_id is missing", ErrInvalidEscrow)
+	}
+	if t.counterpartyPublicKey == nil || t.counterpartySignature == nil {
+		return fmt.Errorf("%w: a second party has to co-sign", ErrInvalidEscrow)
+	}
+	if err := bc.VerifyTransactionSignature(t.counterpartyPublicKey, t.counterpartySignature, t); err != nil {
+		return fmt.Errorf("counterparty: %w", err)
+	}
+	return nil
+}
+
+// applyEscrow records the effect of a valid transaction at height on the
+// escrows.
+func applyEscrow(escrows map[string]*EscrowInfo, t *Transaction, height int64) {
+	switch t.txType {
+	case TxEscrowLock:
+		id := t.ID()
+		escrows[id] = &EscrowInfo{
+			ID:        id,
+			Sender:    t.senderBlockchainAddress,
+			Recipient: t.recipientBlockchainAddress,
+			Arbiter:   t.escrow.Arbiter,
+			TokenName: t.token.TokenName,
+			Amount:    t.token.TokenValue,
+			Decimals:  t.decimals,
+			Height:    height,
+			State:     EscrowLocked,
+		}
+	case TxEscrowRelease, TxEscrowRefund:
+		if info, ok := escrows[t.escrowID]; ok {
+			info.State = EscrowReleased
+			if t.txType == TxEscrowRefund {
+				info.State = EscrowRefunded
+			}
+			info.SettledBy = t.ID()
+		}
+	}
+}
+
+// escrows builds the escrows of chain.
+func (bc *Blockchain) escrows(chain []*Block) map[string]*EscrowInfo {
+	s, start := bc.base(chain)
+	escrows := cloneInfos(s.Escrows)
+	for height := start; height < len(chain); height++ {
+		for _, t := range chain[height].transactions {
+			applyEscrow(escrows, t, int64(height))
+		}
+	}
+	return escrows
+}
+
+// validateEscrow checks a settlement against the escrows. It must pay the
+// locked amount to the right party and be signed by two different parties.
+func (bc *Blockchain) validateEscrow(t *Transaction, escrows map[string]*EscrowInfo) error {
+	if !isEscrowSettle(t.txType) {
+		return nil
+	}
+	info, ok := escrows[t.escrowID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEscrow, t.escrowID)
+	}
+	if info.State != EscrowLocked {
+		return ErrEscrowSettled
+	}
+	if t.recipientBlockchainAddress != info.payee(t.txType) {
+		return fmt.Errorf("%w: %s pays %s", ErrInvalidEscrow, t.txType, info.payee(t.txType))
+	}
+	if t.token.TokenName != info.TokenName || !t.token.TokenValue.Equal(info.Amount) || t.decimals != info.Decimals {
+		return fmt.Errorf("%w: amount must be the locked %s %s", ErrInvalidEscrow, info.Amount, info.TokenName)
+	}
+
+	cosigner := utils.AddressFromPublicKey(t.counterpartyPublicKey, bc.network.AddressVersion)
+	if !info.isParty(t.senderBlockchainAddress) || !info.isParty(cosigner) || cosigner == t.senderBlockchainAddress {
+		return fmt.Errorf("%w: two different parties of the escrow have to sign", ErrInvalidEscrow)
+	}
+	return nil
+}
+
+// Escrow returns the state of the escrow created by the escrow_lock
+// transaction with the given ID.
+func (bc *Blockchain) Escrow(id string) (*EscrowInfo, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	info, ok := bc.escrows(bc.chain)[id]
+	return info, ok
+}
+
+// Escrows returns the escrows the address is a party of, oldest first.
+func (bc *Blockchain) Escrows(blockchainAddress string) []*EscrowInfo {
+	bc.mux.RLock()
+	escrows := bc.escrows(bc.chain)
+	bc.mux.RUnlock()
+
+	infos := make([]*EscrowInfo, 0)
+	for _, info := range escrows {
+		if info.isParty(blockchainAddress) {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Height != infos[j].Height {
+			return infos[i].Height < infos[j].Height
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
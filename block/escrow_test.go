@@ -0,0 +1,155 @@
+package block
+
+import (
+	"errors"
+	"main/utils"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// lockEscrow signs an escrow_lock of 5 DNZ from sender to recipient.
+func lockEscrow(bc *Blockchain, sender *testKey, recipient string, escrow *Escrow, nonce uint64) *Transaction {
+	tx := NewTransaction(sender.address, recipient, Token{TokenName: "DNZ", TokenValue: decimal.NewFromInt(5)})
+	tx.SetDecimals(NativeTokenDecimals)
+	tx.SetEscrow(escrow)
+	return sign(bc, sender, tx, nonce)
+}
+
+func TestEscrowLock(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(tx *Transaction)
+		want error
+	}{
+		{"valid", func(tx *Transaction) {}, nil},
+		{"no terms", func(tx *Transaction) { tx.escrow = nil }, ErrInvalidEscrow},
+		{"invalid arbiter", func(tx *Transaction) { tx.escrow.Arbiter = "nobody" }, utils.ErrInvalidAddress},
+		{"sender arbitrates", func(tx *Transaction) { tx.escrow.Arbiter = tx.senderBlockchainAddress }, ErrInvalidEscrow},
+		{"recipient arbitrates", func(tx *Transaction) { tx.escrow.Arbiter = tx.recipientBlockchainAddress }, ErrInvalidEscrow},
+		{"to itself", func(tx *Transaction) { tx.recipientBlockchainAddress = tx.senderBlockchainAddress }, ErrInvalidEscrow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob, carol := newTestKey(t, bc), newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 3)
+
+			tx := lockEscrow(bc, alice, bob.address, &Escrow{Arbiter: carol.address}, 1)
+			tt.edit(tx)
+			sign(bc, alice, tx, 1)
+			if err := bc.AddTransaction(tx); !errors.Is(err, tt.want) {
+				t.Errorf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscrowSettle(t *testing.T) {
+	tests := []struct {
+		name     string
+		txType   string
+		sender   string
+		cosigner string
+		edit     func(tx *Transaction, keys map[string]*testKey)
+		want     error
+		state    string
+	}{
+		{"release by the recipient and the arbiter", TxEscrowRelease, "bob", "carol", nil, nil, EscrowReleased},
+		{"release by the sender and the recipient", TxEscrowRelease, "alice", "bob", nil, nil, EscrowReleased},
+		{"refund by the arbiter and the sender", TxEscrowRefund, "carol", "alice", nil, nil, EscrowRefunded},
+		{"not co-signed", TxEscrowRelease, "bob", "", nil, ErrInvalidEscrow, ""},
+		{"co-signed by the sender itself", TxEscrowRelease, "bob", "bob", nil, ErrInvalidEscrow, ""},
+		{"co-signed by an outsider", TxEscrowRelease, "bob", "dave", nil, ErrInvalidEscrow, ""},
+		{"sent by an outsider", TxEscrowRelease, "dave", "carol", nil, ErrInvalidEscrow, ""},
+		{"release to the sender", TxEscrowRelease, "bob", "carol", func(tx *Transaction, keys map[string]*testKey) {
+			tx.recipientBlockchainAddress = keys["alice"].address
+		}, ErrInvalidEscrow, ""},
+		{"another amount", TxEscrowRelease, "bob", "carol", func(tx *Transaction, keys map[string]*testKey) {
+			tx.token.TokenValue = decimal.NewFromInt(4)
+		}, ErrInvalidEscrow, ""},
+		{"unknown escrow", TxEscrowRelease, "bob", "carol", func(tx *Transaction, keys map[string]*testKey) {
+			tx.escrowID = "unknown"
+		}, ErrUnknownEscrow, ""},
+		{"no escrow", TxEscrowRelease, "bob", "carol", func(tx *Transaction, keys map[string]*testKey) {
+			tx.escrowID = ""
+		}, ErrInvalidEscrow, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			keys := map[string]*testKey{}
+			for _, name := range []string{"alice", "bob", "carol", "dave"} {
+				keys[name] = newTestKey(t, bc)
+			}
+			alice, bob := keys["alice"], keys["bob"]
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 3)
+			lock := lockEscrow(bc, alice, bob.address, &Escrow{Arbiter: keys["carol"].address}, 1)
+			if err := bc.AddTransaction(lock); err != nil {
+				t.Fatal(err)
+			}
+			mine(t, bc, 1)
+
+			settle := func() *Transaction {
+				payee := bob.address
+				if tt.txType == TxEscrowRefund {
+					payee = alice.address
+				}
+				sender := keys[tt.sender]
+				tx := NewTransaction(sender.address, payee, Token{TokenName: "DNZ", TokenValue: decimal.NewFromInt(5)})
+				tx.SetDecimals(NativeTokenDecimals)
+				tx.SetEscrowSettle(tt.txType, lock.ID())
+				if tt.edit != nil {
+					tt.edit(tx, keys)
+				}
+				sign(bc, sender, tx, bc.NextNonce(sender.address))
+				if tt.cosigner != "" {
+					countersign(tx, keys[tt.cosigner])
+				}
+				return tx
+			}
+			if err := bc.AddTransaction(settle()); !errors.Is(err, tt.want) {
+				t.Fatalf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+
+			mine(t, bc, 1)
+			if info, _ := bc.Escrow(lock.ID()); info.State != tt.state {
+				t.Errorf("state %s, want %s", info.State, tt.state)
+			}
+			if tt.txType == TxEscrowRelease && !bc.CalculateTotalAmount(bob.address, "DNZ").Equal(decimal.NewFromInt(5)) {
+				t.Error("recipient was not paid")
+			}
+			if err := bc.AddTransaction(settle()); err != ErrEscrowSettled {
+				t.Errorf("second settlement: AddTransaction() = %v, want %v", err, ErrEscrowSettled)
+			}
+		})
+	}
+}
+
+func TestEscrowCosignedOtherTerms(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	alice, bob, carol := newTestKey(t, bc), newTestKey(t, bc), newTestKey(t, bc)
+	bc.blockchainAddress = alice.address
+	mine(t, bc, 3)
+	lock := lockEscrow(bc, alice, bob.address, &Escrow{Arbiter: carol.address}, 1)
+	if err := bc.AddTransaction(lock); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, 1)
+
+	tx := NewTransaction(bob.address, bob.address, Token{TokenName: "DNZ", TokenValue: decimal.NewFromInt(5)})
+	tx.SetDecimals(NativeTokenDecimals)
+	sign(bc, bob, tx, 1)
+	tx.SetEscrowSettle(TxEscrowRelease, "other")
+	countersign(tx, carol)
+	tx.SetEscrowSettle(TxEscrowRelease, lock.ID())
+	sign(bc, bob, tx, 1)
+	if err := bc.AddTransaction(tx); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("AddTransaction() = %v, want %v", err, ErrInvalidSignature)
+	}
+}
@@ -0,0 +1,57 @@
+package block
+
+import (
+	"sync"
+	"time"
+)
+
+const eventBufferSize = 64
+
+const (
+	EventTransactionEvicted = "transaction_evicted"
+)
+
+type Event struct {
+	Type      string      `json:"type"`
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+type eventBus struct {
+	mux         sync.Mutex
+	subscribers map[chan *Event]struct{}
+}
+
+// Subscribe registers a listener for node events. The returned function must
+// be called to unsubscribe. Slow listeners miss events rather than block the
+// node.
+func (bc *Blockchain) Subscribe() (<-chan *Event, func()) {
+	ch := make(chan *Event, eventBufferSize)
+
+	bc.events.mux.Lock()
+	if bc.events.subscribers == nil {
+		bc.events.subscribers = make(map[chan *Event]struct{})
+	}
+	bc.events.subscribers[ch] = struct{}{}
+	bc.events.mux.Unlock()
+
+	return ch, func() {
+		bc.events.mux.Lock()
+		delete(bc.events.subscribers, ch)
+		bc.events.mux.Unlock()
+	}
+}
+
+func (bc *Blockchain) publish(eventType string, data interface{}) {
+	e := &Event{Type: eventType, Timestamp: time.Now().Unix(), Data: data}
+
+	bc.events.mux.Lock()
+	defer bc.events.mux.Unlock()
+
+	for ch := range bc.events.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
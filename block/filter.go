@@ -0,0 +1,325 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Every block has a compact filter over the addresses its transactions
+// touch, a Golomb-coded set as in BIP 158. A wallet downloads the filters,
+// tests its addresses locally and only fetches the blocks that match,
+// without telling a node which addresses it is interested in. Filters can
+// give false positives at a rate of 1/filterM, never false negatives.
+//
+// Addresses are hashed with the block hash as key to 64 bits, mapped onto
+// [0, N*filterM) and sorted. The differences between neighbours are Rice
+// coded with filterP bits of remainder and prefixed with N as a uvarint.
+// Each filter header chains the hash of the filter to the previous header,
+// so filters served by different nodes can be compared.
+const (
+	filterKeyPrefix = "f/"
+	filterP         = 19
+	filterM         = 784931
+	// MaxFilters is the most filters Filters returns at once.
+	MaxFilters = 1000
+)
+
+var ErrInvalidFilter = errors.New("invalid block filter")
+
+// BlockFilter is the compact filter of the block at Height.
+type BlockFilter struct {
+	Height    int64  `json:"height"`
+	BlockHash string `json:"block_hash"`
+	Filter    string `json:"filter"`
+	Header    string `json:"filter_header"`
+}
+
+// Addresses lists the addresses t touches: its sender and recipients and
+// the arbiter of an escrow. The genesis sender is left out.
+func (t *Transaction) Addresses() []string {
+	addresses := make([]string, 0, 2)
+	add := func(a string) {
+		if a != "" && a != GenesisSender {
+			addresses = append(addresses, a)
+		}
+	}
+	add(t.senderBlockchainAddress)
+	add(t.recipientBlockchainAddress)
+	for _, o := range t.outputs {
+		add(o.RecipientBlockchainAddress)
+	}
+	if t.escrow != nil {
+		add(t.escrow.Arbiter)
+	}
+	return addresses
+}
+
+// filterItems returns the distinct addresses of the transactions of b,
+// leaving out the mining sender.
+func (bc *Blockchain) filterItems(b *Block) []string {
+	seen := make(map[string]bool)
+	items := make([]string, 0)
+	for _, t := range b.transactions {
+		for _, a := range t.Addresses() {
+			if a != bc.conf.MiningSender && !seen[a] {
+				seen[a] = true
+				items = append(items, a)
+			}
+		}
+	}
+	return items
+}
+
+// buildFilter builds the filter of the block at height on top of the header
+// of the previous filter.
+func (bc *Blockchain) buildFilter(b *Block, height int64, previousHeader [32]byte) *BlockFilter {
+	hash := b.Hash()
+	filter := encodeFilter(hash, bc.filterItems(b))
+	return &BlockFilter{
+		Height:    height,
+		BlockHash: fmt.Sprintf("%x", hash),
+		Filter:    hex.EncodeToString(filter),
+		Header:    fmt.Sprintf("%x", filterHeader(filter, previousHeader)),
+	}
+}
+
+func filterHeader(filter []byte, previousHeader [32]byte) [32]byte {
+	h := sha256.Sum256(filter)
+	return sha256.Sum256(append(h[:], previousHeader[:]...))
+}
+
+// filterValues hashes items onto [0, n*filterM) in ascending order.
+func filterValues(key [32]byte, n uint64, items []string) []uint64 {
+	values := make([]uint64, len(items))
+	for i, item := range items {
+		h := sha256.Sum256(append(key[:], item...))
+		values[i], _ = bits.Mul64(binary.BigEndian.Uint64(h[:8]), n*filterM)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
+
+func encodeFilter(key [32]byte, items []string) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(items)))
+	w := &bitWriter{}
+	var last uint64
+	for _, v := range filterValues(key, uint64(len(items)), items) {
+		delta := v - last
+		last = v
+		for q := delta >> filterP; q > 0; q-- {
+			w.writeBit(1)
+		}
+		w.writeBit(0)
+		w.writeBits(delta, filterP)
+	}
+	return append(out, w.bytes...)
+}
+
+func decodeFilter(filter []byte) ([]uint64, uint64, error) {
+	n, read := binary.Uvarint(filter)
+	if read <= 0 || n > uint64(len(filter))*8 {
+		return nil, 0, ErrInvalidFilter
+	}
+	r := &bitReader{bytes: filter[read:]}
+	values := make([]uint64, n)
+	var last uint64
+	for i := range values {
+		var q uint64
+		for {
+			b, ok := r.readBit()
+			if !ok {
+				return nil, 0, ErrInvalidFilter
+			}
+			if b == 0 {
+				break
+			}
+			q++
+		}
+		rem, ok := r.readBits(filterP)
+		if !ok {
+			return nil, 0, ErrInvalidFilter
+		}
+		last += q<<filterP | rem
+		values[i] = last
+	}
+	return values, n, nil
+}
+
+// Match reports whether any of addresses may be in the block of the filter.
+func (bf *BlockFilter) Match(addresses ...string) (bool, error) {
+	key, err := decodeHash(bf.BlockHash)
+	if err != nil {
+		return false, ErrInvalidFilter
+	}
+	filter, err := hex.DecodeString(bf.Filter)
+	if err != nil {
+		return false, ErrInvalidFilter
+	}
+	values, n, err := decodeFilter(filter)
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	queries := filterValues(key, n, addresses)
+	for i, j := 0, 0; i < len(values) && j < len(queries); {
+		switch {
+		case values[i] == queries[j]:
+			return true, nil
+		case values[i] < queries[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return false, nil
+}
+
+// Verify checks that the filter chains onto the filter of the previous
+// block, which is nil for the genesis block.
+func (bf *BlockFilter) Verify(previous *BlockFilter) error {
+	var previousHeader [32]byte
+	if previous != nil {
+		var err error
+		if previousHeader, err = decodeHash(previous.Header); err != nil {
+			return ErrInvalidFilter
+		}
+	}
+	filter, err := hex.DecodeString(bf.Filter)
+	if err != nil {
+		return ErrInvalidFilter
+	}
+	if fmt.Sprintf("%x", filterHeader(filter, previousHeader)) != bf.Header {
+		return fmt.Errorf("%w: header mismatch", ErrInvalidFilter)
+	}
+	return nil
+}
+
+type bitWriter struct {
+	bytes []byte
+	n     uint
+}
+
+func (w *bitWriter) writeBit(b uint64) {
+	if w.n%8 == 0 {
+		w.bytes = append(w.bytes, 0)
+	}
+	if b != 0 {
+		w.bytes[len(w.bytes)-1] |= 0x80 >> (w.n % 8)
+	}
+	w.n++
+}
+
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for i := n; i > 0; i-- {
+		w.writeBit(v >> (i - 1) & 1)
+	}
+}
+
+type bitReader struct {
+	bytes []byte
+	n     uint
+}
+
+func (r *bitReader) readBit() (uint64, bool) {
+	if r.n/8 >= uint(len(r.bytes)) {
+		return 0, false
+	}
+	b := r.bytes[r.n/8] >> (7 - r.n%8) & 1
+	r.n++
+	return uint64(b), true
+}
+
+func (r *bitReader) readBits(n uint) (uint64, bool) {
+	var v uint64
+	for ; n > 0; n-- {
+		b, ok := r.readBit()
+		if !ok {
+			return 0, false
+		}
+		v = v<<1 | b
+	}
+	return v, true
+}
+
+func filterKey(height int64) []byte {
+	return []byte(fmt.Sprintf("%s%016x", filterKeyPrefix, height))
+}
+
+func saveFilter(wb *badger.WriteBatch, f *BlockFilter) error {
+	m, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return wb.Set(filterKey(f.Height), m)
+}
+
+// filter loads the filter of the block at height.
+func (bc *Blockchain) filter(height int64) (*BlockFilter, error) {
+	var f BlockFilter
+	err := bc.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(filterKey(height))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &f)
+		})
+	})
+	return &f, err
+}
+
+// previousFilterHeader returns the header of the filter below height, zero
+// for the genesis block.
+func (bc *Blockchain) previousFilterHeader(height int64) ([32]byte, error) {
+	if height == 0 {
+		return [32]byte{}, nil
+	}
+	f, err := bc.filter(height - 1)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return decodeHash(f.Header)
+}
+
+// Filters returns up to MaxFilters filters from height from to height to,
+// inclusive. A zero to means the tip.
+func (bc *Blockchain) Filters(from int64, to int64) ([]*BlockFilter, error) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	tip := int64(len(bc.chain)) - 1
+	if to == 0 || to > tip {
+		to = tip
+	}
+	if from < 0 {
+		from = 0
+	}
+	filters := make([]*BlockFilter, 0)
+	for height := from; height <= to && len(filters) < MaxFilters; height++ {
+		f, err := bc.filter(height)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// Block returns the block at height. On a pruned node old blocks come
+// without their body.
+func (bc *Blockchain) Block(height int64) (*Block, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if height < 0 || height >= int64(len(bc.chain)) {
+		return nil, false
+	}
+	return bc.chain[height], true
+}
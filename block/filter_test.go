@@ -0,0 +1,108 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeFilter(t *testing.T) {
+	key := sha256.Sum256([]byte("block"))
+	tests := []struct {
+		name  string
+		items []string
+		want  string
+	}{
+		{"empty", nil, "00"},
+		{"one address", []string{"alice"}, "015bc0f0"},
+		{"three addresses", []string{"alice", "bob", "carol"}, "0339956acd6bdf1b60"},
+		{"order does not matter", []string{"carol", "alice", "bob"}, "0339956acd6bdf1b60"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := encodeFilter(key, tt.items)
+			if got := hex.EncodeToString(filter); got != tt.want {
+				t.Errorf("encodeFilter() = %s, want %s", got, tt.want)
+			}
+			values, n, err := decodeFilter(filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filterValues(key, uint64(len(tt.items)), tt.items); n != uint64(len(tt.items)) || !reflect.DeepEqual(values, want) {
+				t.Errorf("decodeFilter() = %v, %d, want %v, %d", values, n, want, len(tt.items))
+			}
+		})
+	}
+}
+
+func TestDecodeFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		want    []uint64
+		wantErr bool
+	}{
+		{"empty", "00", []uint64{}, false},
+		{"remainder only", "01000050", []uint64{5}, false},
+		{"quotient", "01800018", []uint64{1<<filterP + 3}, false},
+		{"two values", "02000058000180", []uint64{5, 1<<filterP + 8}, false},
+		{"no count", "", nil, true},
+		{"truncated remainder", "010000", nil, true},
+		{"unterminated quotient", "01ff", nil, true},
+		{"count beyond data", "0a00", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, _ := hex.DecodeString(tt.filter)
+			values, _, err := decodeFilter(filter)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidFilter) {
+					t.Errorf("decodeFilter() = %v, want %v", err, ErrInvalidFilter)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(values, tt.want) {
+				t.Errorf("decodeFilter() = %v, %v, want %v", values, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	hash := sha256.Sum256([]byte("block"))
+	filter := func(items ...string) *BlockFilter {
+		return &BlockFilter{BlockHash: fmt.Sprintf("%x", hash), Filter: hex.EncodeToString(encodeFilter(hash, items))}
+	}
+	tests := []struct {
+		name      string
+		filter    *BlockFilter
+		addresses []string
+		want      bool
+		wantErr   bool
+	}{
+		{"member", filter("alice", "bob", "carol"), []string{"bob"}, true, false},
+		{"one of several", filter("alice", "bob", "carol"), []string{"dave", "carol"}, true, false},
+		{"not a member", filter("alice", "bob", "carol"), []string{"dave"}, false, false},
+		{"empty filter", filter(), []string{"alice"}, false, false},
+		{"other block", &BlockFilter{BlockHash: fmt.Sprintf("%x", [32]byte{}), Filter: filter("alice").Filter}, []string{"alice"}, false, false},
+		{"invalid hash", &BlockFilter{BlockHash: "zz", Filter: "00"}, []string{"alice"}, false, true},
+		{"invalid filter", &BlockFilter{BlockHash: fmt.Sprintf("%x", hash), Filter: "01ff"}, []string{"alice"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.filter.Match(tt.addresses...)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidFilter) {
+					t.Errorf("Match() = %v, want %v", err, ErrInvalidFilter)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Match() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"main/config"
+	"main/utils"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// GenesisSender is the sender of the allocation transactions in the genesis
+// block. It is not a spendable address.
+const GenesisSender = "GENESIS"
+
+// RewardSchedule controls the emission of the reward token. The reward is
+// halved every HalvingInterval blocks but never drops below MinReward, and no
+// block may mint beyond SupplyCap. A zero interval or cap disables halvings or
+// the cap.
+type RewardSchedule struct {
+	Token           string          `json:"token"`
+	InitialReward   decimal.Decimal `json:"initial_reward"`
+	HalvingInterval int64           `json:"halving_interval"`
+	MinReward       decimal.Decimal `json:"min_reward"`
+	SupplyCap       decimal.Decimal `json:"supply_cap"`
+}
+
+// Reward returns the reward of the block at height, given the supply of the
+// reward token circulating before that block.
+func (rs RewardSchedule) Reward(height int64, circulating decimal.Decimal) decimal.Decimal {
+	reward := rs.InitialReward
+	if rs.HalvingInterval > 0 {
+		two := decimal.NewFromInt(2)
+		for i := height / rs.HalvingInterval; i > 0 && reward.GreaterThan(rs.MinReward); i-- {
+			reward = reward.Div(two)
+		}
+		reward = reward.Truncate(NativeTokenDecimals)
+		if reward.LessThan(rs.MinReward) {
+			reward = rs.MinReward
+		}
+	}
+
+	if rs.SupplyCap.IsPositive() {
+		remaining := rs.SupplyCap.Sub(circulating)
+		if !remaining.IsPositive() {
+			return decimal.Zero
+		}
+		if reward.GreaterThan(remaining) {
+			reward = remaining
+		}
+	}
+	return reward
+}
+
+// Genesis describes the first block of a network and the chain parameters
+// every node of that network has to agree on.
+type Genesis struct {
+	NetworkID      string         `json:"network_id"`
+	Timestamp      int64          `json:"timestamp"`
+	Difficulty     int            `json:"difficulty"`
+	RewardSchedule RewardSchedule `json:"reward_schedule"`
+	// CoinbaseMaturity is the number of blocks after which a mining reward
+	// may be spent. A reward mined at height h is spendable from block
+	// h+CoinbaseMaturity on.
+	CoinbaseMaturity int64 `json:"coinbase_maturity"`
+	// GasPrice is what a deploy or call pays per unit of gas it uses, in
+	// the reward token. The fee is taken from the sender and paid to the
+	// miner of the block along with its reward.
+	GasPrice    decimal.Decimal                       `json:"gas_price"`
+	Allocations map[string]map[string]decimal.Decimal `json:"allocations"`
+}
+
+// DefaultGenesis is used when no genesis file is configured. Its parameters
+// come from the environment, so only nodes sharing that configuration end up
+// on the same network.
+func DefaultGenesis(conf config.Blockchain, network config.Network) *Genesis {
+	return &Genesis{
+		NetworkID:  "gochain-" + network.Name,
+		Difficulty: conf.Difficulty,
+		RewardSchedule: RewardSchedule{
+			Token:           conf.DefaultRewardToken,
+			InitialReward:   utils.FloatToDecimal(conf.MiningReward),
+			HalvingInterval: conf.HalvingInterval,
+			MinReward:       utils.FloatToDecimal(conf.MinReward),
+			SupplyCap:       utils.FloatToDecimal(conf.SupplyCap),
+		},
+		CoinbaseMaturity: conf.CoinbaseMaturity,
+		GasPrice:         utils.FloatToDecimal(conf.GasPrice),
+		Allocations:      map[string]map[string]decimal.Decimal{},
+	}
+}
+
+func LoadGenesis(path string) (*Genesis, error) {
+	m, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGenesis(m)
+}
+
+func ParseGenesis(m []byte) (*Genesis, error) {
+	g := new(Genesis)
+	if err := json.Unmarshal(m, g); err != nil {
+		return nil, fmt.Errorf("genesis: %v", err)
+	}
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *Genesis) Validate() error {
+	if g.NetworkID == "" {
+		return errors.New("genesis: network_id is required")
+	}
+	if g.Difficulty <= 0 || g.Difficulty > sha256.Size*2 {
+		return fmt.Errorf("genesis: invalid difficulty %d", g.Difficulty)
+	}
+	if g.RewardSchedule.Token == "" {
+		return errors.New("genesis: reward_schedule.token is required")
+	}
+	rs := g.RewardSchedule
+	if rs.InitialReward.IsNegative() {
+		return errors.New("genesis: reward_schedule.initial_reward must not be negative")
+	}
+	if rs.HalvingInterval < 0 {
+		return errors.New("genesis: reward_schedule.halving_interval must not be negative")
+	}
+	if rs.MinReward.IsNegative() || rs.MinReward.GreaterThan(rs.InitialReward) {
+		return errors.New("genesis: reward_schedule.min_reward must be between 0 and initial_reward")
+	}
+	if rs.SupplyCap.IsNegative() {
+		return errors.New("genesis: reward_schedule.supply_cap must not be negative")
+	}
+	if g.CoinbaseMaturity < 0 {
+		return errors.New("genesis: coinbase_maturity must not be negative")
+	}
+	if g.GasPrice.IsNegative() {
+		return errors.New("genesis: gas_price must not be negative")
+	}
+	if !fitsDecimals(g.GasPrice, NativeTokenDecimals) {
+		return fmt.Errorf("genesis: gas_price has more than %d decimals", NativeTokenDecimals)
+	}
+	for name, value := range map[string]decimal.Decimal{
+		"initial_reward": rs.InitialReward, "min_reward": rs.MinReward, "supply_cap": rs.SupplyCap,
+	} {
+		if !fitsDecimals(value, NativeTokenDecimals) {
+			return fmt.Errorf("genesis: reward_schedule.%s has more than %d decimals", name, NativeTokenDecimals)
+		}
+	}
+	for address, tokens := range g.Allocations {
+		for tokenName, value := range tokens {
+			if !value.IsPositive() {
+				return fmt.Errorf("genesis: allocation of %s to %s must be positive", tokenName, address)
+			}
+			if !fitsDecimals(value, NativeTokenDecimals) {
+				return fmt.Errorf("genesis: allocation of %s to %s has more than %d decimals", tokenName, address, NativeTokenDecimals)
+			}
+		}
+	}
+	if allocated := g.Allocated(rs.Token); rs.SupplyCap.IsPositive() && allocated.GreaterThan(rs.SupplyCap) {
+		return fmt.Errorf("genesis: allocations of %s exceed the supply cap", rs.Token)
+	}
+	return nil
+}
+
+// Fee is what a deploy or call that used gasUsed gas pays.
+func (g *Genesis) Fee(gasUsed uint64) decimal.Decimal {
+	return g.GasPrice.Mul(decimal.NewFromInt(int64(gasUsed)))
+}
+
+// Allocated sums the genesis allocations of a token.
+func (g *Genesis) Allocated(tokenName string) decimal.Decimal {
+	total := decimal.Zero
+	for _, tokens := range g.Allocations {
+		total = total.Add(tokens[tokenName])
+	}
+	return total
+}
+
+// CheckAddresses verifies that every allocation goes to an address of the
+// network with the given version byte.
+func (g *Genesis) CheckAddresses(version byte) error {
+	for address := range g.Allocations {
+		if err := utils.ValidateAddress(address, version); err != nil {
+			return fmt.Errorf("genesis: %v", err)
+		}
+	}
+	return nil
+}
+
+// Hash commits to the whole genesis configuration. It is used as the
+// previous hash of the genesis block, so nodes with a different network ID or
+// parameters end up with a different genesis block.
+func (g *Genesis) Hash() [32]byte {
+	m, _ := json.Marshal(g)
+	return sha256.Sum256(m)
+}
+
+// Block builds the genesis block. Allocations are ordered by address and
+// token name so that every node produces the same block. Its state holds the
+// allocations.
+func (g *Genesis) Block() *Block {
+	addresses := make([]string, 0, len(g.Allocations))
+	for address := range g.Allocations {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	transactions := make([]*Transaction, 0)
+	for _, address := range addresses {
+		tokenNames := make([]string, 0, len(g.Allocations[address]))
+		for tokenName := range g.Allocations[address] {
+			tokenNames = append(tokenNames, tokenName)
+		}
+		sort.Strings(tokenNames)
+
+		for _, tokenName := range tokenNames {
+			token := Token{TokenName: tokenName, TokenValue: g.Allocations[address][tokenName]}
+			t := NewTransaction(GenesisSender, address, token)
+			t.decimals = NativeTokenDecimals
+			transactions = append(transactions, t)
+		}
+	}
+
+	balances := make(map[string]decimal.Decimal)
+	for _, t := range transactions {
+		balances[spendKey(t.recipientBlockchainAddress, t.token.TokenName)] = t.token.TokenValue
+	}
+
+	return &Block{
+		timestamp:        g.Timestamp * int64(time.Second),
+		nonce:            0,
+		previousHash:     g.Hash(),
+		transactionsRoot: TransactionsRoot(transactions),
+		stateRoot:        newStateTree(balances, nil, nil).root(),
+		transactions:     transactions,
+	}
+}
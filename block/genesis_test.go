@@ -0,0 +1,222 @@
+package block
+
+import (
+	"encoding/json"
+	"errors"
+	"main/config"
+	"main/utils"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func validGenesis(alice string) *Genesis {
+	return &Genesis{
+		NetworkID:  "gochain-test",
+		Difficulty: 3,
+		RewardSchedule: RewardSchedule{
+			Token:           "DNZ",
+			InitialReward:   decimal.NewFromInt(50),
+			HalvingInterval: 10,
+			MinReward:       decimal.NewFromInt(1),
+			SupplyCap:       decimal.NewFromInt(1000),
+		},
+		CoinbaseMaturity: 10,
+		GasPrice:         decimal.RequireFromString("0.00000001"),
+		Allocations:      map[string]map[string]decimal.Decimal{alice: {"DNZ": decimal.NewFromInt(100)}},
+	}
+}
+
+func TestParseGenesis(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(g *Genesis)
+		wantErr bool
+	}{
+		{"valid", func(g *Genesis) {}, false},
+		{"no cap", func(g *Genesis) { g.RewardSchedule.SupplyCap = decimal.Zero }, false},
+		{"no network", func(g *Genesis) { g.NetworkID = "" }, true},
+		{"no difficulty", func(g *Genesis) { g.Difficulty = 0 }, true},
+		{"difficulty beyond the hash", func(g *Genesis) { g.Difficulty = 65 }, true},
+		{"no reward token", func(g *Genesis) { g.RewardSchedule.Token = "" }, true},
+		{"negative reward", func(g *Genesis) { g.RewardSchedule.InitialReward = decimal.NewFromInt(-1) }, true},
+		{"negative halving interval", func(g *Genesis) { g.RewardSchedule.HalvingInterval = -1 }, true},
+		{"min reward above reward", func(g *Genesis) { g.RewardSchedule.MinReward = decimal.NewFromInt(51) }, true},
+		{"negative cap", func(g *Genesis) { g.RewardSchedule.SupplyCap = decimal.NewFromInt(-1) }, true},
+		{"reward decimals", func(g *Genesis) { g.RewardSchedule.InitialReward = decimal.RequireFromString("0.000000001") }, true},
+		{"negative maturity", func(g *Genesis) { g.CoinbaseMaturity = -1 }, true},
+		{"negative gas price", func(g *Genesis) { g.GasPrice = decimal.NewFromInt(-1) }, true},
+		{"gas price decimals", func(g *Genesis) { g.GasPrice = decimal.RequireFromString("0.000000001") }, true},
+		{"zero allocation", func(g *Genesis) {
+			g.Allocations["other"] = map[string]decimal.Decimal{"DNZ": decimal.Zero}
+		}, true},
+		{"allocation decimals", func(g *Genesis) {
+			g.Allocations["other"] = map[string]decimal.Decimal{"DNZ": decimal.RequireFromString("0.000000001")}
+		}, true},
+		{"allocations beyond the cap", func(g *Genesis) {
+			g.Allocations["other"] = map[string]decimal.Decimal{"DNZ": decimal.NewFromInt(901)}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := validGenesis("alice")
+			tt.edit(g)
+			m, _ := json.Marshal(g)
+			if _, err := ParseGenesis(m); (err != nil) != tt.wantErr {
+				t.Errorf("ParseGenesis() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := ParseGenesis([]byte("{")); err == nil {
+		t.Error("ParseGenesis() of invalid JSON succeeded")
+	}
+}
+
+func TestGenesisBlock(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+	g := validGenesis(alice.address)
+	g.Allocations[bob.address] = map[string]decimal.Decimal{"DNZ": decimal.NewFromInt(5), "GOLD": decimal.NewFromInt(7)}
+
+	b := g.Block()
+	for i := 0; i < 5; i++ {
+		if g.Block().Hash() != b.Hash() {
+			t.Fatal("genesis block depends on the order of the allocations")
+		}
+	}
+	if len(b.transactions) != 3 {
+		t.Errorf("%d allocations, want 3", len(b.transactions))
+	}
+	if got := g.Allocated("DNZ"); !got.Equal(decimal.NewFromInt(105)) {
+		t.Errorf("Allocated() = %s, want 105", got)
+	}
+	if err := g.CheckAddresses(bc.network.AddressVersion); err != nil {
+		t.Errorf("CheckAddresses() = %v", err)
+	}
+	if err := g.CheckAddresses(bc.network.AddressVersion + 1); err == nil {
+		t.Error("CheckAddresses() accepted addresses of another network")
+	}
+
+	other := validGenesis(alice.address)
+	other.NetworkID = "gochain-other"
+	if other.Block().Hash() == validGenesis(alice.address).Block().Hash() {
+		t.Error("networks with different IDs share their genesis block")
+	}
+}
+
+func TestCreateBlockchainRejectsOtherGenesis(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	conf := bc.conf
+	bc.db.Close()
+
+	conf.Difficulty++
+	if other, err := CreateBlockchain("", conf); err == nil {
+		other.db.Close()
+		t.Error("opened the database of another genesis")
+	}
+	conf.Difficulty--
+	same, err := CreateBlockchain("", conf)
+	if err != nil {
+		t.Fatalf("could not reopen the database: %v", err)
+	}
+	same.db.Close()
+}
+
+func TestNetworkGenesis(t *testing.T) {
+	for name, network := range config.Networks {
+		t.Run(name, func(t *testing.T) {
+			m, err := network.Genesis()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m == nil {
+				return
+			}
+			g, err := ParseGenesis(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if g.NetworkID != "gochain-"+name {
+				t.Errorf("network ID %s, want gochain-%s", g.NetworkID, name)
+			}
+			if err := g.CheckAddresses(network.AddressVersion); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestAddTransactionRejectsOtherNetwork(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	alice := newTestKey(t, bc)
+	bc.blockchainAddress = alice.address
+	mine(t, bc, 3)
+	mainnet := utils.AddressFromPublicKey(&alice.privateKey.PublicKey, config.Networks["mainnet"].AddressVersion)
+	if err := bc.AddTransaction(transfer(bc, alice, mainnet, 1, 1)); !errors.Is(err, utils.ErrInvalidAddress) {
+		t.Errorf("AddTransaction() = %v, want %v", err, utils.ErrInvalidAddress)
+	}
+}
+
+func TestReward(t *testing.T) {
+	schedule := validGenesis("alice").RewardSchedule
+	tests := []struct {
+		name        string
+		edit        func(rs *RewardSchedule)
+		height      int64
+		circulating int64
+		want        string
+	}{
+		{"first block", nil, 0, 0, "50"},
+		{"before the first halving", nil, 9, 0, "50"},
+		{"first halving", nil, 10, 0, "25"},
+		{"second halving", nil, 20, 0, "12.5"},
+		{"fourth halving", nil, 49, 0, "3.125"},
+		{"floor", nil, 60, 0, "1"},
+		{"far beyond the floor", nil, 1 << 40, 0, "1"},
+		{"no halvings", func(rs *RewardSchedule) { rs.HalvingInterval = 0 }, 1000, 0, "50"},
+		{"below the cap", nil, 0, 950, "50"},
+		{"reaching the cap", nil, 0, 990, "10"},
+		{"at the cap", nil, 0, 1000, "0"},
+		{"beyond the cap", nil, 0, 1100, "0"},
+		{"floor at the cap", nil, 60, 1000, "0"},
+		{"no cap", func(rs *RewardSchedule) { rs.SupplyCap = decimal.Zero }, 0, 5000, "50"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := schedule
+			if tt.edit != nil {
+				tt.edit(&rs)
+			}
+			got := rs.Reward(tt.height, decimal.NewFromInt(tt.circulating))
+			if !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("Reward(%d, %d) = %s, want %s", tt.height, tt.circulating, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiningStopsAtSupplyCap(t *testing.T) {
+	conf := newTestChain(t, "", 0, 0).conf
+	conf.DbSavePath = t.TempDir()
+	conf.SupplyCap = 20
+	bc, err := CreateBlockchain("", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.db.Close()
+	alice := newTestKey(t, bc)
+	bc.blockchainAddress = alice.address
+	mine(t, bc, 4)
+
+	for _, s := range bc.Supply() {
+		if s.TokenName != "DNZ" {
+			continue
+		}
+		if !s.Circulating.Equal(decimal.NewFromInt(20)) || !s.Remaining.IsZero() {
+			t.Errorf("supply %s, remaining %v, want the cap of 20", s.Circulating, s.Remaining)
+		}
+	}
+	if !bc.ValidChain(bc.Chain()) {
+		t.Error("chain at the cap is not valid")
+	}
+}
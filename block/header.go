@@ -0,0 +1,213 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrNotMined = errors.New("transaction has not been mined")
+
+// BlockHeader is what the hash and the proof of work of a block commit to.
+// The transactions are committed through the Merkle root of their hashes,
+// the balances after the block through the root of the state tree, so a
+// header alone is enough to check the proof of work and the proofs built
+// against it.
+type BlockHeader struct {
+	Timestamp        int64  `json:"timestamp"`
+	Nonce            int    `json:"nonce"`
+	PreviousHash     string `json:"previous_hash"`
+	TransactionsRoot string `json:"transactions_root"`
+	StateRoot        string `json:"state_root"`
+}
+
+func (h *BlockHeader) Hash() [32]byte {
+	m, _ := json.Marshal(h)
+	return sha256.Sum256(m)
+}
+
+// ValidProof reports whether the hash of the header starts with difficulty
+// zeros.
+func (h *BlockHeader) ValidProof(difficulty int) bool {
+	return strings.HasPrefix(fmt.Sprintf("%x", h.Hash()), strings.Repeat("0", difficulty))
+}
+
+// TransactionHash is the hash a transaction takes part in the transactions
+// root with. Unlike its ID it covers the public keys and co-signatures.
+func TransactionHash(t *Transaction) [32]byte {
+	m, _ := json.Marshal(t)
+	return sha256.Sum256(m)
+}
+
+// TransactionsRoot is the Merkle root of the hashes of transactions. Leaves
+// and inner nodes are hashed with distinct prefixes, an unpaired node moves
+// up a level unchanged. No transactions give the zero hash.
+func TransactionsRoot(transactions []*Transaction) [32]byte {
+	if len(transactions) == 0 {
+		return [32]byte{}
+	}
+	level := transactionLeaves(transactions)
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+// MerkleStep is one level of the path from a transaction to the
+// transactions root. Left tells whether Hash is the left sibling.
+type MerkleStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// transactionsPath returns the path from the transaction at index to the
+// transactions root. Levels at which the node is unpaired have no step.
+func transactionsPath(transactions []*Transaction, index int) []*MerkleStep {
+	path := make([]*MerkleStep, 0)
+	level := transactionLeaves(transactions)
+	for len(level) > 1 {
+		if sibling := index ^ 1; sibling < len(level) {
+			path = append(path, &MerkleStep{Hash: fmt.Sprintf("%x", level[sibling]), Left: sibling < index})
+		}
+		level = nextLevel(level)
+		index /= 2
+	}
+	return path
+}
+
+func transactionLeaves(transactions []*Transaction) [][32]byte {
+	leaves := make([][32]byte, len(transactions))
+	for i, t := range transactions {
+		leaves[i] = leafHash(TransactionHash(t))
+	}
+	return leaves
+}
+
+func nextLevel(level [][32]byte) [][32]byte {
+	next := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, nodeHash(level[i], level[i+1]))
+	}
+	return next
+}
+
+// TransactionProof proves that a transaction is included in a block.
+type TransactionProof struct {
+	Transaction *Transaction  `json:"transaction"`
+	BlockHeight int64         `json:"block_height"`
+	BlockHash   string        `json:"block_hash"`
+	Header      *BlockHeader  `json:"header"`
+	Index       int           `json:"index"`
+	Path        []*MerkleStep `json:"path"`
+}
+
+// Verify checks the proof against its header. Whether the header belongs to
+// the chain is up to the caller.
+func (tp *TransactionProof) Verify() error {
+	if tp.Header == nil || tp.Transaction == nil {
+		return fmt.Errorf("%w: header and transaction are required", ErrInvalidProof)
+	}
+	if fmt.Sprintf("%x", tp.Header.Hash()) != tp.BlockHash {
+		return fmt.Errorf("%w: header does not match the block hash", ErrInvalidProof)
+	}
+	root, err := decodeHash(tp.Header.TransactionsRoot)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+	h := leafHash(TransactionHash(tp.Transaction))
+	for _, step := range tp.Path {
+		sibling, err := decodeHash(step.Hash)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidProof, err)
+		}
+		if step.Left {
+			h = nodeHash(sibling, h)
+		} else {
+			h = nodeHash(h, sibling)
+		}
+	}
+	if h != root {
+		return fmt.Errorf("%w: root mismatch", ErrInvalidProof)
+	}
+	return nil
+}
+
+// MaxHeaders is the most headers Headers returns at once.
+const MaxHeaders = 2000
+
+// Headers returns up to MaxHeaders headers from height from on.
+func (bc *Blockchain) Headers(from int64) []*BlockHeader {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	headers := make([]*BlockHeader, 0)
+	for height := from; height >= 0 && height < int64(len(bc.chain)) && len(headers) < MaxHeaders; height++ {
+		headers = append(headers, bc.chain[height].Header())
+	}
+	return headers
+}
+
+// ProveTransaction returns the proof that the mined transaction id is
+// included in its block.
+func (bc *Blockchain) ProveTransaction(id string) (*TransactionProof, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	height, err := bc.receiptHeight(id)
+	if err != nil || height >= int64(len(bc.chain)) {
+		return nil, false
+	}
+	b := bc.chain[height]
+	for i, t := range b.transactions {
+		if t.ID() == id {
+			return &TransactionProof{
+				Transaction: t,
+				BlockHeight: height,
+				BlockHash:   fmt.Sprintf("%x", b.Hash()),
+				Header:      b.Header(),
+				Index:       i,
+				Path:        transactionsPath(b.transactions, i),
+			}, true
+		}
+	}
+	return nil, false
+}
+
+func leafHash(data ...[32]byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	for _, d := range data {
+		h.Write(d[:])
+	}
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+func nodeHash(left, right [32]byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left[:])
+	h.Write(right[:])
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// decodeHash parses a hex encoded hash.
+func decodeHash(s string) ([32]byte, error) {
+	var hash [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(hash) {
+		return hash, fmt.Errorf("invalid hash %q", s)
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
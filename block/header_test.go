@@ -0,0 +1,68 @@
+package block
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestTransactionProof(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+	bc.blockchainAddress = alice.address
+	mine(t, bc, 5)
+	var ids []string
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		tx := transfer(bc, alice, bob.address, int64(nonce), nonce)
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, tx.ID())
+	}
+	mine(t, bc, 1)
+
+	tests := []struct {
+		name   string
+		tamper func(p *TransactionProof)
+	}{
+		{"transaction", func(p *TransactionProof) {
+			tx := *p.Transaction
+			tx.token.TokenValue = tx.token.TokenValue.Add(decimal.NewFromInt(1))
+			p.Transaction = &tx
+		}},
+		{"path", func(p *TransactionProof) {
+			p.Path[0] = &MerkleStep{Hash: fmt.Sprintf("%x", [32]byte{1}), Left: p.Path[0].Left}
+		}},
+		{"side", func(p *TransactionProof) {
+			p.Path[0] = &MerkleStep{Hash: p.Path[0].Hash, Left: !p.Path[0].Left}
+		}},
+		{"header", func(p *TransactionProof) {
+			h := *p.Header
+			h.Nonce++
+			p.Header = &h
+		}},
+		{"block hash", func(p *TransactionProof) {
+			p.BlockHash = fmt.Sprintf("%x", [32]byte{})
+		}},
+	}
+	for _, id := range ids {
+		p, ok := bc.ProveTransaction(id)
+		if !ok {
+			t.Fatalf("no proof for %s", id)
+		}
+		if err := p.Verify(); err != nil {
+			t.Fatalf("proof of %s: %v", id, err)
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tampered, _ := bc.ProveTransaction(id)
+				tt.tamper(tampered)
+				if err := tampered.Verify(); !errors.Is(err, ErrInvalidProof) {
+					t.Errorf("Verify() = %v, want %v", err, ErrInvalidProof)
+				}
+			})
+		}
+	}
+}
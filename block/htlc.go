@@ -0,0 +1,221 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// A hash time-locked contract holds the funds of an htlc_lock transaction.
+// The recipient claims them with the preimage of the hash lock before the
+// timeout height, afterwards the sender can take them back with a refund.
+// Claiming reveals the preimage on chain, which lets the counterparty of a
+// cross-chain swap claim its side on the other chain.
+const (
+	TxHTLCLock   = "htlc_lock"
+	TxHTLCClaim  = "htlc_claim"
+	TxHTLCRefund = "htlc_refund"
+)
+
+const (
+	HTLCLocked   = "locked"
+	HTLCClaimed  = "claimed"
+	HTLCRefunded = "refunded"
+)
+
+var (
+	ErrInvalidHTLC = errors.New("invalid htlc")
+	ErrUnknownHTLC = errors.New("htlc does not exist")
+	ErrHTLCSettled = errors.New("htlc is already claimed or refunded")
+)
+
+// HTLC holds the terms of an htlc_lock transaction. HashLock is the hex
+// encoded SHA-256 hash of the secret, Timeout the block height from which on
+// the sender can refund.
+type HTLC struct {
+	HashLock string `json:"hash_lock"`
+	Timeout  int64  `json:"timeout"`
+}
+
+// HTLCSettle references the lock an htlc_claim or htlc_refund settles. Claims
+// carry the hex encoded preimage of the hash lock.
+type HTLCSettle struct {
+	ID       string `json:"htlc_id"`
+	Preimage string `json:"preimage,omitempty"`
+}
+
+// HTLCInfo is the state of a contract created by an htlc_lock transaction.
+type HTLCInfo struct {
+	ID        string          `json:"id"`
+	Sender    string          `json:"sender_blockchain_address"`
+	Recipient string          `json:"recipient_blockchain_address"`
+	TokenName string          `json:"token_name"`
+	Amount    decimal.Decimal `json:"token_value"`
+	Decimals  uint8           `json:"token_decimals"`
+	HashLock  string          `json:"hash_lock"`
+	Timeout   int64           `json:"timeout"`
+	Height    int64           `json:"height"`
+	State     string          `json:"state"`
+	Preimage  string          `json:"preimage,omitempty"`
+	SettledBy string          `json:"settled_by,omitempty"`
+}
+
+// SetHTLC turns t into an htlc_lock transaction.
+func (t *Transaction) SetHTLC(htlc *HTLC) {
+	t.txType = TxHTLCLock
+	t.htlc = htlc
+}
+
+// SetHTLCSettle turns t into an htlc_claim or htlc_refund transaction.
+func (t *Transaction) SetHTLCSettle(txType string, settle *HTLCSettle) {
+	t.txType = txType
+	t.settle = settle
+}
+
+func isHTLCSettle(txType string) bool {
+	return txType == TxHTLCClaim || txType == TxHTLCRefund
+}
+
+// applyHTLC records the effect of a valid transaction at height on the
+// contracts.
+func applyHTLC(htlcs map[string]*HTLCInfo, t *Transaction, height int64) {
+	switch t.txType {
+	case TxHTLCLock:
+		id := t.ID()
+		htlcs[id] = &HTLCInfo{
+			ID:        id,
+			Sender:    t.senderBlockchainAddress,
+			Recipient: t.recipientBlockchainAddress,
+			TokenName: t.token.TokenName,
+			Amount:    t.token.TokenValue,
+			Decimals:  t.decimals,
+			HashLock:  t.htlc.HashLock,
+			Timeout:   t.htlc.Timeout,
+			Height:    height,
+			State:     HTLCLocked,
+		}
+	case TxHTLCClaim, TxHTLCRefund:
+		if info, ok := htlcs[t.settle.ID]; ok {
+			info.State = HTLCClaimed
+			if t.txType == TxHTLCRefund {
+				info.State = HTLCRefunded
+			}
+			info.Preimage = t.settle.Preimage
+			info.SettledBy = t.ID()
+		}
+	}
+}
+
+// htlcs builds the contracts of chain.
+func (bc *Blockchain) htlcs(chain []*Block) map[string]*HTLCInfo {
+	s, start := bc.base(chain)
+	htlcs := cloneInfos(s.HTLCs)
+	for height := start; height < len(chain); height++ {
+		for _, t := range chain[height].transactions {
+			applyHTLC(htlcs, t, int64(height))
+		}
+	}
+	return htlcs
+}
+
+// validateHTLC checks a transaction mined at height against the contracts.
+// Claims must reveal the preimage before the timeout, refunds only work
+// from the timeout on. Both move exactly the locked amount.
+func validateHTLC(t *Transaction, htlcs map[string]*HTLCInfo, height int64) error {
+	switch t.txType {
+	case TxHTLCLock:
+		if t.htlc == nil {
+			return fmt.Errorf("%w: terms are missing", ErrInvalidHTLC)
+		}
+		if b, err := hex.DecodeString(t.htlc.HashLock); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("%w: hash lock must be a hex encoded SHA-256 hash", ErrInvalidHTLC)
+		}
+		if t.htlc.Timeout <= height {
+			return fmt.Errorf("%w: timeout must be above the current height %d", ErrInvalidHTLC, height)
+		}
+		if t.recipientBlockchainAddress == t.senderBlockchainAddress {
+			return fmt.Errorf("%w: sender and recipient must differ", ErrInvalidHTLC)
+		}
+		return nil
+	case TxHTLCClaim, TxHTLCRefund:
+	default:
+		return nil
+	}
+
+	if t.settle == nil {
+		return fmt.Errorf("%w: htlc_id is missing", ErrInvalidHTLC)
+	}
+	info, ok := htlcs[t.settle.ID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownHTLC, t.settle.ID)
+	}
+	if info.State != HTLCLocked {
+		return ErrHTLCSettled
+	}
+	if t.token.TokenName != info.TokenName || !t.token.TokenValue.Equal(info.Amount) || t.decimals != info.Decimals {
+		return fmt.Errorf("%w: amount must be the locked %s %s", ErrInvalidHTLC, info.Amount, info.TokenName)
+	}
+
+	if t.txType == TxHTLCRefund {
+		if t.senderBlockchainAddress != info.Sender {
+			return fmt.Errorf("%w: only the sender can refund", ErrInvalidHTLC)
+		}
+		if height < info.Timeout {
+			return fmt.Errorf("%w: refund is possible from height %d", ErrInvalidHTLC, info.Timeout)
+		}
+		if t.settle.Preimage != "" {
+			return fmt.Errorf("%w: a refund has no preimage", ErrInvalidHTLC)
+		}
+		return nil
+	}
+
+	if t.senderBlockchainAddress != info.Recipient {
+		return fmt.Errorf("%w: only the recipient can claim", ErrInvalidHTLC)
+	}
+	if height >= info.Timeout {
+		return fmt.Errorf("%w: htlc timed out at height %d", ErrInvalidHTLC, info.Timeout)
+	}
+	preimage, err := hex.DecodeString(t.settle.Preimage)
+	if err != nil {
+		return fmt.Errorf("%w: preimage must be hex encoded", ErrInvalidHTLC)
+	}
+	h := sha256.Sum256(preimage)
+	if hex.EncodeToString(h[:]) != info.HashLock {
+		return fmt.Errorf("%w: preimage does not match the hash lock", ErrInvalidHTLC)
+	}
+	return nil
+}
+
+// HTLC returns the state of the contract created by the htlc_lock
+// transaction with the given ID.
+func (bc *Blockchain) HTLC(id string) (*HTLCInfo, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	info, ok := bc.htlcs(bc.chain)[id]
+	return info, ok
+}
+
+// HTLCs returns the contracts the address locked or can claim, oldest first.
+func (bc *Blockchain) HTLCs(blockchainAddress string) []*HTLCInfo {
+	bc.mux.RLock()
+	htlcs := bc.htlcs(bc.chain)
+	bc.mux.RUnlock()
+
+	infos := make([]*HTLCInfo, 0)
+	for _, info := range htlcs {
+		if info.Sender == blockchainAddress || info.Recipient == blockchainAddress {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Height != infos[j].Height {
+			return infos[i].Height < infos[j].Height
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
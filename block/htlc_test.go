@@ -0,0 +1,140 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	testPreimage = hex.EncodeToString([]byte("secret"))
+	testHashLock = func() string {
+		h := sha256.Sum256([]byte("secret"))
+		return hex.EncodeToString(h[:])
+	}()
+)
+
+// lockHTLC signs an htlc_lock of 5 DNZ from sender to recipient.
+func lockHTLC(bc *Blockchain, sender *testKey, recipient string, htlc *HTLC, nonce uint64) *Transaction {
+	tx := NewTransaction(sender.address, recipient, Token{TokenName: "DNZ", TokenValue: decimal.NewFromInt(5)})
+	tx.SetDecimals(NativeTokenDecimals)
+	tx.SetHTLC(htlc)
+	return sign(bc, sender, tx, nonce)
+}
+
+func TestHTLCLock(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(tx *Transaction, height int64)
+		want error
+	}{
+		{"valid", func(tx *Transaction, height int64) {}, nil},
+		{"no terms", func(tx *Transaction, height int64) { tx.htlc = nil }, ErrInvalidHTLC},
+		{"hash lock not hex", func(tx *Transaction, height int64) { tx.htlc.HashLock = "secret" }, ErrInvalidHTLC},
+		{"short hash lock", func(tx *Transaction, height int64) { tx.htlc.HashLock = testPreimage }, ErrInvalidHTLC},
+		{"timeout at the current height", func(tx *Transaction, height int64) { tx.htlc.Timeout = height }, ErrInvalidHTLC},
+		{"to itself", func(tx *Transaction, height int64) {
+			tx.recipientBlockchainAddress = tx.senderBlockchainAddress
+		}, ErrInvalidHTLC},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 3)
+			height := int64(len(bc.Chain()))
+
+			tx := lockHTLC(bc, alice, bob.address, &HTLC{HashLock: testHashLock, Timeout: height + 3}, 1)
+			tt.edit(tx, height)
+			sign(bc, alice, tx, 1)
+			if err := bc.AddTransaction(tx); !errors.Is(err, tt.want) {
+				t.Errorf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTLCSettle(t *testing.T) {
+	tests := []struct {
+		name     string
+		blocks   int
+		txType   string
+		bySender bool
+		edit     func(settle *HTLCSettle, tx *Transaction)
+		want     error
+		state    string
+	}{
+		{"claim", 0, TxHTLCClaim, false, nil, nil, HTLCClaimed},
+		{"claim with the wrong preimage", 0, TxHTLCClaim, false, func(s *HTLCSettle, tx *Transaction) {
+			s.Preimage = hex.EncodeToString([]byte("guess"))
+		}, ErrInvalidHTLC, ""},
+		{"claim with a malformed preimage", 0, TxHTLCClaim, false, func(s *HTLCSettle, tx *Transaction) {
+			s.Preimage = "secret"
+		}, ErrInvalidHTLC, ""},
+		{"claim by the sender", 0, TxHTLCClaim, true, nil, ErrInvalidHTLC, ""},
+		{"claim of another amount", 0, TxHTLCClaim, false, func(s *HTLCSettle, tx *Transaction) {
+			tx.token.TokenValue = decimal.NewFromInt(6)
+		}, ErrInvalidHTLC, ""},
+		{"claim of an unknown htlc", 0, TxHTLCClaim, false, func(s *HTLCSettle, tx *Transaction) {
+			s.ID = "unknown"
+		}, ErrUnknownHTLC, ""},
+		{"claim after the timeout", 2, TxHTLCClaim, false, nil, ErrInvalidHTLC, ""},
+		{"refund", 2, TxHTLCRefund, true, nil, nil, HTLCRefunded},
+		{"refund before the timeout", 1, TxHTLCRefund, true, nil, ErrInvalidHTLC, ""},
+		{"refund by the recipient", 2, TxHTLCRefund, false, nil, ErrInvalidHTLC, ""},
+		{"refund with a preimage", 2, TxHTLCRefund, true, func(s *HTLCSettle, tx *Transaction) {
+			s.Preimage = testPreimage
+		}, ErrInvalidHTLC, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 3)
+			height := int64(len(bc.Chain()))
+			lock := lockHTLC(bc, alice, bob.address, &HTLC{HashLock: testHashLock, Timeout: height + 3}, 1)
+			if err := bc.AddTransaction(lock); err != nil {
+				t.Fatal(err)
+			}
+			mine(t, bc, 1+tt.blocks)
+
+			settle := func(nonce uint64) *Transaction {
+				sender := bob
+				if tt.bySender {
+					sender = alice
+					nonce++
+				}
+				s := &HTLCSettle{ID: lock.ID()}
+				if tt.txType == TxHTLCClaim {
+					s.Preimage = testPreimage
+				}
+				tx := NewTransaction(sender.address, "", Token{TokenName: "DNZ", TokenValue: decimal.NewFromInt(5)})
+				tx.SetDecimals(NativeTokenDecimals)
+				tx.SetHTLCSettle(tt.txType, s)
+				if tt.edit != nil {
+					tt.edit(s, tx)
+				}
+				return sign(bc, sender, tx, nonce)
+			}
+			if err := bc.AddTransaction(settle(1)); !errors.Is(err, tt.want) {
+				t.Fatalf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+
+			mine(t, bc, 1)
+			if info, _ := bc.HTLC(lock.ID()); info.State != tt.state {
+				t.Errorf("state %s, want %s", info.State, tt.state)
+			}
+			if err := bc.AddTransaction(settle(2)); err != ErrHTLCSettled {
+				t.Errorf("second settlement: AddTransaction() = %v, want %v", err, ErrHTLCSettled)
+			}
+		})
+	}
+}
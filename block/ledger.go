@@ -0,0 +1,100 @@
+package block
+
+import "github.com/shopspring/decimal"
+
+// entry is the change a transaction makes to the balance of one address in
+// one token. Credits are positive, debits negative.
+type entry struct {
+	address   string
+	tokenName string
+	amount    decimal.Decimal
+}
+
+// entries lists the balance changes of t. Genesis allocations, mining
+// rewards, token issuance and minting create new supply and only credit the
+// recipient. Burning only debits the sender, a swap moves one token each way.
+// An htlc_lock debits the sender and the claim or refund pays the locked
+// amount out to its own sender. Escrows work the same, except that the
+// settlement pays its recipient. Deploys and calls only pay their fee, the
+// coinbase pays the fees of its block out to the miner.
+func (bc *Blockchain) entries(t *Transaction) []entry {
+	if isContract(t.txType) {
+		if fee := bc.genesis.Fee(t.gasUsed); fee.IsPositive() {
+			return []entry{
+				{address: t.senderBlockchainAddress, tokenName: bc.genesis.RewardSchedule.Token, amount: fee.Neg()},
+			}
+		}
+		return nil
+	}
+
+	if t.txType == TxMultiTransfer {
+		entries := make([]entry, 0, 2*len(t.outputs))
+		for _, o := range t.outputs {
+			entries = append(entries,
+				entry{address: t.senderBlockchainAddress, tokenName: o.TokenName, amount: o.TokenValue.Neg()},
+				entry{address: o.RecipientBlockchainAddress, tokenName: o.TokenName, amount: o.TokenValue})
+		}
+		return entries
+	}
+
+	if t.txType == TxSwap {
+		return []entry{
+			{address: t.senderBlockchainAddress, tokenName: t.token.TokenName, amount: t.token.TokenValue.Neg()},
+			{address: t.recipientBlockchainAddress, tokenName: t.token.TokenName, amount: t.token.TokenValue},
+			{address: t.recipientBlockchainAddress, tokenName: t.swap.TokenName, amount: t.swap.TokenValue.Neg()},
+			{address: t.senderBlockchainAddress, tokenName: t.swap.TokenName, amount: t.swap.TokenValue},
+		}
+	}
+
+	if t.txType == TxHTLCLock || t.txType == TxEscrowLock {
+		return []entry{
+			{address: t.senderBlockchainAddress, tokenName: t.token.TokenName, amount: t.token.TokenValue.Neg()},
+		}
+	}
+
+	if isHTLCSettle(t.txType) {
+		return []entry{
+			{address: t.senderBlockchainAddress, tokenName: t.token.TokenName, amount: t.token.TokenValue},
+		}
+	}
+
+	if isEscrowSettle(t.txType) {
+		return []entry{
+			{address: t.recipientBlockchainAddress, tokenName: t.token.TokenName, amount: t.token.TokenValue},
+		}
+	}
+
+	if t.txType == TxBurn {
+		return []entry{
+			{address: t.senderBlockchainAddress, tokenName: t.token.TokenName, amount: t.token.TokenValue.Neg()},
+		}
+	}
+
+	if t.senderBlockchainAddress == bc.conf.MiningSender {
+		return []entry{
+			{address: t.recipientBlockchainAddress, tokenName: t.token.TokenName, amount: t.token.TokenValue.Add(t.fee)},
+		}
+	}
+
+	if t.txType == TxIssueToken || t.txType == TxMint || t.senderBlockchainAddress == GenesisSender {
+		return []entry{
+			{address: t.recipientBlockchainAddress, tokenName: t.token.TokenName, amount: t.token.TokenValue},
+		}
+	}
+
+	return []entry{
+		{address: t.senderBlockchainAddress, tokenName: t.token.TokenName, amount: t.token.TokenValue.Neg()},
+		{address: t.recipientBlockchainAddress, tokenName: t.token.TokenName, amount: t.token.TokenValue},
+	}
+}
+
+// debits returns the entries of t that take from a balance.
+func (bc *Blockchain) debits(t *Transaction) []entry {
+	debits := make([]entry, 0, 1)
+	for _, e := range bc.entries(t) {
+		if e.amount.IsNegative() {
+			debits = append(debits, e)
+		}
+	}
+	return debits
+}
@@ -0,0 +1,150 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCallFee(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+	bc.blockchainAddress = alice.address
+	mine(t, bc, 3)
+
+	deploy := NewTransaction(alice.address, "", Token{})
+	deploy.txType, deploy.deploy = TxDeploy, &ContractDeploy{Code: counterCode()}
+	sign(bc, alice, deploy, 1)
+	if err := bc.AddTransaction(deploy); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, 1)
+	contracts := bc.contracts(bc.Chain())
+	var address string
+	for a := range contracts {
+		address = a
+	}
+
+	before := bc.CalculateTotalAmount(alice.address, "DNZ")
+	supply := bc.Supply()[0].Circulating
+	call := NewTransaction(alice.address, address, Token{})
+	call.txType, call.call = TxCall, &ContractCall{GasLimit: 10000}
+	sign(bc, alice, call, 2)
+	if err := bc.AddTransaction(call); err != nil {
+		t.Fatal(err)
+	}
+	bc.blockchainAddress = bob.address
+	mine(t, bc, 1)
+
+	r, err := bc.Receipt(call.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee := bc.genesis.Fee(r.GasUsed)
+	if !fee.IsPositive() || !r.FeePaid.Equal(fee) {
+		t.Fatalf("fee paid = %s, want %s", r.FeePaid, fee)
+	}
+	if got, want := bc.CalculateTotalAmount(alice.address, "DNZ"), before.Sub(fee); !got.Equal(want) {
+		t.Errorf("caller balance = %s, want %s", got, want)
+	}
+	if got, want := bc.CalculateTotalAmount(bob.address, "DNZ"), decimal.NewFromInt(8).Add(fee); !got.Equal(want) {
+		t.Errorf("miner balance = %s, want %s", got, want)
+	}
+	if got, want := bc.Supply()[0].Circulating, supply.Add(decimal.NewFromInt(8)); !got.Equal(want) {
+		t.Errorf("supply = %s, want %s", got, want)
+	}
+	chain := bc.Chain()
+	if !bc.ValidChain(chain) {
+		t.Fatal("mined chain is not valid")
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(call *Transaction, coinbase *Transaction)
+	}{
+		{"fee kept by the miner", func(call *Transaction, coinbase *Transaction) {
+			coinbase.fee = coinbase.fee.Add(decimal.NewFromInt(1))
+		}},
+		{"fee not paid out", func(call *Transaction, coinbase *Transaction) {
+			coinbase.fee = decimal.Zero
+		}},
+		{"gas understated", func(call *Transaction, coinbase *Transaction) {
+			call.gasUsed--
+			coinbase.fee = bc.genesis.Fee(call.gasUsed)
+		}},
+		{"no gas", func(call *Transaction, coinbase *Transaction) {
+			call.gasUsed, coinbase.fee = 0, decimal.Zero
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tip := chain[len(chain)-1]
+			transactions := make([]*Transaction, len(tip.transactions))
+			for i, tx := range tip.transactions {
+				c := *tx
+				transactions[i] = &c
+			}
+			tt.tamper(transactions[0], transactions[len(transactions)-1])
+			forged := append([]*Block{}, chain[:len(chain)-1]...)
+			b := NewBlock(0, tip.previousHash, [32]byte{}, transactions)
+			storage := map[string]map[uint64]uint64{address: {0: 1}}
+			b.stateRoot = newStateTree(bc.balances(append(forged, b)), contracts, storage).root()
+			b.timestamp = tip.timestamp
+			b.nonce = bc.ProofOfWork(b)
+			if bc.ValidChain(append(forged, b)) {
+				t.Error("chain with a wrong fee is valid")
+			}
+		})
+	}
+}
+
+func TestDeployFee(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+	bc.blockchainAddress = alice.address
+	mine(t, bc, 3)
+
+	deploy := NewTransaction(alice.address, "", Token{})
+	deploy.SetDeploy(&ContractDeploy{Code: onceCode()})
+	sign(bc, alice, deploy, 1)
+	if err := bc.AddTransaction(deploy); err != nil {
+		t.Fatal(err)
+	}
+	// The fee of the first deploy is reserved, the second does not fit.
+	again := NewTransaction(alice.address, "", Token{})
+	again.SetDeploy(&ContractDeploy{Code: onceCode()})
+	sign(bc, alice, again, 2)
+	if err := bc.AddTransaction(again); err != ErrInsufficientBalance {
+		t.Fatalf("AddTransaction() = %v, want %v", err, ErrInsufficientBalance)
+	}
+
+	before := bc.CalculateTotalAmount(alice.address, "DNZ")
+	bc.blockchainAddress = bob.address
+	mine(t, bc, 1)
+	r, err := bc.Receipt(deploy.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gas := uint64(len(onceCode())/2) * DeployGasPerByte
+	fee := bc.genesis.Fee(gas)
+	if r.GasUsed != gas || !r.FeePaid.Equal(fee) {
+		t.Fatalf("receipt used %d gas for %s, want %d for %s", r.GasUsed, r.FeePaid, gas, fee)
+	}
+	if got, want := bc.CalculateTotalAmount(alice.address, "DNZ"), before.Sub(fee); !got.Equal(want) {
+		t.Errorf("deployer balance = %s, want %s", got, want)
+	}
+	if got, want := bc.CalculateTotalAmount(bob.address, "DNZ"), decimal.NewFromInt(8).Add(fee); !got.Equal(want) {
+		t.Errorf("miner balance = %s, want %s", got, want)
+	}
+
+	chain := bc.Chain()
+	if !bc.ValidChain(chain) {
+		t.Fatal("mined chain is not valid")
+	}
+	tip := chain[len(chain)-1].transactions
+	free, coinbase := *tip[0], *tip[len(tip)-1]
+	free.gasUsed, coinbase.fee = 0, decimal.Zero
+	if bc.ValidChain(reseal(bc, chain, []*Transaction{&free, &coinbase})) {
+		t.Error("chain with a free deploy is valid")
+	}
+}
@@ -0,0 +1,286 @@
+package block
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/shopspring/decimal"
+)
+
+const transactionPoolKey = "mp"
+
+// pendingTransaction is the on-disk form of a mempool entry. It keeps the
+// signed request so the entry can be verified again after a restart.
+type pendingTransaction struct {
+	Request *TransactionRequest `json:"request"`
+	AddedAt int64               `json:"added_at"`
+}
+
+func (t *Transaction) pending() *pendingTransaction {
+	return &pendingTransaction{Request: t.Request(), AddedAt: t.addedAt.UnixNano()}
+}
+
+func (bc *Blockchain) SaveTransactionPool() bool {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.saveTransactionPool()
+}
+
+func (bc *Blockchain) saveTransactionPool() bool {
+	pending := make([]*pendingTransaction, 0, len(bc.transactionPool))
+	for _, t := range bc.transactionPool {
+		if t.senderBlockchainAddress == bc.conf.MiningSender {
+			continue
+		}
+		pending = append(pending, t.pending())
+	}
+
+	m, err := json.Marshal(pending)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return false
+	}
+
+	err = bc.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(transactionPoolKey), m)
+	})
+	if err != nil {
+		log.Printf("ERROR: could not persist transaction pool: %v", err)
+		return false
+	}
+	return true
+}
+
+// LoadTransactionPool restores the persisted mempool, verifying every entry
+// again against the current chain state. Entries that are older than
+// MempoolMaxAge or no longer valid are dropped. Time-locked entries are kept
+// whatever their age, their age counts from when they unlock.
+func (bc *Blockchain) LoadTransactionPool() int {
+	var pending []*pendingTransaction
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	err := bc.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(transactionPoolKey))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &pending)
+		})
+	})
+	if err != nil {
+		log.Printf("ERROR: could not load transaction pool: %v", err)
+		return 0
+	}
+
+	height := int64(len(bc.chain))
+	now := time.Now()
+	restored := 0
+	for _, p := range pending {
+		if p.Request == nil {
+			log.Println("mempool: dropping malformed transaction")
+			continue
+		}
+		if err := p.Request.Validate(); err != nil {
+			log.Printf("mempool: dropping malformed transaction: %v", err)
+			continue
+		}
+
+		t := p.Request.Transaction()
+		t.addedAt = time.Unix(0, p.AddedAt)
+		if t.Locked(height, now) {
+			t.addedAt = now
+		} else if bc.conf.MempoolMaxAge > 0 && now.Sub(t.addedAt) > bc.conf.MempoolMaxAge {
+			log.Printf("mempool: dropping expired transaction added at %v", t.addedAt)
+			continue
+		}
+
+		if err := bc.validateTransaction(t, bc.transactionPool); err != nil {
+			log.Printf("mempool: dropping invalid transaction from %s: %v", t.senderBlockchainAddress, err)
+			continue
+		}
+
+		bc.transactionPool = append(bc.transactionPool, t)
+		restored++
+	}
+
+	bc.saveTransactionPool()
+	log.Printf("mempool: restored %d of %d pending transactions", restored, len(pending))
+	return restored
+}
+
+func spendKey(blockchainAddress string, tokenName string) string {
+	return blockchainAddress + "/" + tokenName
+}
+
+func splitSpendKey(key string) (string, string) {
+	i := strings.Index(key, "/")
+	return key[:i], key[i+1:]
+}
+
+// pendingSpends sums what each sender spends per token in transactions. The
+// gas of a pending call is not known yet, so its whole gas limit is reserved
+// for the fee, a deploy reserves the gas of its code.
+func (bc *Blockchain) pendingSpends(transactions []*Transaction) map[string]decimal.Decimal {
+	pending := make(map[string]decimal.Decimal)
+	for _, t := range transactions {
+		for _, d := range bc.debits(t) {
+			key := spendKey(d.address, d.tokenName)
+			pending[key] = pending[key].Sub(d.amount)
+		}
+		if isContract(t.txType) {
+			key := spendKey(t.senderBlockchainAddress, bc.genesis.RewardSchedule.Token)
+			pending[key] = pending[key].Add(bc.genesis.Fee(gasLimit(t)))
+		}
+	}
+	return pending
+}
+
+// ReconcileTransactionPool updates the pool after the chain tip moved. Pooled
+// transactions included in accepted blocks are removed, transactions from
+// orphaned blocks are offered again, and everything left is re-validated in
+// arrival order against the new tip.
+func (bc *Blockchain) ReconcileTransactionPool(accepted []*Block, orphaned []*Block) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.reconcileTransactionPool(accepted, orphaned)
+}
+
+func (bc *Blockchain) reconcileTransactionPool(accepted []*Block, orphaned []*Block) {
+	included := make(map[string]bool)
+	for _, b := range accepted {
+		for _, t := range b.transactions {
+			included[t.ID()] = true
+		}
+	}
+
+	candidates := make([]*Transaction, 0, len(bc.transactionPool))
+	for _, b := range orphaned {
+		for _, t := range b.transactions {
+			if t.senderBlockchainAddress != bc.conf.MiningSender {
+				// Copy so that snapshots still referencing the orphaned
+				// block are not affected.
+				c := *t
+				c.addedAt = time.Now()
+				candidates = append(candidates, &c)
+			}
+		}
+	}
+	candidates = append(candidates, bc.transactionPool...)
+
+	kept := make([]*Transaction, 0, len(candidates))
+	for _, t := range candidates {
+		id := t.ID()
+		if included[id] || t.senderBlockchainAddress == bc.conf.MiningSender {
+			continue
+		}
+		included[id] = true
+
+		if err := bc.validateTransaction(t, kept); err != nil {
+			log.Printf("mempool: dropping transaction %s after chain update: %v", id, err)
+			continue
+		}
+		kept = append(kept, t)
+	}
+
+	bc.transactionPool = kept
+	bc.saveTransactionPool()
+}
+
+const maxEvictions = 100
+
+const (
+	EvictionExpiredHeight = "expired_height"
+	EvictionExpiredTime   = "expired_time"
+	EvictionStale         = "stale"
+)
+
+type Eviction struct {
+	Transaction *Transaction `json:"transaction"`
+	Reason      string       `json:"reason"`
+	EvictedAt   int64        `json:"evicted_at"`
+}
+
+func (bc *Blockchain) evictionReason(t *Transaction, height int64, now time.Time) string {
+	if t.expiryHeight > 0 && height > t.expiryHeight {
+		return EvictionExpiredHeight
+	}
+	if t.expiresAt > 0 && now.Unix() >= t.expiresAt {
+		return EvictionExpiredTime
+	}
+	if bc.conf.MempoolMaxAge > 0 && now.Sub(t.addedAt) > bc.conf.MempoolMaxAge {
+		return EvictionStale
+	}
+	return ""
+}
+
+// EvictTransactions removes pooled transactions that can no longer be mined
+// in the next block, or that have been waiting longer than MempoolMaxAge.
+func (bc *Blockchain) EvictTransactions() []*Eviction {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	height := int64(len(bc.chain))
+	now := time.Now()
+	kept := make([]*Transaction, 0, len(bc.transactionPool))
+	evicted := make([]*Eviction, 0)
+	held := false
+
+	for _, t := range bc.transactionPool {
+		if t.Locked(height, now) {
+			// The age of a held transaction counts from when it unlocks.
+			t.addedAt = now
+			held = true
+		}
+		reason := bc.evictionReason(t, height, now)
+		if reason == "" || t.senderBlockchainAddress == bc.conf.MiningSender {
+			kept = append(kept, t)
+			continue
+		}
+		evicted = append(evicted, &Eviction{Transaction: t, Reason: reason, EvictedAt: now.Unix()})
+	}
+
+	if len(evicted) == 0 {
+		if held {
+			bc.saveTransactionPool()
+		}
+		return evicted
+	}
+
+	bc.transactionPool = kept
+	bc.saveTransactionPool()
+
+	bc.evictions = append(bc.evictions, evicted...)
+	if len(bc.evictions) > maxEvictions {
+		bc.evictions = bc.evictions[len(bc.evictions)-maxEvictions:]
+	}
+
+	for _, e := range evicted {
+		log.Printf("mempool: evicted transaction from %s (%s)", e.Transaction.senderBlockchainAddress, e.Reason)
+		bc.publish(EventTransactionEvicted, e)
+	}
+
+	return evicted
+}
+
+// Evictions returns the most recent mempool evictions, oldest first.
+func (bc *Blockchain) Evictions() []*Eviction {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	evictions := make([]*Eviction, len(bc.evictions))
+	copy(evictions, bc.evictions)
+	return evictions
+}
+
+func (bc *Blockchain) StartMempoolJanitor() {
+	bc.EvictTransactions()
+	_ = time.AfterFunc(bc.conf.MempoolJanitorInterval, bc.StartMempoolJanitor)
+}
@@ -0,0 +1,234 @@
+package block
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/shopspring/decimal"
+)
+
+// savedPool reads the mempool bc persisted.
+func savedPool(t *testing.T, bc *Blockchain) []*pendingTransaction {
+	t.Helper()
+	var pending []*pendingTransaction
+	err := bc.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(transactionPoolKey))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &pending)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pending
+}
+
+// writePool replaces the mempool bc persisted.
+func writePool(t *testing.T, bc *Blockchain, pending []*pendingTransaction) {
+	t.Helper()
+	m, _ := json.Marshal(pending)
+	err := bc.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(transactionPoolKey), m)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// restart empties the pool of bc and loads it again as on startup.
+func restart(bc *Blockchain) int {
+	bc.mux.Lock()
+	bc.transactionPool = nil
+	bc.mux.Unlock()
+	return bc.LoadTransactionPool()
+}
+
+// age makes every pooled transaction of bc older than MempoolMaxAge and
+// persists the pool.
+func age(bc *Blockchain) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	for _, t := range bc.transactionPool {
+		t.addedAt = time.Now().Add(-2 * bc.conf.MempoolMaxAge)
+	}
+	bc.saveTransactionPool()
+}
+
+func TestLoadTransactionPoolKeepsLocked(t *testing.T) {
+	tests := []struct {
+		name       string
+		lockHeight int64
+		want       int
+	}{
+		{"locked", 100, 1},
+		{"unlocked", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 3)
+			tx := transfer(bc, alice, bob.address, 1, 1)
+			tx.SetLock(tt.lockHeight, 0)
+			sign(bc, alice, tx, 1)
+			if err := bc.AddTransaction(tx); err != nil {
+				t.Fatal(err)
+			}
+			age(bc)
+
+			if got := restart(bc); got != tt.want {
+				t.Fatalf("LoadTransactionPool() = %d, want %d", got, tt.want)
+			}
+			if tt.want == 0 {
+				return
+			}
+
+			age(bc)
+			if evicted := bc.EvictTransactions(); len(evicted) != 0 {
+				t.Fatalf("evicted a time-locked transaction: %s", evicted[0].Reason)
+			}
+			for _, p := range savedPool(t, bc) {
+				if since := time.Since(time.Unix(0, p.AddedAt)); since > bc.conf.MempoolMaxAge {
+					t.Errorf("persisted age %v, want the age reset", since)
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionPoolPersistence(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(t *testing.T, bc *Blockchain, pending []*pendingTransaction)
+		want int
+	}{
+		{"pending", nil, 1},
+		{"mined since", func(t *testing.T, bc *Blockchain, pending []*pendingTransaction) {
+			mine(t, bc, 1)
+			writePool(t, bc, pending)
+		}, 0},
+		{"tampered", func(t *testing.T, bc *Blockchain, pending []*pendingTransaction) {
+			value := decimal.NewFromInt(2)
+			pending[0].Request.TokenValue = &value
+			writePool(t, bc, pending)
+		}, 0},
+		{"malformed", func(t *testing.T, bc *Blockchain, pending []*pendingTransaction) {
+			pending[0].Request = nil
+			writePool(t, bc, pending)
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 3)
+			tx := transfer(bc, alice, bob.address, 1, 1)
+			if err := bc.AddTransaction(tx); err != nil {
+				t.Fatal(err)
+			}
+			if tt.edit != nil {
+				tt.edit(t, bc, savedPool(t, bc))
+			}
+
+			if got := restart(bc); got != tt.want {
+				t.Fatalf("LoadTransactionPool() = %d, want %d", got, tt.want)
+			}
+			if pool := bc.TransactionPool(); tt.want == 1 && pool[0].ID() != tx.ID() {
+				t.Errorf("restored %s, want %s", pool[0].ID(), tx.ID())
+			}
+			if got := len(savedPool(t, bc)); got != tt.want {
+				t.Errorf("%d transactions saved after the restart, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvictTransactions(t *testing.T) {
+	tests := []struct {
+		name   string
+		expire func(tx *Transaction)
+		want   string
+	}{
+		{"pending", func(tx *Transaction) {}, ""},
+		{"expired height", func(tx *Transaction) { tx.expiryHeight = 1 }, EvictionExpiredHeight},
+		{"expired time", func(tx *Transaction) { tx.expiresAt = time.Now().Unix() - 1 }, EvictionExpiredTime},
+		{"stale", func(tx *Transaction) { tx.addedAt = time.Now().Add(-2 * time.Hour) }, EvictionStale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 3)
+			if err := bc.AddTransaction(transfer(bc, alice, bob.address, 1, 1)); err != nil {
+				t.Fatal(err)
+			}
+			bc.mux.Lock()
+			tt.expire(bc.transactionPool[0])
+			bc.mux.Unlock()
+			events, unsubscribe := bc.Subscribe()
+			defer unsubscribe()
+
+			evicted := bc.EvictTransactions()
+			if tt.want == "" {
+				if len(evicted) != 0 || len(bc.TransactionPool()) != 1 {
+					t.Fatalf("evicted %d, want none", len(evicted))
+				}
+				return
+			}
+			if len(evicted) != 1 || evicted[0].Reason != tt.want {
+				t.Fatalf("evicted %v, want one %s", evicted, tt.want)
+			}
+			if len(bc.TransactionPool()) != 0 || len(savedPool(t, bc)) != 0 {
+				t.Error("evicted transaction is still pooled")
+			}
+			if e := bc.Evictions(); len(e) != 1 || e[0].Reason != tt.want {
+				t.Errorf("Evictions() = %v, want one %s", e, tt.want)
+			}
+			select {
+			case e := <-events:
+				if e.Type != EventTransactionEvicted || e.Data.(*Eviction).Reason != tt.want {
+					t.Errorf("event %s %v, want %s %s", e.Type, e.Data, EventTransactionEvicted, tt.want)
+				}
+			default:
+				t.Error("no eviction event")
+			}
+		})
+	}
+}
+
+func TestAddTransactionRejectsExpired(t *testing.T) {
+	tests := []struct {
+		name         string
+		expiryHeight int64
+		expiresAt    int64
+		want         error
+	}{
+		{"no expiry", 0, 0, nil},
+		{"next block", 4, 0, nil},
+		{"past height", 3, 0, ErrTransactionExpired},
+		{"future time", 0, time.Now().Unix() + 60, nil},
+		{"past time", 0, time.Now().Unix() - 1, ErrTransactionExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 3)
+			tx := transfer(bc, alice, bob.address, 1, 1)
+			tx.SetExpiry(tt.expiryHeight, tt.expiresAt)
+			sign(bc, alice, tx, 1)
+			if err := bc.AddTransaction(tx); err != tt.want {
+				t.Errorf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
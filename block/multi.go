@@ -0,0 +1,129 @@
+package block
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// TxMultiTransfer pays several outputs from one sender under one signature.
+// Either all outputs are applied or none.
+const TxMultiTransfer = "multi_transfer"
+
+const MaxOutputs = 100
+
+var (
+	ErrInvalidOutputs = errors.New("invalid outputs")
+	ErrMissingNonce   = errors.New("a nonce is required")
+	ErrNonceTooLow    = errors.New("nonce must be higher than the last nonce of the sender")
+)
+
+// Output is one payment of a multi_transfer transaction.
+type Output struct {
+	RecipientBlockchainAddress string          `json:"recipient_blockchain_address"`
+	TokenName                  string          `json:"token_name"`
+	TokenValue                 decimal.Decimal `json:"token_value"`
+	TokenDecimals              uint8           `json:"token_decimals"`
+}
+
+// FixedOutput is the form of an Output in signed payloads and blocks.
+type FixedOutput struct {
+	Recipient string     `json:"recipient_blockchain_address"`
+	Token     FixedToken `json:"token"`
+}
+
+func (o *Output) Token() Token {
+	return Token{TokenName: o.TokenName, TokenValue: o.TokenValue}
+}
+
+func (o *Output) Fixed() FixedOutput {
+	return FixedOutput{Recipient: o.RecipientBlockchainAddress, Token: NewFixedToken(o.Token(), o.TokenDecimals)}
+}
+
+func (fo FixedOutput) Output() (*Output, error) {
+	token, err := fo.Token.Token()
+	if err != nil {
+		return nil, err
+	}
+	return &Output{
+		RecipientBlockchainAddress: fo.Recipient,
+		TokenName:                  token.TokenName,
+		TokenValue:                 token.TokenValue,
+		TokenDecimals:              fo.Token.Decimals,
+	}, nil
+}
+
+// FixedOutputs converts outputs for signing, nil stays nil.
+func FixedOutputs(outputs []*Output) []FixedOutput {
+	if outputs == nil {
+		return nil
+	}
+	fixed := make([]FixedOutput, len(outputs))
+	for i, o := range outputs {
+		fixed[i] = o.Fixed()
+	}
+	return fixed
+}
+
+// verifyOutputs checks the form of the outputs of a multi_transfer.
+func (bc *Blockchain) verifyOutputs(t *Transaction) error {
+	if len(t.outputs) == 0 || len(t.outputs) > MaxOutputs {
+		return fmt.Errorf("%w: between 1 and %d outputs are allowed", ErrInvalidOutputs, MaxOutputs)
+	}
+	if t.recipientBlockchainAddress != "" {
+		return fmt.Errorf("%w: recipients are given per output", ErrInvalidOutputs)
+	}
+	for i, o := range t.outputs {
+		if err := bc.validateAddress(o.RecipientBlockchainAddress); err != nil {
+			return fmt.Errorf("%w: output %d: %v", ErrInvalidOutputs, i, err)
+		}
+	}
+	return nil
+}
+
+// validateOutputs checks every output of a multi_transfer against the token
+// registry.
+func (bc *Blockchain) validateOutputs(t *Transaction, tokens map[string]*TokenInfo) error {
+	for i, o := range t.outputs {
+		info, ok := tokens[o.TokenName]
+		if !ok {
+			return fmt.Errorf("output %d: %w: %s", i, ErrUnknownToken, o.TokenName)
+		}
+		if !o.TokenValue.IsPositive() {
+			return fmt.Errorf("output %d: %w", i, ErrInvalidAmount)
+		}
+		if o.TokenDecimals != info.Decimals || !fitsDecimals(o.TokenValue, info.Decimals) {
+			return fmt.Errorf("output %d: %w: %s has %d decimals", i, ErrWrongDecimals, o.TokenName, info.Decimals)
+		}
+	}
+	return nil
+}
+
+// lastNonce returns the highest nonce the sender used in chain and pool.
+func (bc *Blockchain) lastNonce(chain []*Block, pool []*Transaction, blockchainAddress string) uint64 {
+	s, start := bc.base(chain)
+	last := s.Nonces[blockchainAddress]
+	for _, b := range chain[start:] {
+		for _, t := range b.transactions {
+			if t.senderBlockchainAddress == blockchainAddress && t.nonce > last {
+				last = t.nonce
+			}
+		}
+	}
+	for _, t := range pool {
+		if t.senderBlockchainAddress == blockchainAddress && t.nonce > last {
+			last = t.nonce
+		}
+	}
+	return last
+}
+
+// NextNonce returns the lowest nonce the sender can use for its next
+// transaction. Every transaction carries a nonce above the last one of its
+// sender, gaps are allowed.
+func (bc *Blockchain) NextNonce(blockchainAddress string) uint64 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.lastNonce(bc.chain, bc.transactionPool, blockchainAddress) + 1
+}
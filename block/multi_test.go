@@ -0,0 +1,65 @@
+package block
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMultiTransfer(t *testing.T) {
+	output := func(recipient string, value string) *Output {
+		return &Output{RecipientBlockchainAddress: recipient, TokenName: "DNZ", TokenValue: decimal.RequireFromString(value), TokenDecimals: NativeTokenDecimals}
+	}
+	tests := []struct {
+		name string
+		edit func(tx *Transaction, bob, carol *testKey)
+		want error
+	}{
+		{"valid", func(tx *Transaction, bob, carol *testKey) {}, nil},
+		{"no outputs", func(tx *Transaction, bob, carol *testKey) { tx.outputs = nil }, ErrInvalidOutputs},
+		{"too many outputs", func(tx *Transaction, bob, carol *testKey) {
+			for len(tx.outputs) <= MaxOutputs {
+				tx.outputs = append(tx.outputs, output(bob.address, "0.01"))
+			}
+		}, ErrInvalidOutputs},
+		{"recipient outside the outputs", func(tx *Transaction, bob, carol *testKey) {
+			tx.recipientBlockchainAddress = bob.address
+		}, ErrInvalidOutputs},
+		{"invalid output address", func(tx *Transaction, bob, carol *testKey) {
+			tx.outputs[1].RecipientBlockchainAddress = "nobody"
+		}, ErrInvalidOutputs},
+		{"unknown token", func(tx *Transaction, bob, carol *testKey) { tx.outputs[1].TokenName = "GOLD" }, ErrUnknownToken},
+		{"zero output", func(tx *Transaction, bob, carol *testKey) { tx.outputs[1].TokenValue = decimal.Zero }, ErrInvalidAmount},
+		{"wrong decimals", func(tx *Transaction, bob, carol *testKey) { tx.outputs[1].TokenDecimals = 2 }, ErrWrongDecimals},
+		{"outputs beyond the balance", func(tx *Transaction, bob, carol *testKey) {
+			tx.outputs[1].TokenValue = decimal.NewFromInt(21)
+		}, ErrInsufficientBalance},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob, carol := newTestKey(t, bc), newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 3)
+
+			tx := NewTransaction(alice.address, "", Token{})
+			tx.SetOutputs([]*Output{output(bob.address, "4"), output(carol.address, "1.5")})
+			tt.edit(tx, bob, carol)
+			sign(bc, alice, tx, 1)
+			if err := bc.AddTransaction(tx); !errors.Is(err, tt.want) {
+				t.Fatalf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+
+			mine(t, bc, 1)
+			for address, want := range map[string]string{alice.address: "26.5", bob.address: "4", carol.address: "1.5"} {
+				if got := bc.CalculateTotalAmount(address, "DNZ"); !got.Equal(decimal.RequireFromString(want)) {
+					t.Errorf("balance %s, want %s", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package block
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"main/utils"
+)
+
+const MaxMultisigKeys = 15
+
+var (
+	ErrInvalidMultisig     = errors.New("invalid multisig")
+	ErrNotEnoughSignatures = errors.New("not enough signatures for the multisig threshold")
+)
+
+// Multisig describes an account controlled by Threshold of PublicKeys. Its
+// address is utils.MultisigAddress of the keys in this order.
+type Multisig struct {
+	Threshold  uint8    `json:"threshold"`
+	PublicKeys []string `json:"public_keys"`
+}
+
+// Address returns the multisig address of m for the given version byte.
+func (m *Multisig) Address(version byte) string {
+	return utils.MultisigAddress(m.Threshold, m.publicKeys(), version)
+}
+
+func (m *Multisig) publicKeys() []*ecdsa.PublicKey {
+	keys := make([]*ecdsa.PublicKey, len(m.PublicKeys))
+	for i, k := range m.PublicKeys {
+		keys[i] = utils.PublicKeyFromString(k)
+	}
+	return keys
+}
+
+// Validate checks the threshold and the form of the keys.
+func (m *Multisig) Validate() error {
+	if len(m.PublicKeys) == 0 || len(m.PublicKeys) > MaxMultisigKeys {
+		return fmt.Errorf("%w: between 1 and %d public keys are allowed", ErrInvalidMultisig, MaxMultisigKeys)
+	}
+	if m.Threshold == 0 || int(m.Threshold) > len(m.PublicKeys) {
+		return fmt.Errorf("%w: threshold must be between 1 and the number of keys", ErrInvalidMultisig)
+	}
+	seen := make(map[string]bool)
+	for i, k := range m.PublicKeys {
+		if len(k) != 128 {
+			return fmt.Errorf("%w: public key %d must be 128 hex characters", ErrInvalidMultisig, i)
+		}
+		if seen[k] {
+			return fmt.Errorf("%w: public key %d is listed twice", ErrInvalidMultisig, i)
+		}
+		seen[k] = true
+	}
+	return nil
+}
+
+// verifyMultisig checks that the sender is the multisig account of t and that
+// at least its threshold of keys signed t. Every signature given must be
+// valid.
+func (bc *Blockchain) verifyMultisig(t *Transaction) error {
+	if err := t.multisig.Validate(); err != nil {
+		return err
+	}
+	if t.multisig.Address(bc.network.MultisigVersion) != t.senderBlockchainAddress {
+		return ErrSenderMismatch
+	}
+	if len(t.signatures) != len(t.multisig.PublicKeys) {
+		return fmt.Errorf("%w: expected one signature slot per key", ErrInvalidMultisig)
+	}
+
+	signed := 0
+	for i, publicKey := range t.multisig.publicKeys() {
+		if t.signatures[i] == nil {
+			continue
+		}
+		if err := bc.VerifyTransactionSignature(publicKey, t.signatures[i], t); err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+		signed++
+	}
+	if signed < int(t.multisig.Threshold) {
+		return fmt.Errorf("%w: %d of %d", ErrNotEnoughSignatures, signed, t.multisig.Threshold)
+	}
+	return nil
+}
+
+// validateMultisigRequest checks the multisig fields of a request. Missing
+// signatures are empty strings.
+func validateMultisigRequest(errs *utils.ValidationErrors, tr *TransactionRequest) {
+	if err := tr.Multisig.Validate(); err != nil {
+		errs.Add("multisig", err.Error())
+		return
+	}
+	if len(tr.Signatures) != len(tr.Multisig.PublicKeys) {
+		errs.Add("signatures", "must have one entry per public key")
+		return
+	}
+	for i, s := range tr.Signatures {
+		if s != "" && len(s) != 128 {
+			errs.Add(fmt.Sprintf("signatures[%d]", i), "must be empty or 128 hex characters")
+		}
+	}
+}
+
+// SignatureCount returns how many keys of a multisig request have signed.
+func (tr *TransactionRequest) SignatureCount() int {
+	n := 0
+	for _, s := range tr.Signatures {
+		if s != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func signaturesFromStrings(ss []string) []*utils.Signature {
+	if ss == nil {
+		return nil
+	}
+	signatures := make([]*utils.Signature, len(ss))
+	for i, s := range ss {
+		if len(s) == 128 {
+			signatures[i] = utils.SignatureFromString(s)
+		}
+	}
+	return signatures
+}
+
+func signaturesToStrings(signatures []*utils.Signature) []string {
+	if signatures == nil {
+		return nil
+	}
+	ss := make([]string, len(signatures))
+	for i, s := range signatures {
+		if s != nil {
+			ss[i] = s.String()
+		}
+	}
+	return ss
+}
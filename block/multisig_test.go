@@ -0,0 +1,91 @@
+package block
+
+import (
+	"errors"
+	"fmt"
+	"main/utils"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMultisig(t *testing.T) {
+	tests := []struct {
+		name    string
+		signers []int
+		edit    func(tx *Transaction, keys []*testKey)
+		want    error
+	}{
+		{"threshold signed", []int{0, 2}, nil, nil},
+		{"all signed", []int{0, 1, 2}, nil, nil},
+		{"below threshold", []int{1}, nil, ErrNotEnoughSignatures},
+		{"unsigned", nil, nil, ErrNotEnoughSignatures},
+		{"signed by a key of another slot", []int{0, 1}, func(tx *Transaction, keys []*testKey) {
+			tx.signatures[0], tx.signatures[1] = tx.signatures[1], tx.signatures[0]
+		}, ErrInvalidSignature},
+		{"missing slot", []int{0, 1}, func(tx *Transaction, keys []*testKey) {
+			tx.signatures = tx.signatures[:2]
+		}, ErrInvalidMultisig},
+		{"zero threshold", []int{0, 1}, func(tx *Transaction, keys []*testKey) { tx.multisig.Threshold = 0 }, ErrInvalidMultisig},
+		{"threshold above the keys", []int{0, 1}, func(tx *Transaction, keys []*testKey) { tx.multisig.Threshold = 4 }, ErrInvalidMultisig},
+		{"lower threshold", []int{0}, func(tx *Transaction, keys []*testKey) { tx.multisig.Threshold = 1 }, ErrSenderMismatch},
+		{"duplicate key", []int{0, 1}, func(tx *Transaction, keys []*testKey) {
+			tx.multisig.PublicKeys[2] = tx.multisig.PublicKeys[0]
+		}, ErrInvalidMultisig},
+		{"malformed key", []int{0, 1}, func(tx *Transaction, keys []*testKey) {
+			tx.multisig.PublicKeys[2] = "abc"
+		}, ErrInvalidMultisig},
+		{"no keys", nil, func(tx *Transaction, keys []*testKey) {
+			tx.multisig.PublicKeys, tx.signatures = nil, nil
+		}, ErrInvalidMultisig},
+		{"other keys", []int{0, 1}, func(tx *Transaction, keys []*testKey) {
+			tx.multisig.PublicKeys[2] = publicKeyString(keys[3])
+		}, ErrSenderMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			keys := []*testKey{newTestKey(t, bc), newTestKey(t, bc), newTestKey(t, bc), newTestKey(t, bc)}
+			m := &Multisig{Threshold: 2}
+			for _, k := range keys[:3] {
+				m.PublicKeys = append(m.PublicKeys, publicKeyString(k))
+			}
+			address := m.Address(bc.network.MultisigVersion)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 3)
+			if err := bc.AddTransaction(transfer(bc, alice, address, 10, 1)); err != nil {
+				t.Fatal(err)
+			}
+			mine(t, bc, 1)
+
+			tx := NewTransaction(address, bob.address, Token{TokenName: "DNZ", TokenValue: decimal.NewFromInt(4)})
+			tx.SetDecimals(NativeTokenDecimals)
+			tx.chainID, tx.nonce, tx.multisig = bc.network.ChainID, 1, m
+			tx.signatures = make([]*utils.Signature, len(m.PublicKeys))
+			h := tx.SigningHash()
+			for _, i := range tt.signers {
+				tx.signatures[i] = utils.Sign(keys[i].privateKey, h[:])
+			}
+			if tt.edit != nil {
+				tt.edit(tx, keys)
+			}
+			if err := bc.AddTransaction(tx); !errors.Is(err, tt.want) {
+				t.Fatalf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+
+			mine(t, bc, 1)
+			if got := bc.CalculateTotalAmount(address, "DNZ"); !got.Equal(decimal.NewFromInt(6)) {
+				t.Errorf("multisig holds %s, want 6", got)
+			}
+		})
+	}
+}
+
+// publicKeyString encodes the public key of k the way requests carry it.
+func publicKeyString(k *testKey) string {
+	return fmt.Sprintf("%064x%064x", k.privateKey.PublicKey.X.Bytes(), k.privateKey.PublicKey.Y.Bytes())
+}
@@ -0,0 +1,352 @@
+package block
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/shopspring/decimal"
+)
+
+// A pruned node keeps the bodies of its last PruneKeepBlocks blocks only.
+// The state the older blocks lead to is kept in a snapshot instead, so
+// balances, tokens, contracts and the other state are scanned from the
+// snapshot rather than from genesis. Older blocks keep their headers, which
+// still link the chain and carry the proof of work, and their filters.
+//
+// Mining rewards mature over CoinbaseMaturity blocks, so the bodies of that
+// many blocks below the snapshot are kept as well. Nodes advertise the
+// height their bodies start at in the handshake, peers fetch older bodies
+// from archive nodes.
+//
+// The snapshot only moves in steps of SnapshotInterval blocks, so it is
+// written once per interval rather than with every block. The receipt index
+// is kept for pruned blocks and records which transactions were mined below
+// the snapshot.
+const snapshotKey = "ps"
+
+var ErrPruned = errors.New("block body has been pruned")
+
+// snapshot is the state after the blocks below Height, the last of which
+// has the hash BlockHash.
+type snapshot struct {
+	Height    int64                        `json:"height"`
+	BlockHash string                       `json:"block_hash"`
+	Balances  map[string]decimal.Decimal   `json:"balances"`
+	Tokens    map[string]*TokenInfo        `json:"tokens"`
+	HTLCs     map[string]*HTLCInfo         `json:"htlcs"`
+	Escrows   map[string]*EscrowInfo       `json:"escrows"`
+	Contracts map[string]*ContractInfo     `json:"contracts"`
+	Storage   map[string]map[uint64]uint64 `json:"storage"`
+	Nonces    map[string]uint64            `json:"nonces"`
+}
+
+// genesisState is the state before the genesis block.
+func (bc *Blockchain) genesisState() *snapshot {
+	return &snapshot{
+		Balances:  make(map[string]decimal.Decimal),
+		Tokens:    bc.genesis.Tokens(),
+		HTLCs:     make(map[string]*HTLCInfo),
+		Escrows:   make(map[string]*EscrowInfo),
+		Contracts: make(map[string]*ContractInfo),
+		Storage:   make(map[string]map[uint64]uint64),
+		Nonces:    make(map[string]uint64),
+	}
+}
+
+// base returns the state chain is scanned from and the height to scan it
+// from: the snapshot if chain contains the block it was taken at, the state
+// before genesis otherwise. The state must not be modified.
+func (bc *Blockchain) base(chain []*Block) (*snapshot, int) {
+	s := bc.snapshot
+	if s != nil && s.Height > 0 && int64(len(chain)) >= s.Height &&
+		fmt.Sprintf("%x", chain[s.Height-1].Hash()) == s.BlockHash {
+		return s, int(s.Height)
+	}
+	return bc.genesisState(), 0
+}
+
+// bodiesFrom returns the lowest height whose body is needed next to a
+// snapshot at height, for the maturity of mining rewards.
+func (bc *Blockchain) bodiesFrom(height int) int {
+	from := height - int(bc.genesis.CoinbaseMaturity) + 1
+	if from < 1 {
+		return 1
+	}
+	if from > height {
+		return height
+	}
+	return from
+}
+
+func (s *snapshot) copy() *snapshot {
+	return &snapshot{
+		Height:    s.Height,
+		BlockHash: s.BlockHash,
+		Balances:  copyMap(s.Balances),
+		Tokens:    cloneInfos(s.Tokens),
+		HTLCs:     cloneInfos(s.HTLCs),
+		Escrows:   cloneInfos(s.Escrows),
+		Contracts: cloneInfos(s.Contracts),
+		Storage:   s.storage().writes,
+		Nonces:    copyMap(s.Nonces),
+	}
+}
+
+// storage returns an overlay holding the contract storage of s.
+func (s *snapshot) storage() *storageOverlay {
+	storage := newStorageOverlay(nil)
+	for contract, writes := range s.Storage {
+		storage.apply(contract, writes)
+	}
+	return storage
+}
+
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func cloneInfos[V any](m map[string]*V) map[string]*V {
+	c := make(map[string]*V, len(m))
+	for k, v := range m {
+		info := *v
+		c[k] = &info
+	}
+	return c
+}
+
+// advance returns the state after the blocks of the chain below height,
+// applying the blocks from start on to s.
+func (bc *Blockchain) advance(s *snapshot, start int, height int) *snapshot {
+	next := s.copy()
+	storage := next.storage()
+	for h := start; h < height; h++ {
+		b := bc.chain[h]
+		bc.applyBalances(next.Balances, b.transactions)
+		for _, t := range b.transactions {
+			bc.applyToken(next.Tokens, t, int64(h))
+			applyHTLC(next.HTLCs, t, int64(h))
+			applyEscrow(next.Escrows, t, int64(h))
+			bc.applyContract(next.Contracts, storage, t, int64(h))
+			if t.nonce > next.Nonces[t.senderBlockchainAddress] {
+				next.Nonces[t.senderBlockchainAddress] = t.nonce
+			}
+		}
+	}
+	next.Storage = storage.writes
+	next.Height = int64(height)
+	next.BlockHash = fmt.Sprintf("%x", bc.chain[height-1].Hash())
+	return next
+}
+
+// withoutBody returns the block with its header only.
+func (b *Block) withoutBody() *Block {
+	return &Block{
+		timestamp:        b.timestamp,
+		nonce:            b.nonce,
+		previousHash:     b.previousHash,
+		transactionsRoot: b.transactionsRoot,
+		stateRoot:        b.stateRoot,
+		pruned:           true,
+	}
+}
+
+// prune moves the snapshot up to the last multiple of SnapshotInterval at
+// least PruneKeepBlocks below the tip and drops the bodies and receipts of
+// the blocks no longer needed. Archive nodes, with PruneKeepBlocks at 0,
+// never prune.
+func (bc *Blockchain) prune() {
+	keep := int(bc.conf.PruneKeepBlocks)
+	if keep <= 0 {
+		return
+	}
+	s, start := bc.base(bc.chain)
+	interval := int(bc.conf.SnapshotInterval)
+	height := (len(bc.chain) - keep) / interval * interval
+	if height <= start {
+		return
+	}
+	next := bc.advance(s, start, height)
+
+	wb := bc.db.NewWriteBatch()
+	defer wb.Cancel()
+	m, err := json.Marshal(next)
+	if err != nil {
+		log.Printf("ERROR: could not encode the state snapshot: %v", err)
+		return
+	}
+	if err := wb.Set([]byte(snapshotKey), m); err != nil {
+		log.Printf("ERROR: could not persist the state snapshot: %v", err)
+		return
+	}
+	pruned := make(map[int]*Block)
+	for h := bc.bodiesFrom(start); h < bc.bodiesFrom(height); h++ {
+		b := bc.chain[h]
+		if b.pruned {
+			continue
+		}
+		pruned[h] = b.withoutBody()
+		err := wb.Delete(receiptKey(int64(h)))
+		if err == nil {
+			m, _ := json.Marshal(pruned[h])
+			err = wb.Set(blockKey(int64(h)), m)
+		}
+		if err != nil {
+			log.Printf("ERROR: could not prune block %d: %v", h, err)
+			return
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		log.Printf("ERROR: could not prune the chain: %v", err)
+		return
+	}
+
+	bc.snapshot = next
+	for h, b := range pruned {
+		bc.chain[h] = b
+	}
+	if len(pruned) > 0 {
+		log.Printf("pruned %d block bodies, keeping bodies from height %d", len(pruned), bc.bodiesFrom(height))
+	}
+}
+
+// loadSnapshot reads the persisted state snapshot, if any.
+func (bc *Blockchain) loadSnapshot() error {
+	return bc.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(snapshotKey))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			s := new(snapshot)
+			if err := json.Unmarshal(val, s); err != nil {
+				return err
+			}
+			bc.snapshot = s
+			return nil
+		})
+	})
+}
+
+// PrunedHeight returns the height from which the node has the bodies of
+// its blocks, 0 for an archive node. The genesis block is never pruned.
+func (bc *Blockchain) PrunedHeight() int64 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.prunedHeight()
+}
+
+func (bc *Blockchain) prunedHeight() int64 {
+	_, start := bc.base(bc.chain)
+	if from := bc.bodiesFrom(start); start > 0 && from > 1 {
+		return int64(from)
+	}
+	return 0
+}
+
+// handshake checks that node is on our network and returns the height its
+// block bodies start at.
+func (bc *Blockchain) handshake(node string) (int64, error) {
+	response, err := http.Get(fmt.Sprintf("http://%s/genesis", node))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	var status struct {
+		Hash         string `json:"hash"`
+		PrunedHeight int64  `json:"pruned_height"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+		return 0, err
+	}
+	if status.Hash != fmt.Sprintf("%x", bc.genesisHash) {
+		return 0, fmt.Errorf("peer is on another network (genesis %s)", status.Hash)
+	}
+	return status.PrunedHeight, nil
+}
+
+// archiveNodes returns the peers that have the body of the block at height.
+func (bc *Blockchain) archiveNodes(height int) []string {
+	bc.muxNodes.RLock()
+	defer bc.muxNodes.RUnlock()
+	nodes := make([]string, 0)
+	for _, n := range bc.nodes {
+		if prunedHeight := bc.prunedHeights[n]; prunedHeight == 0 || int64(height) >= prunedHeight {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// fillBodies completes the pruned blocks of chain that are needed to
+// validate it, taking them from our chain where it is the same and from
+// archive peers otherwise. A fetched body must match the transactions root
+// of its header.
+func (bc *Blockchain) fillBodies(chain []*Block) error {
+	bc.mux.RLock()
+	_, start := bc.base(chain)
+	own := bc.chain
+	bc.mux.RUnlock()
+
+	for h := bc.bodiesFrom(start); h < len(chain); h++ {
+		if !chain[h].pruned {
+			continue
+		}
+		hash := chain[h].Hash()
+		if h < len(own) && !own[h].pruned && own[h].Hash() == hash {
+			chain[h] = own[h]
+			continue
+		}
+		var err error = ErrPruned
+		for _, n := range bc.archiveNodes(h) {
+			var b *Block
+			if b, err = fetchBlock(n, h); err == nil {
+				switch {
+				case b.Hash() != hash:
+					err = fmt.Errorf("%s sent another block %d", n, h)
+				case TransactionsRoot(b.transactions) != b.transactionsRoot:
+					err = fmt.Errorf("%s sent block %d with transactions that do not match its root", n, h)
+				}
+			}
+			if err == nil {
+				chain[h] = b
+				break
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("block %d: %w", h, err)
+		}
+	}
+	return nil
+}
+
+func fetchBlock(node string, height int) (*Block, error) {
+	response, err := http.Get(fmt.Sprintf("http://%s/blocks/%d", node, height))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", node, response.Status)
+	}
+	b := new(Block)
+	if err := json.NewDecoder(response.Body).Decode(b); err != nil {
+		return nil, err
+	}
+	if b.pruned {
+		return nil, ErrPruned
+	}
+	return b, nil
+}
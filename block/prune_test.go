@@ -0,0 +1,331 @@
+package block
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"main/config"
+	"main/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type testKey struct {
+	privateKey *ecdsa.PrivateKey
+	address    string
+}
+
+func newTestKey(t *testing.T, bc *Blockchain) *testKey {
+	t.Helper()
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{privateKey, utils.AddressFromPublicKey(&privateKey.PublicKey, bc.network.AddressVersion)}
+}
+
+// newTestChain creates a devnet chain in a temporary directory that mines to
+// miner and keeps the bodies of its last keep blocks, all of them for 0.
+func newTestChain(t *testing.T, miner string, keep int64, interval int64) *Blockchain {
+	t.Helper()
+	conf := config.Blockchain{
+		Network:                "devnet",
+		Difficulty:             1,
+		MiningSender:           "DENIZ",
+		DefaultRewardToken:     "DNZ",
+		MiningReward:           8,
+		CoinbaseMaturity:       2,
+		GasPrice:               0.001,
+		DbSavePath:             t.TempDir(),
+		MempoolMaxAge:          time.Hour,
+		MempoolJanitorInterval: time.Hour,
+		PruneKeepBlocks:        keep,
+		SnapshotInterval:       interval,
+	}
+	bc, err := CreateBlockchain(miner, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.db.Close() })
+	return bc
+}
+
+// transfer signs a transfer of value DNZ from sender to recipient.
+func transfer(bc *Blockchain, sender *testKey, recipient string, value int64, nonce uint64) *Transaction {
+	tx := NewTransaction(sender.address, recipient, Token{TokenName: "DNZ", TokenValue: decimal.NewFromInt(value)})
+	tx.decimals = NativeTokenDecimals
+	return sign(bc, sender, tx, nonce)
+}
+
+// sign signs tx as sender with nonce for the chain of bc.
+func sign(bc *Blockchain, sender *testKey, tx *Transaction, nonce uint64) *Transaction {
+	tx.chainID = bc.network.ChainID
+	tx.nonce = nonce
+	tx.senderPublicKey = &sender.privateKey.PublicKey
+	h := tx.SigningHash()
+	tx.signature = utils.Sign(sender.privateKey, h[:])
+	return tx
+}
+
+// mine mines n blocks on bc.
+func mine(t *testing.T, bc *Blockchain, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if !bc.Mining() {
+			t.Fatalf("could not mine block %d", len(bc.Chain()))
+		}
+	}
+}
+
+// syncFrom makes bc take the chain of other as its own.
+func syncFrom(bc *Blockchain, other *Blockchain) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.chain = other.Chain()
+	bc.updateLastHash()
+	bc.rebuildState(0)
+}
+
+// serveChain answers the peer requests of ResolveConflicts for bc and adds
+// the server as the only node of peer.
+func serveChain(t *testing.T, bc *Blockchain, peer *Blockchain) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chain", func(w http.ResponseWriter, req *http.Request) {
+		m, _ := bc.MarshalJSON()
+		w.Write(m)
+	})
+	mux.HandleFunc("/genesis", func(w http.ResponseWriter, req *http.Request) {
+		m, _ := json.Marshal(map[string]any{"hash": fmt.Sprintf("%x", bc.GenesisHash()), "pruned_height": bc.PrunedHeight()})
+		w.Write(m)
+	})
+	mux.HandleFunc("/blocks/", func(w http.ResponseWriter, req *http.Request) {
+		var height int64
+		fmt.Sscanf(strings.TrimPrefix(req.URL.Path, "/blocks/"), "%d", &height)
+		b, ok := bc.Block(height)
+		if !ok || b.Pruned() {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		m, _ := json.Marshal(b)
+		w.Write(m)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	node := strings.TrimPrefix(server.URL, "http://")
+	prunedHeight, err := peer.handshake(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.muxNodes.Lock()
+	peer.nodes = []string{node}
+	peer.prunedHeights = map[string]int64{node: prunedHeight}
+	peer.muxNodes.Unlock()
+}
+
+// checkSameState fails unless got and want agree on the tip, the balances
+// and nonces of the addresses, the supply and the filters.
+func checkSameState(t *testing.T, got *Blockchain, want *Blockchain, addresses ...string) {
+	t.Helper()
+	if got.LastBlock().Hash() != want.LastBlock().Hash() {
+		t.Fatalf("tip %x, want %x", got.LastBlock().Hash(), want.LastBlock().Hash())
+	}
+	for _, a := range addresses {
+		if g, w := got.CalculateTotalAmount(a, "DNZ"), want.CalculateTotalAmount(a, "DNZ"); !g.Equal(w) {
+			t.Errorf("balance of %s = %s, want %s", a, g, w)
+		}
+		if g, w := got.NextNonce(a), want.NextNonce(a); g != w {
+			t.Errorf("next nonce of %s = %d, want %d", a, g, w)
+		}
+	}
+	if g, w := got.Supply()[0].Circulating, want.Supply()[0].Circulating; !g.Equal(w) {
+		t.Errorf("supply = %s, want %s", g, w)
+	}
+	gf, err := got.Filters(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf, err := want.Filters(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gf) != len(wf) {
+		t.Fatalf("%d filters, want %d", len(gf), len(wf))
+	}
+	for i := range gf {
+		if gf[i].Header != wf[i].Header {
+			t.Errorf("filter header %d = %s, want %s", i, gf[i].Header, wf[i].Header)
+		}
+	}
+}
+
+func TestPruneMatchesArchive(t *testing.T) {
+	tests := []struct {
+		name     string
+		keep     int64
+		interval int64
+	}{
+		{"every block", 3, 1},
+		{"interval", 2, 4},
+		{"keep above interval", 5, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, archive), newTestKey(t, archive)
+			archive.blockchainAddress = alice.address
+			mine(t, archive, 5)
+			first := transfer(archive, alice, bob.address, 3, 1)
+			if err := archive.AddTransaction(first); err != nil {
+				t.Fatal(err)
+			}
+			mine(t, archive, 4)
+			if err := archive.AddTransaction(transfer(archive, alice, bob.address, 2, 4)); err != nil {
+				t.Fatal(err)
+			}
+			mine(t, archive, 4)
+
+			pruned := newTestChain(t, "", tt.keep, tt.interval)
+			syncFrom(pruned, archive)
+			if pruned.PrunedHeight() == 0 {
+				t.Fatal("chain was not pruned")
+			}
+			checkSameState(t, pruned, archive, alice.address, bob.address)
+
+			pruned.mux.Lock()
+			pruned.rebuildState(0)
+			pruned.mux.Unlock()
+			checkSameState(t, pruned, archive, alice.address, bob.address)
+
+			if err := pruned.AddTransaction(first); err == nil {
+				t.Error("a transaction mined below the snapshot was accepted again")
+			}
+			if !pruned.IsIncluded(first.ID()) {
+				t.Error("a transaction mined below the snapshot is not included")
+			}
+			if _, err := pruned.Receipt(first.ID()); err != ErrPruned {
+				t.Errorf("receipt of a pruned transaction: %v, want %v", err, ErrPruned)
+			}
+		})
+	}
+}
+
+func TestResolveConflictsBelowSnapshot(t *testing.T) {
+	tests := []struct {
+		name        string
+		forkKeep    int64
+		wantReplace bool
+	}{
+		{"archive peer", 0, true},
+		{"pruned peer", 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, archive), newTestKey(t, archive)
+			archive.blockchainAddress = alice.address
+			mine(t, archive, 5)
+			// The fork shares the first blocks, whose bodies the node prunes.
+			fork := newTestChain(t, bob.address, tt.forkKeep, 1)
+			syncFrom(fork, archive)
+			if err := archive.AddTransaction(transfer(archive, alice, bob.address, 3, 1)); err != nil {
+				t.Fatal(err)
+			}
+			mine(t, archive, 8)
+
+			node := newTestChain(t, "", 3, 4)
+			syncFrom(node, archive)
+			before := node.LastBlock().Hash()
+			if node.PrunedHeight() == 0 {
+				t.Fatal("chain was not pruned")
+			}
+
+			mine(t, fork, 20)
+			serveChain(t, fork, node)
+
+			if got := node.ResolveConflicts(); got != tt.wantReplace {
+				t.Fatalf("ResolveConflicts() = %v, want %v", got, tt.wantReplace)
+			}
+			if !tt.wantReplace {
+				if node.LastBlock().Hash() != before {
+					t.Fatal("chain changed")
+				}
+				return
+			}
+			checkSameState(t, node, fork, alice.address, bob.address)
+			if !node.ValidChain(node.Chain()) {
+				t.Error("chain is not valid after the reorg")
+			}
+			if node.PrunedHeight() == 0 {
+				t.Error("chain was not pruned after the reorg")
+			}
+		})
+	}
+}
+
+func TestFillBodies(t *testing.T) {
+	archive := newTestChain(t, "", 0, 0)
+	alice, bob := newTestKey(t, archive), newTestKey(t, archive)
+	archive.blockchainAddress = alice.address
+	mine(t, archive, 3)
+	if err := archive.AddTransaction(transfer(archive, alice, bob.address, 3, 1)); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, archive, 2)
+	full := archive.Chain()
+	h := 4
+	if len(full[h].transactions) != 2 {
+		t.Fatalf("block %d has %d transactions, want the transfer and the coinbase", h, len(full[h].transactions))
+	}
+
+	tests := []struct {
+		name  string
+		serve func() *Block
+		want  bool
+	}{
+		{"honest", func() *Block { return full[h] }, true},
+		{"another block", func() *Block { return full[h+1] }, false},
+		{"pruned", func() *Block { return full[h].withoutBody() }, false},
+		{"body without the transfer", func() *Block {
+			b := *full[h]
+			b.transactions = b.transactions[1:]
+			return &b
+		}, false},
+		{"body with another transfer", func() *Block {
+			b := *full[h]
+			b.transactions = []*Transaction{transfer(archive, alice, bob.address, 4, 1), b.transactions[1]}
+			return &b
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/blocks/", func(w http.ResponseWriter, req *http.Request) {
+				m, _ := json.Marshal(tt.serve())
+				w.Write(m)
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+			node := newTestChain(t, "", 0, 0)
+			node.nodes = []string{strings.TrimPrefix(server.URL, "http://")}
+
+			chain := append([]*Block{}, full...)
+			chain[h] = full[h].withoutBody()
+			err := node.fillBodies(chain)
+			if (err == nil) != tt.want {
+				t.Fatalf("fillBodies() = %v, want success %v", err, tt.want)
+			}
+			if tt.want && (chain[h].Pruned() || TransactionsRoot(chain[h].transactions) != full[h].transactionsRoot) {
+				t.Error("block was not filled with its body")
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package block
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/shopspring/decimal"
+)
+
+// Every mined transaction gets a receipt recording its outcome. Receipts are
+// stored per block next to the block, with an index from transaction ID to
+// block height.
+const (
+	receiptKeyPrefix      = "r/"
+	receiptIndexKeyPrefix = "rt/"
+)
+
+const (
+	ReceiptSuccess = "success"
+	ReceiptFailed  = "failed"
+)
+
+var ErrUnknownReceipt = errors.New("no receipt for the transaction")
+
+// Receipt is the outcome of a mined transaction. Only deploys and calls use
+// gas, only calls can fail or emit logs. FeePaid is what was charged for the
+// gas, in the reward token.
+type Receipt struct {
+	TransactionID string          `json:"transaction_id"`
+	Type          string          `json:"type,omitempty"`
+	Status        string          `json:"status"`
+	Error         string          `json:"error,omitempty"`
+	BlockHeight   int64           `json:"block_height"`
+	BlockHash     string          `json:"block_hash"`
+	Index         int             `json:"index"`
+	GasUsed       uint64          `json:"gas_used"`
+	FeePaid       decimal.Decimal `json:"fee_paid"`
+	Return        uint64          `json:"return,omitempty"`
+	Logs          []*Log          `json:"logs"`
+}
+
+// Log is emitted by the LOG instruction of a contract. Index counts the logs
+// of a block.
+type Log struct {
+	Address       string   `json:"address"`
+	Topics        []uint64 `json:"topics"`
+	Data          uint64   `json:"data"`
+	BlockHeight   int64    `json:"block_height"`
+	TransactionID string   `json:"transaction_id"`
+	Index         int      `json:"log_index"`
+}
+
+// LogFilter selects logs. An empty Address matches every contract, a nil
+// Topic every log, otherwise one of the topics must equal it. The height
+// range is inclusive, a zero ToHeight means the tip.
+type LogFilter struct {
+	Address    string
+	Topic      *uint64
+	FromHeight int64
+	ToHeight   int64
+}
+
+func (f *LogFilter) matches(l *Log) bool {
+	if f.Address != "" && l.Address != f.Address {
+		return false
+	}
+	if f.Topic == nil {
+		return true
+	}
+	for _, topic := range l.Topics {
+		if topic == *f.Topic {
+			return true
+		}
+	}
+	return false
+}
+
+// buildReceipts builds the receipts of the block at height from the results of
+// its calls, which are nil for other transactions.
+func (bc *Blockchain) buildReceipts(b *Block, height int64, results []*ExecResult) []*Receipt {
+	hash := fmt.Sprintf("%x", b.Hash())
+	receipts := make([]*Receipt, len(b.transactions))
+	logIndex := 0
+	for i, t := range b.transactions {
+		id := t.ID()
+		r := &Receipt{
+			TransactionID: id,
+			Type:          t.txType,
+			Status:        ReceiptSuccess,
+			BlockHeight:   height,
+			BlockHash:     hash,
+			Index:         i,
+			FeePaid:       bc.genesis.Fee(t.gasUsed),
+			Logs:          make([]*Log, 0),
+		}
+		if res := results[i]; res != nil {
+			r.GasUsed = res.GasUsed
+			r.Return = res.Return
+			if res.Err != nil {
+				r.Status, r.Error = ReceiptFailed, res.Err.Error()
+			}
+			for _, l := range res.Logs {
+				l.BlockHeight, l.TransactionID, l.Index = height, id, logIndex
+				logIndex++
+				r.Logs = append(r.Logs, l)
+			}
+		}
+		receipts[i] = r
+	}
+	return receipts
+}
+
+func receiptKey(height int64) []byte {
+	return []byte(fmt.Sprintf("%s%016x", receiptKeyPrefix, height))
+}
+
+func receiptIndexKey(id string) []byte {
+	return []byte(receiptIndexKeyPrefix + id)
+}
+
+// saveReceipts stores the receipts of the block at height.
+func saveReceipts(wb *badger.WriteBatch, height int64, receipts []*Receipt) error {
+	m, err := json.Marshal(receipts)
+	if err != nil {
+		return err
+	}
+	if err := wb.Set(receiptKey(height), m); err != nil {
+		return err
+	}
+	for _, r := range receipts {
+		if err := wb.Set(receiptIndexKey(r.TransactionID), binary.BigEndian.AppendUint64(nil, uint64(height))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockReceipts loads the receipts of the block at height.
+func (bc *Blockchain) blockReceipts(height int64) ([]*Receipt, error) {
+	var receipts []*Receipt
+	err := bc.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(receiptKey(height))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &receipts)
+		})
+	})
+	return receipts, err
+}
+
+// receiptHeight looks up the height of the block a transaction was mined in.
+func (bc *Blockchain) receiptHeight(id string) (int64, error) {
+	var height int64
+	err := bc.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(receiptIndexKey(id))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			height = int64(binary.BigEndian.Uint64(val))
+			return nil
+		})
+	})
+	return height, err
+}
+
+// Receipt returns the receipt of a mined transaction, ErrPruned if the
+// block it was mined in has been pruned.
+func (bc *Blockchain) Receipt(id string) (*Receipt, error) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	height, err := bc.receiptHeight(id)
+	if err != nil {
+		return nil, ErrUnknownReceipt
+	}
+	if height < bc.prunedHeight() {
+		return nil, ErrPruned
+	}
+	receipts, err := bc.blockReceipts(height)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range receipts {
+		if r.TransactionID == id {
+			return r, nil
+		}
+	}
+	return nil, ErrUnknownReceipt
+}
+
+// Logs returns the logs matching the filter, in chain order. A pruned node
+// starts at its pruned height if no FromHeight is given and fails with
+// ErrPruned for a FromHeight below it.
+func (bc *Blockchain) Logs(filter LogFilter) ([]*Log, error) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	to := int64(len(bc.chain)) - 1
+	if filter.ToHeight > 0 && filter.ToHeight < to {
+		to = filter.ToHeight
+	}
+	from := filter.FromHeight
+	if from < 0 {
+		from = 0
+	}
+	if prunedHeight := bc.prunedHeight(); from < prunedHeight {
+		if from > 0 {
+			return nil, fmt.Errorf("%w: logs start at height %d", ErrPruned, prunedHeight)
+		}
+		from = prunedHeight
+	}
+	logs := make([]*Log, 0)
+	for height := from; height <= to; height++ {
+		receipts, err := bc.blockReceipts(height)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range receipts {
+			for _, l := range r.Logs {
+				if filter.matches(l) {
+					logs = append(logs, l)
+				}
+			}
+		}
+	}
+	return logs, nil
+}
@@ -0,0 +1,130 @@
+package block
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// onceCode is a contract that stores a flag and logs topic 7 with its first
+// argument on the first call, and reverts on every later call.
+func onceCode() string {
+	push := func(b []byte, v uint64) []byte {
+		return binary.BigEndian.AppendUint64(append(b, byte(OpPush)), v)
+	}
+	code := push(nil, 0)
+	code = append(code, byte(OpSLoad))
+	code = push(code, 61)
+	code = append(code, byte(OpJumpI))
+	code = push(code, 1)
+	code = push(code, 0)
+	code = append(code, byte(OpSStore))
+	code = push(code, 7)
+	code = push(code, 0)
+	code = append(code, byte(OpArg), byte(OpLog), 1, byte(OpStop), byte(OpJumpDest), byte(OpRevert))
+	return hex.EncodeToString(code)
+}
+
+func TestReceiptsAndLogs(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+	bc.blockchainAddress = alice.address
+	mine(t, bc, 3)
+
+	deploy := NewTransaction(alice.address, "", Token{})
+	deploy.txType, deploy.deploy = TxDeploy, &ContractDeploy{Code: onceCode()}
+	sign(bc, alice, deploy, 1)
+	if err := bc.AddTransaction(deploy); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, 3)
+	var address string
+	for a := range bc.contracts(bc.Chain()) {
+		address = a
+	}
+
+	calls := make([]*Transaction, 2)
+	for i := range calls {
+		calls[i] = NewTransaction(alice.address, address, Token{})
+		calls[i].txType, calls[i].call = TxCall, &ContractCall{Args: []uint64{uint64(42 + i)}, GasLimit: 10000}
+		sign(bc, alice, calls[i], uint64(2+i))
+		if err := bc.AddTransaction(calls[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	payment := transfer(bc, alice, bob.address, 1, 4)
+	if err := bc.AddTransaction(payment); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, 1)
+	chain := bc.Chain()
+	height := int64(len(chain) - 1)
+
+	tests := []struct {
+		name   string
+		tx     *Transaction
+		status string
+		logs   int
+		gas    bool
+	}{
+		{"deploy", deploy, ReceiptSuccess, 0, true},
+		{"logging call", calls[0], ReceiptSuccess, 1, true},
+		{"reverted call", calls[1], ReceiptFailed, 0, true},
+		{"transfer", payment, ReceiptSuccess, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := bc.Receipt(tt.tx.ID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Status != tt.status || len(r.Logs) != tt.logs || r.Type != tt.tx.txType {
+				t.Errorf("receipt %s %s with %d logs, want %s %s with %d", r.Type, r.Status, len(r.Logs), tt.tx.txType, tt.status, tt.logs)
+			}
+			b := chain[r.BlockHeight]
+			if r.BlockHash != fmt.Sprintf("%x", b.Hash()) || b.transactions[r.Index].ID() != r.TransactionID {
+				t.Errorf("receipt points to %d %s index %d", r.BlockHeight, r.BlockHash, r.Index)
+			}
+			if (r.GasUsed > 0) != tt.gas || (r.FeePaid.IsPositive()) != tt.gas {
+				t.Errorf("gas used %d, fee %s", r.GasUsed, r.FeePaid)
+			}
+			if (r.Error != "") != (tt.status == ReceiptFailed) {
+				t.Errorf("error %q", r.Error)
+			}
+		})
+	}
+	if _, err := bc.Receipt("unknown"); err != ErrUnknownReceipt {
+		t.Errorf("Receipt() = %v, want %v", err, ErrUnknownReceipt)
+	}
+
+	topic, other := uint64(7), uint64(8)
+	filters := []struct {
+		name   string
+		filter LogFilter
+		want   int
+	}{
+		{"all", LogFilter{}, 1},
+		{"contract", LogFilter{Address: address}, 1},
+		{"other contract", LogFilter{Address: bob.address}, 0},
+		{"topic", LogFilter{Topic: &topic}, 1},
+		{"other topic", LogFilter{Topic: &other}, 0},
+		{"at the height", LogFilter{FromHeight: height, ToHeight: height}, 1},
+		{"after", LogFilter{FromHeight: height + 1}, 0},
+		{"before", LogFilter{ToHeight: height - 1}, 0},
+	}
+	for _, tt := range filters {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, err := bc.Logs(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(logs) != tt.want {
+				t.Fatalf("%d logs, want %d", len(logs), tt.want)
+			}
+			if tt.want == 1 && (logs[0].Data != 42 || logs[0].TransactionID != calls[0].ID() || logs[0].BlockHeight != height) {
+				t.Errorf("log %+v", logs[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// The state tree commits to the balance of every address in every token and
+// to every contract. It is a sparse Merkle tree keyed by the hash of the
+// spend key for balances and of the address for contracts. Empty subtrees
+// hash to zero and a subtree holding a single leaf is replaced by the leaf,
+// so a path is only as long as it takes to tell its leaf from the others.
+// Zero balances are left out. The leaf of a contract holds the hash of its
+// code and the root of its storage, a tree of the same kind keyed by the
+// hash of the storage key.
+
+var ErrInvalidProof = errors.New("invalid proof")
+
+type stateLeaf struct {
+	key   [32]byte
+	value [32]byte
+}
+
+// stateTree holds the leaves of the state tree ordered by key.
+type stateTree struct {
+	leaves []stateLeaf
+}
+
+// StateKey is the key of the balance of address in tokenName.
+func StateKey(address string, tokenName string) [32]byte {
+	return sha256.Sum256([]byte(spendKey(address, tokenName)))
+}
+
+func balanceHash(balance decimal.Decimal) [32]byte {
+	return sha256.Sum256([]byte(balance.String()))
+}
+
+// ContractStateKey is the key of the contract at address. Spend keys always
+// contain a slash and addresses never do, so it cannot be a balance key.
+func ContractStateKey(address string) [32]byte {
+	return sha256.Sum256([]byte(address))
+}
+
+// contractHash commits to the code of a contract and to its storage.
+func contractHash(info *ContractInfo, storage map[uint64]uint64) [32]byte {
+	code, _ := hex.DecodeString(info.Code)
+	codeHash, root := sha256.Sum256(code), storageRoot(storage)
+	return sha256.Sum256(append(codeHash[:], root[:]...))
+}
+
+// storageRoot is the root of the tree of the storage of a contract. Zero
+// values are left out.
+func storageRoot(storage map[uint64]uint64) [32]byte {
+	leaves := make([]stateLeaf, 0, len(storage))
+	for k, v := range storage {
+		if v == 0 {
+			continue
+		}
+		leaves = append(leaves, stateLeaf{
+			key:   sha256.Sum256(binary.BigEndian.AppendUint64(nil, k)),
+			value: sha256.Sum256(binary.BigEndian.AppendUint64(nil, v)),
+		})
+	}
+	sortLeaves(leaves)
+	return subtreeRoot(leaves, 0)
+}
+
+// newStateTree builds the state tree of balances, keyed by spend key, and
+// of contracts with storage, the complete storage of every contract.
+func newStateTree(balances map[string]decimal.Decimal, contracts map[string]*ContractInfo, storage map[string]map[uint64]uint64) *stateTree {
+	leaves := make([]stateLeaf, 0, len(balances)+len(contracts))
+	for key, balance := range balances {
+		if balance.IsZero() {
+			continue
+		}
+		address, tokenName := splitSpendKey(key)
+		leaves = append(leaves, stateLeaf{key: StateKey(address, tokenName), value: balanceHash(balance)})
+	}
+	for address, info := range contracts {
+		leaves = append(leaves, stateLeaf{key: ContractStateKey(address), value: contractHash(info, storage[address])})
+	}
+	sortLeaves(leaves)
+	return &stateTree{leaves: leaves}
+}
+
+func sortLeaves(leaves []stateLeaf) {
+	sort.Slice(leaves, func(i, j int) bool {
+		return string(leaves[i].key[:]) < string(leaves[j].key[:])
+	})
+}
+
+func (st *stateTree) root() [32]byte {
+	return subtreeRoot(st.leaves, 0)
+}
+
+// prove returns the path to key. If key is not in the tree the path ends at
+// an empty subtree or at the one leaf of the subtree key would be in.
+func (st *stateTree) prove(key [32]byte) *StateProof {
+	proof := &StateProof{Siblings: make([]string, 0)}
+	leaves := st.leaves
+	for depth := 0; len(leaves) > 1; depth++ {
+		i := splitLeaves(leaves, depth)
+		var sibling [32]byte
+		if bit(key, depth) {
+			sibling, leaves = subtreeRoot(leaves[:i], depth+1), leaves[i:]
+		} else {
+			sibling, leaves = subtreeRoot(leaves[i:], depth+1), leaves[:i]
+		}
+		proof.Siblings = append(proof.Siblings, fmt.Sprintf("%x", sibling))
+	}
+	if len(leaves) == 1 && leaves[0].key != key {
+		proof.LeafKey = fmt.Sprintf("%x", leaves[0].key)
+		proof.LeafValue = fmt.Sprintf("%x", leaves[0].value)
+	}
+	return proof
+}
+
+// subtreeRoot hashes leaves, which share their first depth bits.
+func subtreeRoot(leaves []stateLeaf, depth int) [32]byte {
+	switch len(leaves) {
+	case 0:
+		return [32]byte{}
+	case 1:
+		return leafHash(leaves[0].key, leaves[0].value)
+	}
+	i := splitLeaves(leaves, depth)
+	return nodeHash(subtreeRoot(leaves[:i], depth+1), subtreeRoot(leaves[i:], depth+1))
+}
+
+// splitLeaves returns the index of the first leaf whose key has bit depth
+// set.
+func splitLeaves(leaves []stateLeaf, depth int) int {
+	return sort.Search(len(leaves), func(i int) bool {
+		return bit(leaves[i].key, depth)
+	})
+}
+
+func bit(key [32]byte, i int) bool {
+	return key[i/8]&(0x80>>(i%8)) != 0
+}
+
+// StateProof is the path from the root of the state tree to a balance.
+// Siblings are ordered from the root down. LeafKey and LeafValue are set
+// when the path of an absent balance ends at another leaf.
+type StateProof struct {
+	Siblings  []string `json:"siblings"`
+	LeafKey   string   `json:"leaf_key,omitempty"`
+	LeafValue string   `json:"leaf_value,omitempty"`
+}
+
+// VerifyStateProof checks that address holds balance of tokenName in the
+// state with the given root. A zero balance is proven by the absence of its
+// leaf.
+func VerifyStateProof(root [32]byte, address string, tokenName string, balance decimal.Decimal, proof *StateProof) error {
+	if proof == nil || len(proof.Siblings) > 256 {
+		return ErrInvalidProof
+	}
+	key := StateKey(address, tokenName)
+
+	var h [32]byte
+	switch {
+	case !balance.IsZero():
+		if proof.LeafKey != "" {
+			return fmt.Errorf("%w: unexpected leaf", ErrInvalidProof)
+		}
+		h = leafHash(key, balanceHash(balance))
+	case proof.LeafKey != "":
+		other, err := decodeHash(proof.LeafKey)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidProof, err)
+		}
+		value, err := decodeHash(proof.LeafValue)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidProof, err)
+		}
+		if other == key {
+			return fmt.Errorf("%w: the balance is not zero", ErrInvalidProof)
+		}
+		for depth := range proof.Siblings {
+			if bit(other, depth) != bit(key, depth) {
+				return fmt.Errorf("%w: leaf is off the path", ErrInvalidProof)
+			}
+		}
+		h = leafHash(other, value)
+	}
+
+	for depth := len(proof.Siblings) - 1; depth >= 0; depth-- {
+		sibling, err := decodeHash(proof.Siblings[depth])
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidProof, err)
+		}
+		if bit(key, depth) {
+			h = nodeHash(sibling, h)
+		} else {
+			h = nodeHash(h, sibling)
+		}
+	}
+	if h != root {
+		return fmt.Errorf("%w: root mismatch", ErrInvalidProof)
+	}
+	return nil
+}
+
+// BalanceProof proves the balance of an address in a token at a block.
+type BalanceProof struct {
+	BlockchainAddress string          `json:"blockchain_address"`
+	TokenName         string          `json:"token_name"`
+	Balance           decimal.Decimal `json:"balance"`
+	BlockHeight       int64           `json:"block_height"`
+	BlockHash         string          `json:"block_hash"`
+	Header            *BlockHeader    `json:"header"`
+	Proof             *StateProof     `json:"proof"`
+}
+
+// Verify checks the proof against its header. Whether the header belongs to
+// the chain is up to the caller.
+func (bp *BalanceProof) Verify() error {
+	if bp.Header == nil {
+		return fmt.Errorf("%w: header is missing", ErrInvalidProof)
+	}
+	if fmt.Sprintf("%x", bp.Header.Hash()) != bp.BlockHash {
+		return fmt.Errorf("%w: header does not match the block hash", ErrInvalidProof)
+	}
+	root, err := decodeHash(bp.Header.StateRoot)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+	return VerifyStateProof(root, bp.BlockchainAddress, bp.TokenName, bp.Balance, bp.Proof)
+}
+
+// balances sums the balance changes of chain per spend key.
+func (bc *Blockchain) balances(chain []*Block) map[string]decimal.Decimal {
+	s, start := bc.base(chain)
+	balances := copyMap(s.Balances)
+	for _, b := range chain[start:] {
+		bc.applyBalances(balances, b.transactions)
+	}
+	return balances
+}
+
+func (bc *Blockchain) applyBalances(balances map[string]decimal.Decimal, transactions []*Transaction) {
+	for _, t := range transactions {
+		for _, e := range bc.entries(t) {
+			key := spendKey(e.address, e.tokenName)
+			balances[key] = balances[key].Add(e.amount)
+		}
+	}
+}
+
+// ProveBalance returns the balance of address in tokenName at the tip with
+// its proof against the state root of the tip.
+func (bc *Blockchain) ProveBalance(address string, tokenName string) (*BalanceProof, error) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+
+	balances := bc.balances(bc.chain)
+	storage, err := bc.loadStorage()
+	if err != nil {
+		return nil, err
+	}
+	tree := newStateTree(balances, bc.contracts(bc.chain), storage.writes)
+	tip := bc.lastBlock()
+	return &BalanceProof{
+		BlockchainAddress: address,
+		TokenName:         tokenName,
+		Balance:           balances[spendKey(address, tokenName)],
+		BlockHeight:       int64(len(bc.chain) - 1),
+		BlockHash:         fmt.Sprintf("%x", tip.Hash()),
+		Header:            tip.Header(),
+		Proof:             tree.prove(StateKey(address, tokenName)),
+	}, nil
+}
@@ -0,0 +1,208 @@
+package block
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestVerifyStateProof(t *testing.T) {
+	balances := map[string]decimal.Decimal{
+		spendKey("alice", "DNZ"): decimal.NewFromInt(10),
+		spendKey("bob", "DNZ"):   decimal.NewFromInt(3),
+		spendKey("bob", "XYZ"):   decimal.NewFromInt(7),
+		spendKey("carol", "DNZ"): decimal.NewFromInt(1),
+		spendKey("dave", "DNZ"):  decimal.Zero,
+	}
+	tree := newStateTree(balances, nil, nil)
+	root := tree.root()
+	tamperedHash := fmt.Sprintf("%x", [32]byte{1})
+
+	tests := []struct {
+		name    string
+		address string
+		token   string
+		balance decimal.Decimal
+		tamper  func(p *StateProof)
+		wantErr bool
+	}{
+		{"balance", "alice", "DNZ", decimal.NewFromInt(10), nil, false},
+		{"other token", "bob", "XYZ", decimal.NewFromInt(7), nil, false},
+		{"zero balance", "dave", "DNZ", decimal.Zero, nil, false},
+		{"unknown address", "erin", "DNZ", decimal.Zero, nil, false},
+		{"wrong balance", "alice", "DNZ", decimal.NewFromInt(11), nil, true},
+		{"absent balance claimed", "erin", "DNZ", decimal.NewFromInt(1), nil, true},
+		{"present balance denied", "bob", "DNZ", decimal.Zero, nil, true},
+		{"tampered sibling", "alice", "DNZ", decimal.NewFromInt(10), func(p *StateProof) {
+			p.Siblings[len(p.Siblings)-1] = tamperedHash
+		}, true},
+		{"dropped sibling", "carol", "DNZ", decimal.NewFromInt(1), func(p *StateProof) {
+			p.Siblings = p.Siblings[1:]
+		}, true},
+		{"tampered leaf", "erin", "DNZ", decimal.Zero, func(p *StateProof) {
+			if p.LeafKey == "" {
+				p.LeafKey = fmt.Sprintf("%x", StateKey("alice", "DNZ"))
+			}
+			p.LeafValue = tamperedHash
+		}, true},
+		{"leaf of the balance", "bob", "DNZ", decimal.Zero, func(p *StateProof) {
+			p.LeafKey = fmt.Sprintf("%x", StateKey("bob", "DNZ"))
+			p.LeafValue = fmt.Sprintf("%x", balanceHash(decimal.NewFromInt(3)))
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proof := tree.prove(StateKey(tt.address, tt.token))
+			if tt.tamper != nil {
+				tt.tamper(proof)
+			}
+			err := VerifyStateProof(root, tt.address, tt.token, tt.balance, proof)
+			if tt.wantErr && !errors.Is(err, ErrInvalidProof) {
+				t.Errorf("VerifyStateProof() = %v, want %v", err, ErrInvalidProof)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("VerifyStateProof() = %v", err)
+			}
+		})
+	}
+}
+
+func TestContractLeaf(t *testing.T) {
+	info := &ContractInfo{Address: "contract", Code: "00"}
+	storage := map[uint64]uint64{1: 5}
+	root := newStateTree(nil, map[string]*ContractInfo{"contract": info}, map[string]map[uint64]uint64{"contract": storage}).root()
+
+	tests := []struct {
+		name     string
+		code     string
+		storage  map[uint64]uint64
+		wantSame bool
+	}{
+		{"same", "00", map[uint64]uint64{1: 5}, true},
+		{"zero slot", "00", map[uint64]uint64{1: 5, 2: 0}, true},
+		{"other code", "f3", map[uint64]uint64{1: 5}, false},
+		{"other value", "00", map[uint64]uint64{1: 6}, false},
+		{"other slot", "00", map[uint64]uint64{2: 5}, false},
+		{"no storage", "00", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := &ContractInfo{Address: "contract", Code: tt.code}
+			got := newStateTree(nil, map[string]*ContractInfo{"contract": other}, map[string]map[uint64]uint64{"contract": tt.storage}).root()
+			if (got == root) != tt.wantSame {
+				t.Errorf("root changed = %v, want %v", got != root, !tt.wantSame)
+			}
+		})
+	}
+}
+
+// counterCode adds one to storage slot 0 and returns the new value.
+func counterCode() string {
+	push := func(b []byte, v uint64) []byte {
+		return binary.BigEndian.AppendUint64(append(b, byte(OpPush)), v)
+	}
+	code := push(nil, 0)
+	code = append(code, byte(OpSLoad))
+	code = push(code, 1)
+	code = append(code, byte(OpAdd), byte(OpDup), 1)
+	code = push(code, 0)
+	code = append(code, byte(OpSStore), byte(OpReturn))
+	return hex.EncodeToString(code)
+}
+
+func TestValidChainChecksContractState(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	alice := newTestKey(t, bc)
+	bc.blockchainAddress = alice.address
+	mine(t, bc, 3)
+
+	deploy := NewTransaction(alice.address, "", Token{})
+	deploy.txType, deploy.deploy = TxDeploy, &ContractDeploy{Code: counterCode()}
+	sign(bc, alice, deploy, 1)
+	if err := bc.AddTransaction(deploy); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, 1)
+	contracts := bc.contracts(bc.Chain())
+	var address string
+	for a := range contracts {
+		address = a
+	}
+	call := NewTransaction(alice.address, address, Token{})
+	call.txType, call.call = TxCall, &ContractCall{GasLimit: 10000}
+	sign(bc, alice, call, 2)
+	if err := bc.AddTransaction(call); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, 1)
+
+	if info, _ := bc.Contract(address); info.Storage[0] != 1 {
+		t.Fatalf("counter = %d, want 1", info.Storage[0])
+	}
+	chain := bc.Chain()
+	if !bc.ValidChain(chain) {
+		t.Fatal("mined chain is not valid")
+	}
+
+	tests := []struct {
+		name    string
+		storage map[string]map[uint64]uint64
+	}{
+		{"storage left out", nil},
+		{"other storage", map[string]map[uint64]uint64{address: {0: 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tip := chain[len(chain)-1]
+			b := NewBlock(0, tip.previousHash, newStateTree(bc.balances(chain), contracts, tt.storage).root(), tip.transactions)
+			b.timestamp = tip.timestamp
+			b.nonce = bc.ProofOfWork(b)
+			forged := append(append([]*Block{}, chain[:len(chain)-1]...), b)
+			if bc.ValidChain(forged) {
+				t.Error("chain with a wrong contract state is valid")
+			}
+		})
+	}
+}
+
+func TestTipContracts(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	alice := newTestKey(t, bc)
+	bc.blockchainAddress = alice.address
+	mine(t, bc, 3)
+	other := newTestChain(t, alice.address, 0, 0)
+	syncFrom(other, bc)
+	mine(t, other, 1)
+
+	for nonce := uint64(1); nonce <= 2; nonce++ {
+		deploy := NewTransaction(alice.address, "", Token{})
+		deploy.SetDeploy(&ContractDeploy{Code: counterCode()})
+		sign(bc, alice, deploy, nonce)
+		if err := bc.AddTransaction(deploy); err != nil {
+			t.Fatal(err)
+		}
+		mine(t, bc, 2)
+	}
+
+	check := func(bc *Blockchain, want int) {
+		t.Helper()
+		bc.mux.RLock()
+		defer bc.mux.RUnlock()
+		replayed := bc.contracts(bc.chain)
+		if len(bc.tipContracts) != want || len(replayed) != want {
+			t.Fatalf("%d contracts at the tip, %d replayed, want %d", len(bc.tipContracts), len(replayed), want)
+		}
+		for address := range replayed {
+			if _, ok := bc.tipContracts[address]; !ok {
+				t.Errorf("contract %s is missing at the tip", address)
+			}
+		}
+	}
+	check(bc, 2)
+	syncFrom(bc, other)
+	check(bc, 0)
+}
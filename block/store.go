@@ -0,0 +1,244 @@
+package block
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Blocks are persisted in Badger under their height, so a node restarts with
+// its chain. State derived from the chain, the contract storage, the
+// receipts and the block filters, is updated with every block and rebuilt
+// when the chain is replaced.
+const blockKeyPrefix = "b/"
+
+func blockKey(height int64) []byte {
+	return []byte(fmt.Sprintf("%s%016x", blockKeyPrefix, height))
+}
+
+// saveBlocks persists the blocks from height on and removes persisted blocks
+// beyond the tip.
+func (bc *Blockchain) saveBlocks(from int) error {
+	wb := bc.db.NewWriteBatch()
+	defer wb.Cancel()
+	for height := from; height < len(bc.chain); height++ {
+		m, err := json.Marshal(bc.chain[height])
+		if err != nil {
+			return err
+		}
+		if err := wb.Set(blockKey(int64(height)), m); err != nil {
+			return err
+		}
+	}
+
+	var stale [][]byte
+	err := bc.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(blockKeyPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(blockKey(int64(len(bc.chain)))); it.Valid(); it.Next() {
+			stale = append(stale, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range stale {
+		if err := wb.Delete(k); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// loadChain reads the persisted blocks, stopping at the first gap.
+func (bc *Blockchain) loadChain() ([]*Block, error) {
+	var chain []*Block
+	err := bc.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(blockKeyPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if string(it.Item().Key()) != string(blockKey(int64(len(chain)))) {
+				break
+			}
+			b := new(Block)
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, b)
+			})
+			if err != nil {
+				return err
+			}
+			chain = append(chain, b)
+		}
+		return nil
+	})
+	return chain, err
+}
+
+// restoreChain replaces the genesis only chain with the persisted one if it
+// is valid.
+func (bc *Blockchain) restoreChain() {
+	chain, err := bc.loadChain()
+	if err != nil {
+		log.Printf("ERROR: could not load the chain: %v", err)
+		return
+	}
+	if len(chain) <= 1 {
+		return
+	}
+	if !bc.ValidChain(chain) {
+		log.Printf("ERROR: persisted chain of %d blocks is invalid, starting from genesis", len(chain))
+		return
+	}
+	bc.chain = chain
+	log.Printf("restored %d blocks", len(chain))
+}
+
+// executeBlock runs the block at height, the tip of the chain, persists it
+// and the resulting contract storage, receipts and filter.
+func (bc *Blockchain) executeBlock(height int64) {
+	b := bc.chain[height]
+	storage := newStorageOverlay(dbStorage{bc.db})
+	results := make([]*ExecResult, len(b.transactions))
+	for i, t := range b.transactions {
+		results[i] = bc.applyContract(bc.tipContracts, storage, t, height)
+	}
+
+	wb := bc.db.NewWriteBatch()
+	defer wb.Cancel()
+	err := commitStorage(wb, storage)
+	if err == nil {
+		err = saveReceipts(wb, height, bc.buildReceipts(b, height, results))
+	}
+	var previousHeader [32]byte
+	if err == nil {
+		previousHeader, err = bc.previousFilterHeader(height)
+	}
+	if err == nil {
+		err = saveFilter(wb, bc.buildFilter(b, height, previousHeader))
+	}
+	if err == nil {
+		err = wb.Flush()
+	}
+	if err != nil {
+		log.Printf("ERROR: could not persist the state of block %d: %v", height, err)
+	}
+	if err := bc.saveBlocks(int(height)); err != nil {
+		log.Printf("ERROR: could not persist block %d: %v", height, err)
+	}
+	bc.prune()
+}
+
+// rebuildState replays the chain into empty contract storage, receipts and
+// filters and persists its blocks from height from on. A pruned node replays
+// from its snapshot and keeps the filters below it.
+func (bc *Blockchain) rebuildState(from int) {
+	s, start := bc.base(bc.chain)
+	bc.tipContracts = bc.contracts(bc.chain)
+	if err := bc.resetState(start); err != nil {
+		log.Printf("ERROR: could not reset the state: %v", err)
+		return
+	}
+
+	contracts := cloneInfos(s.Contracts)
+	storage := s.storage()
+	previousHeader, err := bc.previousFilterHeader(int64(start))
+	if err != nil {
+		log.Printf("ERROR: could not read the filter below height %d: %v", start, err)
+		return
+	}
+	wb := bc.db.NewWriteBatch()
+	defer wb.Cancel()
+	for height := start; height < len(bc.chain); height++ {
+		b := bc.chain[height]
+		results := make([]*ExecResult, len(b.transactions))
+		for i, t := range b.transactions {
+			results[i] = bc.applyContract(contracts, storage, t, int64(height))
+		}
+		if err := saveReceipts(wb, int64(height), bc.buildReceipts(b, int64(height), results)); err != nil {
+			log.Printf("ERROR: could not persist receipts: %v", err)
+			return
+		}
+		f := bc.buildFilter(b, int64(height), previousHeader)
+		previousHeader, _ = decodeHash(f.Header)
+		if err := saveFilter(wb, f); err != nil {
+			log.Printf("ERROR: could not persist filters: %v", err)
+			return
+		}
+	}
+	err = commitStorage(wb, storage)
+	if err == nil {
+		err = wb.Flush()
+	}
+	if err != nil {
+		log.Printf("ERROR: could not persist the state: %v", err)
+	}
+	if err := bc.saveBlocks(from); err != nil {
+		log.Printf("ERROR: could not persist the chain: %v", err)
+	}
+	bc.prune()
+}
+
+// resetState drops the contract storage and the receipts and filters of the
+// blocks from height start on, and the snapshot with a start at genesis.
+func (bc *Blockchain) resetState(start int) error {
+	if start == 0 {
+		for _, prefix := range []string{contractStoragePrefix, receiptKeyPrefix, receiptIndexKeyPrefix, filterKeyPrefix, snapshotKey} {
+			if err := bc.db.DropPrefix([]byte(prefix)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := bc.db.DropPrefix([]byte(contractStoragePrefix)); err != nil {
+		return err
+	}
+
+	var stale [][]byte
+	err := bc.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for prefix, first := range map[string][]byte{
+			receiptKeyPrefix: receiptKey(int64(start)),
+			filterKeyPrefix:  filterKey(int64(start)),
+		} {
+			for it.Seek(first); it.ValidForPrefix([]byte(prefix)); it.Next() {
+				stale = append(stale, it.Item().KeyCopy(nil))
+			}
+		}
+		for it.Seek([]byte(receiptIndexKeyPrefix)); it.ValidForPrefix([]byte(receiptIndexKeyPrefix)); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				if binary.BigEndian.Uint64(val) >= uint64(start) {
+					stale = append(stale, it.Item().KeyCopy(nil))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	wb := bc.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range stale {
+		if err := wb.Delete(k); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
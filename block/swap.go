@@ -0,0 +1,99 @@
+package block
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"main/utils"
+
+	"github.com/shopspring/decimal"
+)
+
+// TxSwap exchanges tokens between two parties. The sender pays the token of
+// the transaction to the recipient, the recipient pays the swap token back.
+// Both parties sign the same payload, and either both legs are applied or
+// none.
+const TxSwap = "swap"
+
+var ErrInvalidSwap = errors.New("invalid swap")
+
+// Swap is the leg the recipient of a swap pays to the sender.
+type Swap struct {
+	TokenName     string          `json:"token_name"`
+	TokenValue    decimal.Decimal `json:"token_value"`
+	TokenDecimals uint8           `json:"token_decimals"`
+}
+
+func (s *Swap) Token() Token {
+	return Token{TokenName: s.TokenName, TokenValue: s.TokenValue}
+}
+
+func (s *Swap) Fixed() *FixedToken {
+	if s == nil {
+		return nil
+	}
+	ft := NewFixedToken(s.Token(), s.TokenDecimals)
+	return &ft
+}
+
+func swapFromFixed(ft *FixedToken) (*Swap, error) {
+	if ft == nil {
+		return nil, nil
+	}
+	token, err := ft.Token()
+	if err != nil {
+		return nil, err
+	}
+	return &Swap{TokenName: token.TokenName, TokenValue: token.TokenValue, TokenDecimals: ft.Decimals}, nil
+}
+
+// SetSwap turns t into a swap in which the recipient pays swap back.
+func (t *Transaction) SetSwap(swap *Swap) {
+	t.txType = TxSwap
+	t.swap = swap
+}
+
+// SetCounterpartySignature attaches the signature of the recipient of a swap.
+func (t *Transaction) SetCounterpartySignature(publicKey *ecdsa.PublicKey, s *utils.Signature) {
+	t.counterpartyPublicKey = publicKey
+	t.counterpartySignature = s
+}
+
+// verifySwap checks that the recipient of a swap signed the same payload as
+// the sender.
+func (bc *Blockchain) verifySwap(t *Transaction) error {
+	if err := bc.validateAddress(t.recipientBlockchainAddress); err != nil {
+		return fmt.Errorf("recipient: %w", err)
+	}
+	if t.recipientBlockchainAddress == t.senderBlockchainAddress {
+		return fmt.Errorf("%w: sender and recipient must differ", ErrInvalidSwap)
+	}
+	if t.swap == nil {
+		return fmt.Errorf("%w: the counter leg is missing", ErrInvalidSwap)
+	}
+	if t.counterpartyPublicKey == nil || t.counterpartySignature == nil {
+		return fmt.Errorf("%w: the recipient has not signed", ErrInvalidSwap)
+	}
+	if utils.AddressFromPublicKey(t.counterpartyPublicKey, bc.network.AddressVersion) != t.recipientBlockchainAddress {
+		return fmt.Errorf("%w: counterparty key does not belong to the recipient", ErrInvalidSwap)
+	}
+	if err := bc.VerifyTransactionSignature(t.counterpartyPublicKey, t.counterpartySignature, t); err != nil {
+		return fmt.Errorf("counterparty: %w", err)
+	}
+	return nil
+}
+
+// validateSwap checks the counter leg of a swap against the token registry.
+func validateSwap(t *Transaction, tokens map[string]*TokenInfo) error {
+	info, ok := tokens[t.swap.TokenName]
+	if !ok {
+		return fmt.Errorf("swap: %w: %s", ErrUnknownToken, t.swap.TokenName)
+	}
+	if !t.swap.TokenValue.IsPositive() {
+		return fmt.Errorf("swap: %w", ErrInvalidAmount)
+	}
+	if t.swap.TokenDecimals != info.Decimals || !fitsDecimals(t.swap.TokenValue, info.Decimals) {
+		return fmt.Errorf("swap: %w: %s has %d decimals", ErrWrongDecimals, t.swap.TokenName, info.Decimals)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package block
+
+import (
+	"errors"
+	"main/utils"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// countersign signs tx as the counterparty of a swap.
+func countersign(tx *Transaction, counterparty *testKey) {
+	h := tx.SigningHash()
+	tx.SetCounterpartySignature(&counterparty.privateKey.PublicKey, utils.Sign(counterparty.privateKey, h[:]))
+}
+
+func TestSwap(t *testing.T) {
+	tests := []struct {
+		name string
+		// edit runs before the sender signs, sign replaces the signature
+		// of the counterparty.
+		edit func(tx *Transaction)
+		sign func(tx *Transaction, bob, carol *testKey)
+		want error
+	}{
+		{"valid", nil, nil, nil},
+		{"to itself", func(tx *Transaction) {
+			tx.recipientBlockchainAddress = tx.senderBlockchainAddress
+		}, nil, ErrInvalidSwap},
+		{"no counter leg", func(tx *Transaction) { tx.swap = nil }, nil, ErrInvalidSwap},
+		{"not countersigned", nil, func(tx *Transaction, bob, carol *testKey) {
+			tx.SetCounterpartySignature(nil, nil)
+		}, ErrInvalidSwap},
+		{"countersigned by another", nil, func(tx *Transaction, bob, carol *testKey) {
+			countersign(tx, carol)
+		}, ErrInvalidSwap},
+		{"countersigned other terms", nil, func(tx *Transaction, bob, carol *testKey) {
+			tx.swap.TokenValue = decimal.NewFromInt(1)
+			countersign(tx, bob)
+			tx.swap.TokenValue = decimal.NewFromInt(10)
+		}, ErrInvalidSignature},
+		{"unknown counter token", func(tx *Transaction) { tx.swap.TokenName = "SILVER" }, nil, ErrUnknownToken},
+		{"zero counter leg", func(tx *Transaction) { tx.swap.TokenValue = decimal.Zero }, nil, ErrInvalidAmount},
+		{"counter leg decimals", func(tx *Transaction) { tx.swap.TokenDecimals = 2 }, nil, ErrWrongDecimals},
+		{"counter leg beyond the balance", func(tx *Transaction) {
+			tx.swap.TokenValue = decimal.NewFromInt(101)
+		}, nil, ErrInsufficientBalance},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob, carol := newTestKey(t, bc), newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 1)
+			if err := bc.AddTransaction(issue(bc, bob, "GOLD", "100", &TokenIssue{}, 1)); err != nil {
+				t.Fatal(err)
+			}
+			mine(t, bc, 2)
+
+			tx := NewTransaction(alice.address, bob.address, Token{TokenName: "DNZ", TokenValue: decimal.NewFromInt(5)})
+			tx.SetDecimals(NativeTokenDecimals)
+			tx.SetSwap(&Swap{TokenName: "GOLD", TokenValue: decimal.NewFromInt(10)})
+			if tt.edit != nil {
+				tt.edit(tx)
+			}
+			sign(bc, alice, tx, 1)
+			countersign(tx, bob)
+			if tt.sign != nil {
+				tt.sign(tx, bob, carol)
+			}
+			if err := bc.AddTransaction(tx); !errors.Is(err, tt.want) {
+				t.Fatalf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+
+			mine(t, bc, 1)
+			for _, c := range []struct {
+				address, token, want string
+			}{
+				{alice.address, "GOLD", "10"},
+				{bob.address, "GOLD", "90"},
+				{bob.address, "DNZ", "5"},
+			} {
+				if got := bc.CalculateTotalAmount(c.address, c.token); !got.Equal(decimal.RequireFromString(c.want)) {
+					t.Errorf("%s balance %s, want %s", c.token, got, c.want)
+				}
+			}
+		})
+	}
+}
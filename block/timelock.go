@@ -0,0 +1,79 @@
+package block
+
+import (
+	"errors"
+	"time"
+)
+
+// MaxClockDrift is how far the timestamp of a block may be ahead of the
+// clock of the node checking it. Time locks end at most this much early.
+const MaxClockDrift = 2 * time.Minute
+
+var (
+	ErrInvalidLock      = errors.New("transaction expires before its lock ends")
+	ErrInvalidTimestamp = errors.New("block timestamp must be after its parent and not in the future")
+)
+
+// SetLock sets the block height and the unix time from which on the
+// transaction may be mined. Zero disables either lock. Both values are part
+// of the signed payload.
+func (t *Transaction) SetLock(height int64, timestamp int64) {
+	t.lockHeight = height
+	t.lockedUntil = timestamp
+}
+
+// Locked reports whether t may not yet be mined in a block at height created
+// at now.
+func (t *Transaction) Locked(height int64, now time.Time) bool {
+	if t.lockHeight > 0 && height < t.lockHeight {
+		return true
+	}
+	if t.lockedUntil > 0 && now.Unix() < t.lockedUntil {
+		return true
+	}
+	return false
+}
+
+// verifyLock rejects locks that only end after the transaction expired.
+func verifyLock(t *Transaction) error {
+	if t.lockHeight < 0 || t.lockedUntil < 0 {
+		return ErrInvalidLock
+	}
+	if t.expiryHeight > 0 && t.lockHeight > t.expiryHeight {
+		return ErrInvalidLock
+	}
+	if t.expiresAt > 0 && t.lockedUntil >= t.expiresAt {
+		return ErrInvalidLock
+	}
+	return nil
+}
+
+// unlocked returns the transactions that may be mined in a block at height
+// created at now.
+func unlocked(transactions []*Transaction, height int64, now time.Time) []*Transaction {
+	ready := make([]*Transaction, 0, len(transactions))
+	for _, t := range transactions {
+		if !t.Locked(height, now) {
+			ready = append(ready, t)
+		}
+	}
+	return ready
+}
+
+// verifyTimestamp checks that b was created after previous and not later
+// than MaxClockDrift from now.
+func verifyTimestamp(b *Block, previous *Block, now time.Time) error {
+	if b.timestamp <= previous.timestamp || b.timestamp > now.Add(MaxClockDrift).UnixNano() {
+		return ErrInvalidTimestamp
+	}
+	return nil
+}
+
+// blockTime returns the timestamp of a block created at now on top of
+// previous, just after previous if the clock is behind it.
+func blockTime(previous *Block, now time.Time) int64 {
+	if t := now.UnixNano(); t > previous.timestamp {
+		return t
+	}
+	return previous.timestamp + 1
+}
@@ -0,0 +1,49 @@
+package block
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyTimestamp(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	previous := &Block{timestamp: now.Add(-time.Minute).UnixNano()}
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		wantErr   bool
+	}{
+		{"after parent", now, false},
+		{"within drift", now.Add(MaxClockDrift), false},
+		{"same as parent", now.Add(-time.Minute), true},
+		{"before parent", now.Add(-time.Hour), true},
+		{"beyond drift", now.Add(MaxClockDrift + time.Second), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Block{timestamp: tt.timestamp.UnixNano()}
+			if err := verifyTimestamp(b, previous, now); (err != nil) != tt.wantErr {
+				t.Errorf("verifyTimestamp() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBlockTime(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		previous time.Time
+		want     int64
+	}{
+		{"clock ahead", now.Add(-time.Second), now.UnixNano()},
+		{"clock behind", now.Add(time.Second), now.Add(time.Second).UnixNano() + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blockTime(&Block{timestamp: tt.previous.UnixNano()}, now); got != tt.want {
+				t.Errorf("blockTime() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package block
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// Transaction types. Plain transfers leave the type empty.
+const (
+	TxTransfer   = ""
+	TxIssueToken = "issue_token"
+	TxMint       = "mint"
+	TxBurn       = "burn"
+)
+
+const (
+	// NativeTokenDecimals applies to the reward token and to the tokens
+	// allocated in genesis.
+	NativeTokenDecimals = 8
+	MaxTokenDecimals    = 18
+)
+
+var symbolPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{1,9}$`)
+
+var (
+	ErrUnknownTransactionType = errors.New("unknown transaction type")
+	ErrUnknownToken           = errors.New("token is not registered")
+	ErrTokenExists            = errors.New("token is already registered")
+	ErrInvalidIssue           = errors.New("invalid token issue")
+	ErrNotMintAuthority       = errors.New("sender is not the mint authority of the token")
+	ErrNotBurnable            = errors.New("token is not burnable")
+	ErrInvalidAmount          = errors.New("amount must be positive")
+	ErrWrongDecimals          = errors.New("amount does not match the decimals of the token")
+)
+
+// TokenIssue holds the parameters of an issue_token transaction. The symbol
+// and the initial supply are the token of the transaction, which is credited
+// to the owner.
+type TokenIssue struct {
+	Decimals      uint8  `json:"decimals"`
+	MintAuthority string `json:"mint_authority,omitempty"`
+	Burnable      bool   `json:"burnable,omitempty"`
+}
+
+// TokenInfo is a registered token. TotalSupply is what was issued, Minted and
+// Burned track the supply changes since.
+type TokenInfo struct {
+	Symbol        string          `json:"symbol"`
+	Owner         string          `json:"owner"`
+	Decimals      uint8           `json:"decimals"`
+	TotalSupply   decimal.Decimal `json:"total_supply"`
+	Minted        decimal.Decimal `json:"minted"`
+	Burned        decimal.Decimal `json:"burned"`
+	Circulating   decimal.Decimal `json:"circulating"`
+	MintAuthority string          `json:"mint_authority,omitempty"`
+	Burnable      bool            `json:"burnable"`
+	Height        int64           `json:"height"`
+	TransactionID string          `json:"transaction_id,omitempty"`
+}
+
+// Tokens registers the reward token and every token allocated in genesis.
+func (g *Genesis) Tokens() map[string]*TokenInfo {
+	tokens := map[string]*TokenInfo{
+		g.RewardSchedule.Token: {Symbol: g.RewardSchedule.Token},
+	}
+	for _, allocation := range g.Allocations {
+		for tokenName := range allocation {
+			tokens[tokenName] = &TokenInfo{Symbol: tokenName}
+		}
+	}
+	for name, info := range tokens {
+		info.Owner = GenesisSender
+		info.Decimals = NativeTokenDecimals
+		info.TotalSupply = g.Allocated(name)
+	}
+	return tokens
+}
+
+func newTokenInfo(t *Transaction, height int64) *TokenInfo {
+	return &TokenInfo{
+		Symbol:        t.token.TokenName,
+		Owner:         t.senderBlockchainAddress,
+		Decimals:      t.issue.Decimals,
+		TotalSupply:   t.token.TokenValue,
+		MintAuthority: t.issue.MintAuthority,
+		Burnable:      t.issue.Burnable,
+		Height:        height,
+		TransactionID: t.ID(),
+	}
+}
+
+// applyToken records the effect of a valid transaction at height on the
+// token registry. Mining rewards count as minted.
+func (bc *Blockchain) applyToken(tokens map[string]*TokenInfo, t *Transaction, height int64) {
+	switch {
+	case t.txType == TxIssueToken:
+		tokens[t.token.TokenName] = newTokenInfo(t, height)
+	case t.txType == TxMint, t.senderBlockchainAddress == bc.conf.MiningSender:
+		if info, ok := tokens[t.token.TokenName]; ok {
+			info.Minted = info.Minted.Add(t.token.TokenValue)
+		}
+	case t.txType == TxBurn:
+		if info, ok := tokens[t.token.TokenName]; ok {
+			info.Burned = info.Burned.Add(t.token.TokenValue)
+		}
+	}
+}
+
+// tokens builds the token registry of chain.
+func (bc *Blockchain) tokens(chain []*Block) map[string]*TokenInfo {
+	s, start := bc.base(chain)
+	tokens := cloneInfos(s.Tokens)
+	for height := start; height < len(chain); height++ {
+		for _, t := range chain[height].transactions {
+			bc.applyToken(tokens, t, int64(height))
+		}
+	}
+	return tokens
+}
+
+// validateIssue checks an issue_token transaction against the registry.
+func (bc *Blockchain) validateIssue(t *Transaction, tokens map[string]*TokenInfo) error {
+	if t.issue == nil {
+		return fmt.Errorf("%w: issue parameters are missing", ErrInvalidIssue)
+	}
+	if !symbolPattern.MatchString(t.token.TokenName) {
+		return fmt.Errorf("%w: symbol must be 2 to 10 upper case letters or digits", ErrInvalidIssue)
+	}
+	if _, ok := tokens[t.token.TokenName]; ok {
+		return ErrTokenExists
+	}
+	if t.recipientBlockchainAddress != t.senderBlockchainAddress {
+		return fmt.Errorf("%w: the supply must be issued to the owner", ErrInvalidIssue)
+	}
+	if t.issue.Decimals > MaxTokenDecimals {
+		return fmt.Errorf("%w: at most %d decimals are allowed", ErrInvalidIssue, MaxTokenDecimals)
+	}
+	if err := checkDecimals(t, t.issue.Decimals); err != nil {
+		return err
+	}
+	if t.token.TokenValue.IsNegative() {
+		return fmt.Errorf("%w: total supply must not be negative", ErrInvalidIssue)
+	}
+	if t.issue.MintAuthority == "" && !t.token.TokenValue.IsPositive() {
+		return fmt.Errorf("%w: a token without mint authority needs a total supply", ErrInvalidIssue)
+	}
+	if t.issue.MintAuthority != "" {
+		if err := bc.validateAddress(t.issue.MintAuthority); err != nil {
+			return fmt.Errorf("%w: mint authority: %v", ErrInvalidIssue, err)
+		}
+	}
+	return nil
+}
+
+// checkDecimals verifies that the amount of t is signed in the unit of a
+// token with the given decimals and is not more precise than that.
+func checkDecimals(t *Transaction, decimals uint8) error {
+	if t.decimals != decimals {
+		return fmt.Errorf("%w: %s has %d decimals, got %d", ErrWrongDecimals, t.token.TokenName, decimals, t.decimals)
+	}
+	if !fitsDecimals(t.token.TokenValue, decimals) {
+		return fmt.Errorf("%w: %s has %d decimals", ErrWrongDecimals, t.token.TokenName, decimals)
+	}
+	return nil
+}
+
+// validateToken checks t against the token registry. Tokens have to be
+// issued before they can be transferred, only the mint authority may mint
+// and only burnable tokens can be burned. Amounts must be positive and
+// respect the decimals of the token.
+func (bc *Blockchain) validateToken(t *Transaction, tokens map[string]*TokenInfo) error {
+	if t.txType == TxIssueToken {
+		return bc.validateIssue(t, tokens)
+	}
+	if t.txType == TxMultiTransfer {
+		return bc.validateOutputs(t, tokens)
+	}
+	if isContract(t.txType) {
+		return nil
+	}
+	info, ok := tokens[t.token.TokenName]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownToken, t.token.TokenName)
+	}
+
+	switch t.txType {
+	case TxSwap:
+		if err := validateSwap(t, tokens); err != nil {
+			return err
+		}
+	case TxMint:
+		if info.MintAuthority == "" || t.senderBlockchainAddress != info.MintAuthority {
+			return ErrNotMintAuthority
+		}
+	case TxBurn:
+		if !info.Burnable {
+			return ErrNotBurnable
+		}
+	}
+	if !t.token.TokenValue.IsPositive() {
+		return ErrInvalidAmount
+	}
+	return checkDecimals(t, info.Decimals)
+}
+
+// Tokens returns the token registry ordered by symbol.
+func (bc *Blockchain) Tokens() []*TokenInfo {
+	bc.mux.RLock()
+	tokens := bc.tokens(bc.chain)
+	circulating := bc.supply(bc.chain)
+	bc.mux.RUnlock()
+
+	symbols := make([]string, 0, len(tokens))
+	for symbol, info := range tokens {
+		info.Circulating = circulating[symbol]
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	infos := make([]*TokenInfo, len(symbols))
+	for i, symbol := range symbols {
+		infos[i] = tokens[symbol]
+	}
+	return infos
+}
+
+func (bc *Blockchain) Token(symbol string) (*TokenInfo, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	info, ok := bc.tokens(bc.chain)[symbol]
+	if ok {
+		info.Circulating = bc.supply(bc.chain)[symbol]
+	}
+	return info, ok
+}
@@ -0,0 +1,161 @@
+package block
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// issue signs an issue_token transaction of value symbol by owner.
+func issue(bc *Blockchain, owner *testKey, symbol string, value string, ti *TokenIssue, nonce uint64) *Transaction {
+	tx := NewTransaction(owner.address, owner.address, Token{TokenName: symbol, TokenValue: decimal.RequireFromString(value)})
+	tx.SetIssue(ti)
+	if ti != nil {
+		tx.SetDecimals(ti.Decimals)
+	}
+	return sign(bc, owner, tx, nonce)
+}
+
+func TestIssueToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		symbol string
+		value  string
+		issue  *TokenIssue
+		edit   func(tx *Transaction, alice, bob *testKey)
+		want   error
+	}{
+		{"valid", "GOLD", "100", &TokenIssue{Decimals: 2}, nil, nil},
+		{"mintable without supply", "GOLD", "0", &TokenIssue{}, func(tx *Transaction, alice, bob *testKey) {
+			tx.issue.MintAuthority = alice.address
+		}, nil},
+		{"no parameters", "GOLD", "100", nil, nil, ErrInvalidIssue},
+		{"lower case symbol", "gold", "100", &TokenIssue{}, nil, ErrInvalidIssue},
+		{"one letter symbol", "G", "100", &TokenIssue{}, nil, ErrInvalidIssue},
+		{"reward token", "DNZ", "100", &TokenIssue{Decimals: NativeTokenDecimals}, nil, ErrTokenExists},
+		{"issued to another", "GOLD", "100", &TokenIssue{}, func(tx *Transaction, alice, bob *testKey) {
+			tx.recipientBlockchainAddress = bob.address
+		}, ErrInvalidIssue},
+		{"too many decimals", "GOLD", "100", &TokenIssue{Decimals: MaxTokenDecimals + 1}, nil, ErrInvalidIssue},
+		{"negative supply", "GOLD", "-1", &TokenIssue{}, nil, ErrInvalidIssue},
+		{"fixed without supply", "GOLD", "0", &TokenIssue{}, nil, ErrInvalidIssue},
+		{"invalid mint authority", "GOLD", "0", &TokenIssue{MintAuthority: "nobody"}, nil, ErrInvalidIssue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 1)
+			tx := issue(bc, alice, tt.symbol, tt.value, tt.issue, 1)
+			if tt.edit != nil {
+				tt.edit(tx, alice, bob)
+				sign(bc, alice, tx, 1)
+			}
+
+			if err := bc.AddTransaction(tx); !errors.Is(err, tt.want) {
+				t.Fatalf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+			mine(t, bc, 1)
+			info, ok := bc.Token(tt.symbol)
+			if !ok {
+				t.Fatalf("%s is not registered", tt.symbol)
+			}
+			if info.Owner != alice.address || info.Decimals != tt.issue.Decimals || info.TransactionID != tx.ID() {
+				t.Errorf("registered %+v", info)
+			}
+			if got := bc.CalculateTotalAmount(alice.address, tt.symbol); !got.Equal(decimal.RequireFromString(tt.value)) {
+				t.Errorf("owner holds %s, want %s", got, tt.value)
+			}
+		})
+	}
+}
+
+func TestIssueTokenTwice(t *testing.T) {
+	bc := newTestChain(t, "", 0, 0)
+	alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+	bc.blockchainAddress = alice.address
+	mine(t, bc, 1)
+	if err := bc.AddTransaction(issue(bc, alice, "GOLD", "100", &TokenIssue{}, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(issue(bc, bob, "GOLD", "100", &TokenIssue{}, 1)); err != ErrTokenExists {
+		t.Errorf("pooled issue: AddTransaction() = %v, want %v", err, ErrTokenExists)
+	}
+	mine(t, bc, 1)
+	if err := bc.AddTransaction(issue(bc, bob, "GOLD", "100", &TokenIssue{}, 1)); err != ErrTokenExists {
+		t.Errorf("mined issue: AddTransaction() = %v, want %v", err, ErrTokenExists)
+	}
+}
+
+func TestMintAndBurn(t *testing.T) {
+	tests := []struct {
+		name     string
+		mintable bool
+		burnable bool
+		txType   string
+		byOwner  bool
+		value    string
+		want     error
+	}{
+		{"mint by the authority", true, false, TxMint, true, "5", nil},
+		{"mint by another", true, false, TxMint, false, "5", ErrNotMintAuthority},
+		{"mint without authority", false, true, TxMint, true, "5", ErrNotMintAuthority},
+		{"mint nothing", true, false, TxMint, true, "0", ErrInvalidAmount},
+		{"burn burnable", false, true, TxBurn, true, "5", nil},
+		{"burn fixed", true, false, TxBurn, true, "5", ErrNotBurnable},
+		{"burn more than held", false, true, TxBurn, true, "100", ErrInsufficientBalance},
+		{"burn nothing", false, true, TxBurn, true, "0", ErrInvalidAmount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t, "", 0, 0)
+			alice, bob := newTestKey(t, bc), newTestKey(t, bc)
+			bc.blockchainAddress = alice.address
+			mine(t, bc, 1)
+			ti := TokenIssue{Burnable: tt.burnable}
+			if tt.mintable {
+				ti.MintAuthority = alice.address
+			}
+			if err := bc.AddTransaction(issue(bc, alice, "GOLD", "100", &ti, 1)); err != nil {
+				t.Fatal(err)
+			}
+			mine(t, bc, 1)
+
+			sender, nonce := alice, uint64(2)
+			if !tt.byOwner {
+				sender, nonce = bob, 1
+			}
+			recipient := bob.address
+			if tt.txType == TxBurn {
+				recipient = ""
+			}
+			tx := NewTransaction(sender.address, recipient, Token{TokenName: "GOLD", TokenValue: decimal.RequireFromString(tt.value)})
+			tx.SetType(tt.txType)
+			sign(bc, sender, tx, nonce)
+			if err := bc.AddTransaction(tx); err != tt.want {
+				t.Fatalf("AddTransaction() = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+			mine(t, bc, 1)
+
+			info, _ := bc.Token("GOLD")
+			want := decimal.NewFromInt(105)
+			if tt.txType == TxBurn {
+				want = decimal.NewFromInt(95)
+			}
+			if !info.Circulating.Equal(want) || !info.TotalSupply.Equal(decimal.NewFromInt(100)) {
+				t.Errorf("circulating %s of %s issued, want %s", info.Circulating, info.TotalSupply, want)
+			}
+			if !info.Minted.Sub(info.Burned).Equal(want.Sub(info.TotalSupply)) {
+				t.Errorf("minted %s, burned %s", info.Minted, info.Burned)
+			}
+		})
+	}
+}
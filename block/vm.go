@@ -0,0 +1,384 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Contracts run on a small deterministic stack machine. Words are unsigned
+// 64 bit integers and arithmetic wraps around, division by zero yields zero.
+// Every instruction costs gas. A call that runs out of gas, reverts or hits
+// an invalid instruction fails and none of its storage writes are kept.
+
+type Opcode byte
+
+const (
+	OpStop     Opcode = 0x00
+	OpAdd      Opcode = 0x01
+	OpSub      Opcode = 0x02
+	OpMul      Opcode = 0x03
+	OpDiv      Opcode = 0x04
+	OpMod      Opcode = 0x05
+	OpLt       Opcode = 0x10
+	OpGt       Opcode = 0x11
+	OpEq       Opcode = 0x12
+	OpIsZero   Opcode = 0x13
+	OpAnd      Opcode = 0x14
+	OpOr       Opcode = 0x15
+	OpXor      Opcode = 0x16
+	OpNot      Opcode = 0x17
+	OpSLoad    Opcode = 0x20
+	OpSStore   Opcode = 0x21
+	OpCaller   Opcode = 0x30
+	OpHeight   Opcode = 0x31
+	OpArg      Opcode = 0x32
+	OpArgc     Opcode = 0x33
+	OpPop      Opcode = 0x50
+	OpDup      Opcode = 0x51
+	OpSwap     Opcode = 0x52
+	OpJump     Opcode = 0x56
+	OpJumpI    Opcode = 0x57
+	OpJumpDest Opcode = 0x5b
+	OpPush     Opcode = 0x60
+	OpLog      Opcode = 0xa0
+	OpReturn   Opcode = 0xf3
+	OpRevert   Opcode = 0xfd
+)
+
+const (
+	MaxCodeSize   = 4096
+	MaxStackDepth = 1024
+	MaxCallArgs   = 16
+	MaxLogTopics  = 4
+	MaxCallGas    = 1000000
+	// DeployGasPerByte is the gas a deploy uses per byte of its code.
+	DeployGasPerByte = 200
+	// BlockGasLimit caps the sum of the gas limits of the calls in a block.
+	BlockGasLimit = 5000000
+)
+
+var (
+	ErrOutOfGas       = errors.New("out of gas")
+	ErrReverted       = errors.New("execution reverted")
+	ErrInvalidOpcode  = errors.New("invalid opcode")
+	ErrInvalidJump    = errors.New("invalid jump destination")
+	ErrStackUnderflow = errors.New("stack underflow")
+	ErrStackOverflow  = errors.New("stack overflow")
+)
+
+// opInfo describes an instruction. DUP and SWAP take a one byte operand
+// between 1 and 16, LOG the number of topics up to MaxLogTopics, PUSH an 8
+// byte big endian word.
+type opInfo struct {
+	name      string
+	immediate int
+	gas       uint64
+}
+
+var opcodes = map[Opcode]opInfo{
+	OpStop:     {"STOP", 0, 0},
+	OpAdd:      {"ADD", 0, 3},
+	OpSub:      {"SUB", 0, 3},
+	OpMul:      {"MUL", 0, 5},
+	OpDiv:      {"DIV", 0, 5},
+	OpMod:      {"MOD", 0, 5},
+	OpLt:       {"LT", 0, 3},
+	OpGt:       {"GT", 0, 3},
+	OpEq:       {"EQ", 0, 3},
+	OpIsZero:   {"ISZERO", 0, 3},
+	OpAnd:      {"AND", 0, 3},
+	OpOr:       {"OR", 0, 3},
+	OpXor:      {"XOR", 0, 3},
+	OpNot:      {"NOT", 0, 3},
+	OpSLoad:    {"SLOAD", 0, 200},
+	OpSStore:   {"SSTORE", 0, 5000},
+	OpCaller:   {"CALLER", 0, 2},
+	OpHeight:   {"HEIGHT", 0, 2},
+	OpArg:      {"ARG", 0, 3},
+	OpArgc:     {"ARGC", 0, 2},
+	OpPop:      {"POP", 0, 2},
+	OpDup:      {"DUP", 1, 3},
+	OpSwap:     {"SWAP", 1, 3},
+	OpJump:     {"JUMP", 0, 8},
+	OpJumpI:    {"JUMPI", 0, 10},
+	OpJumpDest: {"JUMPDEST", 0, 1},
+	OpPush:     {"PUSH", 8, 3},
+	OpLog:      {"LOG", 1, 375},
+	OpReturn:   {"RETURN", 0, 0},
+	OpRevert:   {"REVERT", 0, 0},
+}
+
+// Storage is the persistent key-value storage of the contracts.
+type Storage interface {
+	Load(contract string, key uint64) uint64
+}
+
+// CallContext is what a contract can learn about the call it runs in.
+type CallContext struct {
+	Contract string
+	Caller   string
+	Height   int64
+	Args     []uint64
+}
+
+// ExecResult is the outcome of running a contract. Writes and Logs are only
+// set if the call succeeded.
+type ExecResult struct {
+	GasUsed uint64
+	Return  uint64
+	Writes  map[uint64]uint64
+	Logs    []*Log
+	Err     error
+}
+
+// AddressWord is the word CALLER pushes for an address: the first 8 bytes of
+// its SHA-256 hash.
+func AddressWord(address string) uint64 {
+	h := sha256.Sum256([]byte(address))
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+// ValidateCode checks that code only holds known instructions with complete
+// operands.
+func ValidateCode(code []byte) error {
+	if len(code) == 0 || len(code) > MaxCodeSize {
+		return fmt.Errorf("%w: code must be between 1 and %d bytes", ErrInvalidContract, MaxCodeSize)
+	}
+	for pc := 0; pc < len(code); pc++ {
+		info, ok := opcodes[Opcode(code[pc])]
+		if !ok {
+			return fmt.Errorf("%w: unknown opcode 0x%02x at %d", ErrInvalidContract, code[pc], pc)
+		}
+		if info.immediate > 0 && pc+info.immediate >= len(code) {
+			return fmt.Errorf("%w: %s at %d lacks its operand", ErrInvalidContract, info.name, pc)
+		}
+		pc += info.immediate
+	}
+	return nil
+}
+
+// jumpDests returns the positions of the JUMPDEST instructions of code,
+// skipping operands.
+func jumpDests(code []byte) map[uint64]bool {
+	dests := make(map[uint64]bool)
+	for pc := 0; pc < len(code); pc++ {
+		op := Opcode(code[pc])
+		if op == OpJumpDest {
+			dests[uint64(pc)] = true
+		}
+		pc += opcodes[op].immediate
+	}
+	return dests
+}
+
+// Execute runs code with at most gasLimit gas. Storage is only read, the
+// writes of a successful call are returned for the caller to apply.
+func Execute(code []byte, ctx *CallContext, storage Storage, gasLimit uint64) *ExecResult {
+	res := &ExecResult{}
+	writes := make(map[uint64]uint64)
+	var logs []*Log
+	stack := make([]uint64, 0, 16)
+	dests := jumpDests(code)
+
+	fail := func(err error) *ExecResult {
+		res.Err = err
+		return res
+	}
+	pop := func() uint64 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	needs := func(n int) bool {
+		return len(stack) >= n
+	}
+
+	for pc := 0; pc < len(code); pc++ {
+		op := Opcode(code[pc])
+		info, ok := opcodes[op]
+		if !ok {
+			return fail(fmt.Errorf("%w 0x%02x at %d", ErrInvalidOpcode, code[pc], pc))
+		}
+		if res.GasUsed+info.gas > gasLimit {
+			res.GasUsed = gasLimit
+			return fail(ErrOutOfGas)
+		}
+		res.GasUsed += info.gas
+		if info.immediate > 0 && pc+info.immediate >= len(code) {
+			return fail(fmt.Errorf("%w: %s at %d lacks its operand", ErrInvalidOpcode, info.name, pc))
+		}
+
+		switch op {
+		case OpStop:
+			res.Writes, res.Logs = writes, logs
+			return res
+		case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpLt, OpGt, OpEq, OpAnd, OpOr, OpXor:
+			if !needs(2) {
+				return fail(ErrStackUnderflow)
+			}
+			a, b := pop(), pop()
+			stack = append(stack, binaryOp(op, a, b))
+		case OpIsZero, OpNot:
+			if !needs(1) {
+				return fail(ErrStackUnderflow)
+			}
+			a := pop()
+			if op == OpNot {
+				stack = append(stack, ^a)
+			} else {
+				stack = append(stack, boolWord(a == 0))
+			}
+		case OpSLoad:
+			if !needs(1) {
+				return fail(ErrStackUnderflow)
+			}
+			key := pop()
+			v, ok := writes[key]
+			if !ok {
+				v = storage.Load(ctx.Contract, key)
+			}
+			stack = append(stack, v)
+		case OpSStore:
+			if !needs(2) {
+				return fail(ErrStackUnderflow)
+			}
+			key, v := pop(), pop()
+			writes[key] = v
+		case OpCaller:
+			stack = append(stack, AddressWord(ctx.Caller))
+		case OpHeight:
+			stack = append(stack, uint64(ctx.Height))
+		case OpArg:
+			if !needs(1) {
+				return fail(ErrStackUnderflow)
+			}
+			i := pop()
+			var v uint64
+			if i < uint64(len(ctx.Args)) {
+				v = ctx.Args[i]
+			}
+			stack = append(stack, v)
+		case OpArgc:
+			stack = append(stack, uint64(len(ctx.Args)))
+		case OpPop:
+			if !needs(1) {
+				return fail(ErrStackUnderflow)
+			}
+			pop()
+		case OpDup, OpSwap:
+			n := int(code[pc+1])
+			pc++
+			if n < 1 || n > 16 {
+				return fail(fmt.Errorf("%w: %s %d", ErrInvalidOpcode, info.name, n))
+			}
+			need := n
+			if op == OpSwap {
+				need = n + 1
+			}
+			if !needs(need) {
+				return fail(ErrStackUnderflow)
+			}
+			top := len(stack) - 1
+			if op == OpDup {
+				stack = append(stack, stack[top-n+1])
+			} else {
+				stack[top], stack[top-n] = stack[top-n], stack[top]
+			}
+		case OpJump, OpJumpI:
+			n := 1
+			if op == OpJumpI {
+				n = 2
+			}
+			if !needs(n) {
+				return fail(ErrStackUnderflow)
+			}
+			dest := pop()
+			if op == OpJumpI && pop() == 0 {
+				continue
+			}
+			if !dests[dest] {
+				return fail(fmt.Errorf("%w: %d", ErrInvalidJump, dest))
+			}
+			// The loop increment steps over the JUMPDEST.
+			pc = int(dest)
+		case OpJumpDest:
+		case OpLog:
+			n := int(code[pc+1])
+			pc++
+			if n > MaxLogTopics {
+				return fail(fmt.Errorf("%w: %s %d", ErrInvalidOpcode, info.name, n))
+			}
+			if !needs(n + 1) {
+				return fail(ErrStackUnderflow)
+			}
+			l := &Log{Address: ctx.Contract, Data: pop(), Topics: make([]uint64, n)}
+			for i := range l.Topics {
+				l.Topics[i] = pop()
+			}
+			logs = append(logs, l)
+		case OpPush:
+			stack = append(stack, binary.BigEndian.Uint64(code[pc+1:pc+9]))
+			pc += 8
+		case OpReturn:
+			if !needs(1) {
+				return fail(ErrStackUnderflow)
+			}
+			res.Return = pop()
+			res.Writes, res.Logs = writes, logs
+			return res
+		case OpRevert:
+			return fail(ErrReverted)
+		}
+
+		if len(stack) > MaxStackDepth {
+			return fail(ErrStackOverflow)
+		}
+	}
+
+	res.Writes, res.Logs = writes, logs
+	return res
+}
+
+// binaryOp applies op to the top of the stack a and the word below it b.
+func binaryOp(op Opcode, a, b uint64) uint64 {
+	switch op {
+	case OpAdd:
+		return a + b
+	case OpSub:
+		return a - b
+	case OpMul:
+		return a * b
+	case OpDiv:
+		if b == 0 {
+			return 0
+		}
+		return a / b
+	case OpMod:
+		if b == 0 {
+			return 0
+		}
+		return a % b
+	case OpLt:
+		return boolWord(a < b)
+	case OpGt:
+		return boolWord(a > b)
+	case OpEq:
+		return boolWord(a == b)
+	case OpAnd:
+		return a & b
+	case OpOr:
+		return a | b
+	case OpXor:
+		return a ^ b
+	}
+	return 0
+}
+
+func boolWord(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
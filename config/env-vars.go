@@ -11,18 +11,33 @@ type EnvVars struct {
 }
 
 type Blockchain struct {
-	Difficulty         int           `envconfig:"CHAIN_MINING_DIFFICULTY" required:"true"`
-	MiningSender       string        `envconfig:"CHAIN_MINING_SENDER" default:"DENIZ"`
-	DefaultRewardToken string        `envconfig:"CHAIN_DEFAULT_REWARD_TOKEN" default:"DNZ"`
-	MiningReward       float64       `envconfig:"CHAIN_MINING_REWARD" required:"true"`
-	MiningTimerSeconds time.Duration `envconfig:"CHAIN_MINING_TIMER_SECONDS" required:"true"`
-	PortRangeStart     uint16        `envconfig:"CHAIN_BLOCKCHAIN_PORT_RANGE_START" required:"true"`
-	PortRangeEnd       uint16        `envconfig:"CHAIN_BLOCKCHAIN_PORT_RANGE_END" required:"true"`
-	IpRangeStart       uint8         `envconfig:"CHAIN_NODE_IP_RANGE_START" required:"true"`
-	IpRangeEnd         uint8         `envconfig:"CHAIN_NODE_IP_RANGE_END" required:"true"`
-	NodeSyncTimeSec    time.Duration `envconfig:"CHAIN_BLOCKCHAIN_NODE_SYNC_TIME_SEC" required:"true"`
-	BlockChainPort     uint16        `envconfig:"CHAIN_PORT" required:"true"`
-	DbSavePath         string        `envconfig:"CHAIN_DB_SAVE_PATH" required:"true"`
+	Difficulty             int           `envconfig:"CHAIN_MINING_DIFFICULTY" required:"true"`
+	MiningSender           string        `envconfig:"CHAIN_MINING_SENDER" default:"DENIZ"`
+	DefaultRewardToken     string        `envconfig:"CHAIN_DEFAULT_REWARD_TOKEN" default:"DNZ"`
+	MiningReward           float64       `envconfig:"CHAIN_MINING_REWARD" required:"true"`
+	HalvingInterval        int64         `envconfig:"CHAIN_HALVING_INTERVAL" default:"0"`
+	MinReward              float64       `envconfig:"CHAIN_MIN_REWARD" default:"0"`
+	SupplyCap              float64       `envconfig:"CHAIN_SUPPLY_CAP" default:"0"`
+	CoinbaseMaturity       int64         `envconfig:"CHAIN_COINBASE_MATURITY" default:"10"`
+	GasPrice               float64       `envconfig:"CHAIN_GAS_PRICE" default:"0.00000001"`
+	MiningTimerSeconds     time.Duration `envconfig:"CHAIN_MINING_TIMER_SECONDS" required:"true"`
+	PortRangeStart         uint16        `envconfig:"CHAIN_BLOCKCHAIN_PORT_RANGE_START" required:"true"`
+	PortRangeEnd           uint16        `envconfig:"CHAIN_BLOCKCHAIN_PORT_RANGE_END" required:"true"`
+	IpRangeStart           uint8         `envconfig:"CHAIN_NODE_IP_RANGE_START" required:"true"`
+	IpRangeEnd             uint8         `envconfig:"CHAIN_NODE_IP_RANGE_END" required:"true"`
+	NodeSyncTimeSec        time.Duration `envconfig:"CHAIN_BLOCKCHAIN_NODE_SYNC_TIME_SEC" required:"true"`
+	BlockChainPort         uint16        `envconfig:"CHAIN_PORT" required:"true"`
+	DbSavePath             string        `envconfig:"CHAIN_DB_SAVE_PATH" required:"true"`
+	MempoolMaxAge          time.Duration `envconfig:"CHAIN_MEMPOOL_MAX_AGE" default:"1h"`
+	MempoolJanitorInterval time.Duration `envconfig:"CHAIN_MEMPOOL_JANITOR_INTERVAL" default:"30s"`
+	MinerAddress           string        `envconfig:"CHAIN_MINER_ADDRESS"`
+	KeystorePath           string        `envconfig:"CHAIN_KEYSTORE_PATH"`
+	KeystorePassphrase     string        `envconfig:"CHAIN_KEYSTORE_PASSPHRASE"`
+	GenesisPath            string        `envconfig:"CHAIN_GENESIS_PATH"`
+	Network                string        `envconfig:"CHAIN_NETWORK" default:"devnet"`
+	SeedPeers              []string      `envconfig:"CHAIN_SEED_PEERS"`
+	PruneKeepBlocks        int64         `envconfig:"CHAIN_PRUNE_KEEP_BLOCKS" default:"0"`
+	SnapshotInterval       int64         `envconfig:"CHAIN_SNAPSHOT_INTERVAL" default:"100"`
 }
 
 func GetConfig() (*EnvVars, error) {
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"embed"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//go:embed genesis/*.json
+var genesisFiles embed.FS
+
+// Network bundles everything that differs between gochain networks. Address
+// version bytes are distinct per network so that an address of one network
+// is rejected by the nodes of another. Multisig and contract addresses carry
+// their own version bytes.
+type Network struct {
+	Name            string
+	ChainID         uint64
+	AddressVersion  byte
+	MultisigVersion byte
+	ContractVersion byte
+	DefaultPort     uint16
+	WalletPort      uint16
+	SeedPeers       []string
+	// GenesisFile names an embedded genesis file. When empty the genesis
+	// is built from the CHAIN_ environment variables.
+	GenesisFile string
+}
+
+var Networks = map[string]Network{
+	"devnet": {
+		Name:            "devnet",
+		ChainID:         1337,
+		AddressVersion:  0x1e,
+		MultisigVersion: 0x32,
+		ContractVersion: 0x3c,
+		DefaultPort:     3000,
+		WalletPort:      8080,
+	},
+	"testnet": {
+		Name:            "testnet",
+		ChainID:         2,
+		AddressVersion:  0x6f,
+		MultisigVersion: 0xc4,
+		ContractVersion: 0xc6,
+		DefaultPort:     4000,
+		WalletPort:      8180,
+		GenesisFile:     "genesis/testnet.json",
+	},
+	"mainnet": {
+		Name:            "mainnet",
+		ChainID:         1,
+		AddressVersion:  0x00,
+		MultisigVersion: 0x05,
+		ContractVersion: 0x1c,
+		DefaultPort:     5000,
+		WalletPort:      8280,
+		GenesisFile:     "genesis/mainnet.json",
+	},
+}
+
+func GetNetwork(name string) (Network, error) {
+	n, ok := Networks[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(Networks))
+		for k := range Networks {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return Network{}, fmt.Errorf("unknown network %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return n, nil
+}
+
+// Genesis returns the embedded genesis file of the network, or nil if the
+// network builds its genesis from the environment.
+func (n Network) Genesis() ([]byte, error) {
+	if n.GenesisFile == "" {
+		return nil, nil
+	}
+	return genesisFiles.ReadFile(n.GenesisFile)
+}
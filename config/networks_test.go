@@ -0,0 +1,45 @@
+package config
+
+import "testing"
+
+func TestGetNetwork(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"devnet", "devnet", false},
+		{"TestNet", "testnet", false},
+		{"mainnet", "mainnet", false},
+		{"regtest", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := GetNetwork(tt.name)
+			if (err != nil) != tt.wantErr || n.Name != tt.want {
+				t.Errorf("GetNetwork() = %q, %v, want %q", n.Name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworksAreDistinct(t *testing.T) {
+	versions := make(map[byte]string)
+	chainIDs := make(map[uint64]string)
+	for name, n := range Networks {
+		for _, v := range []byte{n.AddressVersion, n.MultisigVersion, n.ContractVersion} {
+			if other, ok := versions[v]; ok {
+				t.Errorf("%s and %s share the version byte 0x%02x", name, other, v)
+			}
+			versions[v] = name
+		}
+		if other, ok := chainIDs[n.ChainID]; ok {
+			t.Errorf("%s and %s share the chain ID %d", name, other, n.ChainID)
+		}
+		chainIDs[n.ChainID] = name
+		if m, err := n.Genesis(); err != nil || (n.GenesisFile != "" && len(m) == 0) {
+			t.Errorf("genesis of %s: %v", name, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ go 1.19
 require (
 	github.com/btcsuite/btcutil v1.0.2
 	github.com/dgraph-io/badger/v3 v3.2103.5
+	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/shopspring/decimal v1.3.1
 	golang.org/x/crypto v0.4.0
 )
@@ -22,7 +23,6 @@ require (
 	github.com/golang/protobuf v1.3.1 // indirect
 	github.com/golang/snappy v0.0.3 // indirect
 	github.com/google/flatbuffers v1.12.1 // indirect
-	github.com/kelseyhightower/envconfig v1.4.0 // indirect
 	github.com/klauspost/compress v1.12.3 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	go.opencensus.io v0.22.5 // indirect
@@ -27,7 +27,11 @@ func main() {
 
 	// fmt.Printf("signature: %s\n", t.GenerateSignature())
 
-	initialWallet := wallet.NewWallet()
+	network, err := config.GetNetwork(conf.Network)
+	if err != nil {
+		panic(err)
+	}
+	initialWallet := wallet.NewWalletWithVersion(network.AddressVersion)
 	// walletA := wallet.NewWallet()
 	// walletB := wallet.NewWallet()
 
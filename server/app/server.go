@@ -2,17 +2,23 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"main/block"
 	"main/config"
 	"main/utils"
 	"main/wallet"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 var cache map[string]*block.Blockchain = make(map[string]*block.Blockchain)
+var cacheMux sync.Mutex
 
 type BlockchainServer struct {
 	port uint16
@@ -28,21 +34,69 @@ func NewBlockchainServer(port uint16, conf config.Blockchain) *BlockchainServer
 
 func (bcs *BlockchainServer) Port() uint16 { return bcs.port }
 
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func (bcs *BlockchainServer) GetBlockchain() *block.Blockchain {
+	cacheMux.Lock()
+	defer cacheMux.Unlock()
+
 	bc, ok := cache["blockchain"]
 
 	if !ok {
-		minersWallet := wallet.NewWallet()
-		bc, _ = block.CreateBlockchain(minersWallet.BlockchainAddress(), *bcs.conf)
+		minerAddress, err := bcs.MinerAddress()
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		bc, err = block.CreateBlockchain(minerAddress, *bcs.conf)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
 		cache["blockchain"] = bc
-		log.Printf("private key: %v", minersWallet.PrivateKeyStr())
-		log.Printf("public key: %v", minersWallet.PublicKeyStr())
-		log.Printf("blockchain address: %v", minersWallet.BlockchainAddress())
+		if minerAddress == "" {
+			log.Println("WARNING: no miner reward address configured, mining is disabled")
+		} else {
+			log.Printf("miner reward address: %v", minerAddress)
+		}
 	}
 
 	return bc
 }
 
+// MinerAddress resolves where mining rewards are paid: CHAIN_MINER_ADDRESS if
+// set, otherwise the address of the node key kept in CHAIN_KEYSTORE_PATH,
+// which is generated and saved on first start.
+func (bcs *BlockchainServer) MinerAddress() (string, error) {
+	if bcs.conf.MinerAddress != "" {
+		return bcs.conf.MinerAddress, nil
+	}
+
+	if bcs.conf.KeystorePath == "" {
+		return "", nil
+	}
+
+	network, err := config.GetNetwork(bcs.conf.Network)
+	if err != nil {
+		return "", err
+	}
+
+	minersWallet, created, err := wallet.LoadOrCreateKeystore(bcs.conf.KeystorePath, bcs.conf.KeystorePassphrase, network.AddressVersion)
+	if err != nil {
+		return "", err
+	}
+	if created {
+		log.Printf("created miner keystore %s", bcs.conf.KeystorePath)
+	}
+	log.Printf("public key: %v", minersWallet.PublicKeyStr())
+	return minersWallet.BlockchainAddress(), nil
+}
+
 func (bcs *BlockchainServer) GetChain(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -81,24 +135,22 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 			return
 		}
 
-		if !t.Validate() {
-			log.Println("ERROR: missing field(s)")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+		if err := t.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
 			return
 		}
 
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		signature := utils.SignatureFromString(*t.Signature)
-
 		bc := bcs.GetBlockchain()
 
-		isCreated := bc.Createransaction(*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, block.Token{TokenName: *t.TokenName, TokenValue: *t.TokenValue}, publicKey, signature)
+		err = bc.Createransaction(t.Transaction())
 
 		w.Header().Add("Content-Type", "applications/json")
 		var m []byte
-		if !isCreated {
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			m = utils.JsonStatus("failed")
+			m = utils.JsonError(err)
 		} else {
 			w.WriteHeader(http.StatusCreated)
 			m = utils.JsonStatus("success")
@@ -117,24 +169,22 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 			return
 		}
 
-		if !t.Validate() {
-			log.Println("ERROR: missing field(s)")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+		if err := t.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
 			return
 		}
 
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		signature := utils.SignatureFromString(*t.Signature)
-
 		bc := bcs.GetBlockchain()
 
-		isUpdated := bc.AddTransaction(*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, block.Token{TokenName: *t.TokenName, TokenValue: *t.TokenValue}, publicKey, signature)
+		err = bc.AddTransaction(t.Transaction())
 
 		w.Header().Add("Content-Type", "applications/json")
 		var m []byte
-		if !isUpdated {
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			m = utils.JsonStatus("failed")
+			m = utils.JsonError(err)
 		} else {
 			m = utils.JsonStatus("success")
 		}
@@ -142,6 +192,14 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 		io.WriteString(w, string(m))
 
 	case http.MethodDelete:
+		// Peers maintain their pools from the blocks they accept. Clearing
+		// the pool by hand is left to the operator on this machine.
+		if !isLoopback(req.RemoteAddr) {
+			log.Printf("ERROR: refusing to clear transaction pool for %s", req.RemoteAddr)
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, string(utils.JsonStatus("forbidden")))
+			return
+		}
 		bc := bcs.GetBlockchain()
 		bc.ClearTransactionPool()
 		io.WriteString(w, string(utils.JsonStatus("success")))
@@ -151,6 +209,83 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 	}
 }
 
+func (bcs *BlockchainServer) GetGenesis(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		bc := bcs.GetBlockchain()
+		m, _ := json.Marshal(struct {
+			Network      string         `json:"network"`
+			ChainID      uint64         `json:"chain_id"`
+			Genesis      *block.Genesis `json:"genesis"`
+			Hash         string         `json:"hash"`
+			PrunedHeight int64          `json:"pruned_height"`
+		}{
+			Network:      bc.Network().Name,
+			ChainID:      bc.Network().ChainID,
+			Genesis:      bc.Genesis(),
+			Hash:         fmt.Sprintf("%x", bc.GenesisHash()),
+			PrunedHeight: bc.PrunedHeight(),
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bcs *BlockchainServer) EvictedTransactions(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		evictions := bcs.GetBlockchain().Evictions()
+		m, _ := json.Marshal(struct {
+			Evictions []*block.Eviction `json:"evictions"`
+			Length    int               `json:"length"`
+		}{
+			Evictions: evictions,
+			Length:    len(evictions),
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// Events streams node events to the client as server-sent events.
+func (bcs *BlockchainServer) Events(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "text/event-stream")
+		w.Header().Add("Cache-Control", "no-cache")
+		flusher.Flush()
+
+		events, cancel := bcs.GetBlockchain().Subscribe()
+		defer cancel()
+
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case e := <-events:
+				m, _ := json.Marshal(e)
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, m)
+				flusher.Flush()
+			}
+		}
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
 func (bcs *BlockchainServer) Mine(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -246,6 +381,443 @@ func (bcs *BlockchainServer) GetTokenBalances(w http.ResponseWriter, req *http.R
 
 }
 
+func (bcs *BlockchainServer) GetSupply(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(struct {
+			Supply []*block.Supply `json:"supply"`
+		}{
+			Supply: bcs.GetBlockchain().Supply(),
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetNonce returns the next nonce the address can sign a transaction with.
+func (bcs *BlockchainServer) GetNonce(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(struct {
+			BlockchainAddress string `json:"blockchain_address"`
+			Nonce             uint64 `json:"nonce"`
+		}{
+			BlockchainAddress: blockchainAddress,
+			Nonce:             bcs.GetBlockchain().NextNonce(blockchainAddress),
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bcs *BlockchainServer) GetTokens(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(struct {
+			Tokens []*block.TokenInfo `json:"tokens"`
+		}{
+			Tokens: bcs.GetBlockchain().Tokens(),
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bcs *BlockchainServer) GetToken(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		symbol := strings.TrimPrefix(req.URL.Path, "/tokens/")
+		info, ok := bcs.GetBlockchain().Token(symbol)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonError(block.ErrUnknownToken)))
+			return
+		}
+		m, _ := json.Marshal(info)
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetHTLCs lists the contracts an address locked or can claim.
+func (bcs *BlockchainServer) GetHTLCs(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		m, _ := json.Marshal(struct {
+			HTLCs []*block.HTLCInfo `json:"htlcs"`
+		}{
+			HTLCs: bcs.GetBlockchain().HTLCs(blockchainAddress),
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetHTLC returns the state of the contract created by the htlc_lock
+// transaction with the given ID.
+func (bcs *BlockchainServer) GetHTLC(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		id := strings.TrimPrefix(req.URL.Path, "/htlc/")
+		info, ok := bcs.GetBlockchain().HTLC(id)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonError(block.ErrUnknownHTLC)))
+			return
+		}
+		m, _ := json.Marshal(info)
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetEscrows lists the escrows an address is a party of.
+func (bcs *BlockchainServer) GetEscrows(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		m, _ := json.Marshal(struct {
+			Escrows []*block.EscrowInfo `json:"escrows"`
+		}{
+			Escrows: bcs.GetBlockchain().Escrows(blockchainAddress),
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetEscrow returns the state of the escrow created by the escrow_lock
+// transaction with the given ID.
+func (bcs *BlockchainServer) GetEscrow(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		id := strings.TrimPrefix(req.URL.Path, "/escrow/")
+		info, ok := bcs.GetBlockchain().Escrow(id)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonError(block.ErrUnknownEscrow)))
+			return
+		}
+		m, _ := json.Marshal(info)
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetContracts lists the contracts an address deployed.
+func (bcs *BlockchainServer) GetContracts(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		m, _ := json.Marshal(struct {
+			Contracts []*block.ContractInfo `json:"contracts"`
+		}{
+			Contracts: bcs.GetBlockchain().Contracts(blockchainAddress),
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetContract returns the code and the storage of a contract.
+func (bcs *BlockchainServer) GetContract(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		address := strings.TrimPrefix(req.URL.Path, "/contracts/")
+		info, ok := bcs.GetBlockchain().Contract(address)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonError(block.ErrUnknownContract)))
+			return
+		}
+		m, _ := json.Marshal(info)
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetReceipt serves /tx/{id}/receipt, the receipt of a mined transaction.
+func (bcs *BlockchainServer) GetReceipt(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		id := strings.TrimPrefix(req.URL.Path, "/tx/")
+		if !strings.HasSuffix(id, "/receipt") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		receipt, err := bcs.GetBlockchain().Receipt(strings.TrimSuffix(id, "/receipt"))
+		if errors.Is(err, block.ErrPruned) {
+			w.WriteHeader(http.StatusGone)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		m, _ := json.Marshal(receipt)
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetLogs returns the contract logs matching the address, topic, from_height
+// and to_height query parameters, all of which are optional.
+func (bcs *BlockchainServer) GetLogs(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		query := req.URL.Query()
+		filter := block.LogFilter{Address: query.Get("address")}
+		var errs utils.ValidationErrors
+		if s := query.Get("topic"); s != "" {
+			topic, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				errs.Add("topic", "must be an unsigned integer")
+			}
+			filter.Topic = &topic
+		}
+		heights := []struct {
+			name   string
+			height *int64
+		}{{"from_height", &filter.FromHeight}, {"to_height", &filter.ToHeight}}
+		for _, p := range heights {
+			if s := query.Get(p.name); s != "" {
+				h, err := strconv.ParseInt(s, 10, 64)
+				if err != nil || h < 0 {
+					errs.Add(p.name, "must be a non-negative integer")
+				}
+				*p.height = h
+			}
+		}
+		if err := errs.Err(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		logs, err := bcs.GetBlockchain().Logs(filter)
+		if errors.Is(err, block.ErrPruned) {
+			w.WriteHeader(http.StatusGone)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		m, _ := json.Marshal(struct {
+			Logs []*block.Log `json:"logs"`
+		}{
+			Logs: logs,
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetBalanceProof returns the balance of blockchain_address in token_name
+// at the tip with its proof against the state root of the tip.
+func (bcs *BlockchainServer) GetBalanceProof(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		tokenName := req.URL.Query().Get("token_name")
+		var errs utils.ValidationErrors
+		if blockchainAddress == "" {
+			errs.Add("blockchain_address", "is required")
+		}
+		if tokenName == "" {
+			errs.Add("token_name", "is required")
+		}
+		if err := errs.Err(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		proof, err := bcs.GetBlockchain().ProveBalance(blockchainAddress, tokenName)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		m, _ := json.Marshal(proof)
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetTransactionProof serves /proof/tx/{id}, the proof that a mined
+// transaction is included in its block.
+func (bcs *BlockchainServer) GetTransactionProof(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		id := strings.TrimPrefix(req.URL.Path, "/proof/tx/")
+		proof, ok := bcs.GetBlockchain().ProveTransaction(id)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonError(block.ErrNotMined)))
+			return
+		}
+		m, _ := json.Marshal(proof)
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetHeaders returns up to block.MaxHeaders block headers from the
+// from_height query parameter on, for light clients.
+func (bcs *BlockchainServer) GetHeaders(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		var from int64
+		if s := req.URL.Query().Get("from_height"); s != "" {
+			h, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || h < 0 {
+				var errs utils.ValidationErrors
+				errs.Add("from_height", "must be a non-negative integer")
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonError(errs.Err())))
+				return
+			}
+			from = h
+		}
+		m, _ := json.Marshal(struct {
+			Headers []*block.BlockHeader `json:"headers"`
+		}{
+			Headers: bcs.GetBlockchain().Headers(from),
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetFilters returns the compact filters of the blocks from from_height to
+// to_height, up to block.MaxFilters of them.
+func (bcs *BlockchainServer) GetFilters(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		query := req.URL.Query()
+		var from, to int64
+		var errs utils.ValidationErrors
+		heights := []struct {
+			name   string
+			height *int64
+		}{{"from_height", &from}, {"to_height", &to}}
+		for _, p := range heights {
+			if s := query.Get(p.name); s != "" {
+				h, err := strconv.ParseInt(s, 10, 64)
+				if err != nil || h < 0 {
+					errs.Add(p.name, "must be a non-negative integer")
+				}
+				*p.height = h
+			}
+		}
+		if err := errs.Err(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		filters, err := bcs.GetBlockchain().Filters(from, to)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		m, _ := json.Marshal(struct {
+			Filters []*block.BlockFilter `json:"filters"`
+		}{
+			Filters: filters,
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// GetBlock serves /blocks/{height}, a single block of the chain.
+func (bcs *BlockchainServer) GetBlock(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		height, err := strconv.ParseInt(strings.TrimPrefix(req.URL.Path, "/blocks/"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, ok := bcs.GetBlockchain().Block(height)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if b.Pruned() {
+			w.WriteHeader(http.StatusGone)
+			io.WriteString(w, string(utils.JsonError(block.ErrPruned)))
+			return
+		}
+		m, _ := json.Marshal(b)
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
 func (bcs *BlockchainServer) Consensus(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPut:
@@ -269,11 +841,31 @@ func (bcs *BlockchainServer) Consensus(w http.ResponseWriter, req *http.Request)
 func (bcs *BlockchainServer) Connect() {
 	bcs.GetBlockchain().Run() //sync and start the nodes
 	http.HandleFunc("/", bcs.GetChain)
+	http.HandleFunc("/genesis", bcs.GetGenesis)
 	http.HandleFunc("/transactions", bcs.Transactions)
+	http.HandleFunc("/transactions/evicted", bcs.EvictedTransactions)
+	http.HandleFunc("/events", bcs.Events)
 	http.HandleFunc("/mine", bcs.Mine)
 	http.HandleFunc("/mine/start", bcs.StartMine)
 	http.HandleFunc("/balance", bcs.GetTokenBalance)
 	http.HandleFunc("/balance_all", bcs.GetTokenBalances)
+	http.HandleFunc("/supply", bcs.GetSupply)
+	http.HandleFunc("/nonce", bcs.GetNonce)
+	http.HandleFunc("/tokens", bcs.GetTokens)
+	http.HandleFunc("/tokens/", bcs.GetToken)
+	http.HandleFunc("/htlc", bcs.GetHTLCs)
+	http.HandleFunc("/htlc/", bcs.GetHTLC)
+	http.HandleFunc("/escrow", bcs.GetEscrows)
+	http.HandleFunc("/escrow/", bcs.GetEscrow)
+	http.HandleFunc("/contracts", bcs.GetContracts)
+	http.HandleFunc("/contracts/", bcs.GetContract)
+	http.HandleFunc("/tx/", bcs.GetReceipt)
+	http.HandleFunc("/logs", bcs.GetLogs)
+	http.HandleFunc("/proof/balance", bcs.GetBalanceProof)
+	http.HandleFunc("/proof/tx/", bcs.GetTransactionProof)
+	http.HandleFunc("/headers", bcs.GetHeaders)
+	http.HandleFunc("/filters", bcs.GetFilters)
+	http.HandleFunc("/blocks/", bcs.GetBlock)
 	http.HandleFunc("/consensus", bcs.Consensus)
 	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(bcs.port)), nil))
 }
@@ -16,8 +16,17 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	port := flag.Uint("port", 3000, "TCP port number for Blockchain server")
+	port := flag.Uint("port", 0, "TCP port number for Blockchain server (defaults to the network's port)")
+	network := flag.String("network", conf.Network, "Network profile: devnet, testnet or mainnet")
 	flag.Parse()
+	n, err := config.GetNetwork(*network)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf.Network = n.Name
+	if *port == 0 {
+		*port = uint(n.DefaultPort)
+	}
 	app := app.NewBlockchainServer(uint16(*port), conf.Blockchain)
 	app.Connect()
 }
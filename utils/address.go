@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/ripemd160"
+)
+
+var ErrInvalidAddress = errors.New("invalid blockchain address")
+
+// AddressFromPublicKey derives the base58check blockchain address of a public
+// key for the given network version byte.
+func AddressFromPublicKey(publicKey *ecdsa.PublicKey, version byte) string {
+	// 2. Perform SHA-256 hashing on the public key (32 bytes).
+	h2 := sha256.New()
+	h2.Write(publicKey.X.Bytes())
+	h2.Write(publicKey.Y.Bytes())
+	digest2 := h2.Sum(nil)
+	// 3. Perform RIPEMD-160 hashing on the result of SHA-256 (20 bytes).
+	h3 := ripemd160.New()
+	h3.Write(digest2)
+	digest3 := h3.Sum(nil)
+	return EncodeAddress(digest3, version)
+}
+
+// MultisigAddress derives the address of an account controlled by threshold
+// of the given public keys. The order of the keys is part of the address.
+func MultisigAddress(threshold uint8, publicKeys []*ecdsa.PublicKey, version byte) string {
+	h := sha256.New()
+	h.Write([]byte{threshold})
+	for _, publicKey := range publicKeys {
+		fmt.Fprintf(h, "%064x%064x", publicKey.X.Bytes(), publicKey.Y.Bytes())
+	}
+	h3 := ripemd160.New()
+	h3.Write(h.Sum(nil))
+	return EncodeAddress(h3.Sum(nil), version)
+}
+
+// ContractAddress derives the address of the contract deployed by the
+// transaction with the given ID.
+func ContractAddress(transactionID string, version byte) string {
+	h := sha256.Sum256([]byte(transactionID))
+	h3 := ripemd160.New()
+	h3.Write(h[:])
+	return EncodeAddress(h3.Sum(nil), version)
+}
+
+// EncodeAddress adds the version byte and checksum to a 20 byte hash and
+// encodes the result in base58.
+func EncodeAddress(hash []byte, version byte) string {
+	// 4. Add version byte in front of RIPEMD-160 hash.
+	vd4 := make([]byte, 21)
+	vd4[0] = version
+	copy(vd4[1:], hash[:])
+	// 5. Perform SHA-256 hash on the extended RIPEMD-160 result.
+	h5 := sha256.New()
+	h5.Write(vd4)
+	digest5 := h5.Sum(nil)
+	// 6. Perform SHA-256 hash on the result of the previous SHA-256 hash.
+	h6 := sha256.New()
+	h6.Write(digest5)
+	digest6 := h6.Sum(nil)
+	// 7. Take the first 4 bytes of the second SHA-256 hash for checksum.
+	chsum := digest6[:4]
+	// 8. Add the 4 checksum bytes from 7 at the end of extended RIPEMD-160 hash from 4 (25 bytes).
+	dc8 := make([]byte, 25)
+	copy(dc8[:21], vd4[:])
+	copy(dc8[21:], chsum[:])
+	// 9. Convert the result from a byte string into base58.
+	return base58.Encode(dc8)
+}
+
+// DecodeAddress checks the checksum of address and returns its version byte
+// and 20 byte hash.
+func DecodeAddress(address string) (byte, []byte, error) {
+	b := base58.Decode(address)
+	if len(b) != 25 {
+		return 0, nil, ErrInvalidAddress
+	}
+	digest := sha256.Sum256(b[:21])
+	digest = sha256.Sum256(digest[:])
+	if !bytes.Equal(digest[:4], b[21:]) {
+		return 0, nil, ErrInvalidAddress
+	}
+	return b[0], b[1:21], nil
+}
+
+// ValidateAddress reports an error if address is malformed or does not carry
+// one of the given version bytes, i.e. belongs to another network.
+func ValidateAddress(address string, versions ...byte) error {
+	v, _, err := DecodeAddress(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, address)
+	}
+	expected := make([]string, len(versions))
+	for i, version := range versions {
+		if v == version {
+			return nil
+		}
+		expected[i] = fmt.Sprintf("0x%02x", version)
+	}
+	return fmt.Errorf("%w: %s is for another network (version 0x%02x, expected %s)",
+		ErrInvalidAddress, address, v, strings.Join(expected, " or "))
+}
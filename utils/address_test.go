@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestValidateAddress(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devnet := AddressFromPublicKey(&privateKey.PublicKey, 0x1e)
+	mainnet := AddressFromPublicKey(&privateKey.PublicKey, 0x00)
+	multisig := MultisigAddress(1, []*ecdsa.PublicKey{&privateKey.PublicKey}, 0x32)
+	corrupted := []byte(devnet)
+	if corrupted[5] == 'a' {
+		corrupted[5] = 'b'
+	} else {
+		corrupted[5] = 'a'
+	}
+
+	tests := []struct {
+		name     string
+		address  string
+		versions []byte
+		wantErr  bool
+	}{
+		{"own network", devnet, []byte{0x1e}, false},
+		{"other network", mainnet, []byte{0x1e}, true},
+		{"multisig accepted", multisig, []byte{0x1e, 0x32}, false},
+		{"multisig not accepted", multisig, []byte{0x1e}, true},
+		{"checksum", string(corrupted), []byte{0x1e}, true},
+		{"too short", devnet[:10], []byte{0x1e}, true},
+		{"empty", "", []byte{0x1e}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAddress(tt.address, tt.versions...)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidAddress)) {
+				t.Errorf("ValidateAddress() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"math/big"
@@ -46,3 +47,23 @@ func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) *ecdsa.PrivateKe
 	_ = bi.SetBytes(b)
 	return &ecdsa.PrivateKey{PublicKey: *publicKey, D: &bi}
 }
+
+// halfOrder is half the order of P256, the highest S of a canonical
+// signature.
+var halfOrder = new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+
+// IsLowS reports whether s is in its canonical form. For every signature
+// (R, S) the signature (R, N-S) is valid as well, so only the one with the
+// lower S is accepted.
+func (s *Signature) IsLowS() bool {
+	return s.S.Cmp(halfOrder) <= 0
+}
+
+// Sign signs hash with privateKey and returns the signature with a low S.
+func Sign(privateKey *ecdsa.PrivateKey, hash []byte) *Signature {
+	r, s, _ := ecdsa.Sign(rand.Reader, privateKey, hash)
+	if s.Cmp(halfOrder) > 0 {
+		s.Sub(privateKey.Curve.Params().N, s)
+	}
+	return &Signature{R: r, S: s}
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+)
+
+func TestSignIsLowS(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := elliptic.P256().Params().N
+	for i := 0; i < 50; i++ {
+		h := sha256.Sum256([]byte{byte(i)})
+		s := Sign(privateKey, h[:])
+		if !s.IsLowS() {
+			t.Fatalf("Sign() returned a high S")
+		}
+		if !ecdsa.Verify(&privateKey.PublicKey, h[:], s.R, s.S) {
+			t.Fatalf("Sign() returned an invalid signature")
+		}
+		high := &Signature{R: s.R, S: new(big.Int).Sub(n, s.S)}
+		if high.IsLowS() {
+			t.Fatalf("IsLowS() accepted N-S")
+		}
+		if !ecdsa.Verify(&privateKey.PublicKey, h[:], high.R, high.S) {
+			t.Fatalf("N-S is not a valid signature")
+		}
+	}
+}
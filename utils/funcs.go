@@ -1,12 +1,11 @@
 package utils
 
 import (
-	"fmt"
-
 	"github.com/shopspring/decimal"
 )
 
+// FloatToDecimal converts value using the shortest decimal representation
+// that reads back as the same float, so 0.1 becomes exactly 0.1.
 func FloatToDecimal(value float64) decimal.Decimal {
-	d, _ := decimal.NewFromString(fmt.Sprintf("%f", value))
-	return d
+	return decimal.NewFromFloat(value)
 }
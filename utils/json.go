@@ -10,3 +10,19 @@ func JsonStatus(message string) []byte {
 	})
 	return m
 }
+
+// JsonError is the failure status with the reason attached. Validation
+// errors are listed per field as well.
+func JsonError(err error) []byte {
+	ve, _ := err.(ValidationErrors)
+	m, _ := json.Marshal(struct {
+		Message string           `json:"message"`
+		Error   string           `json:"error"`
+		Errors  ValidationErrors `json:"errors,omitempty"`
+	}{
+		Message: "failed",
+		Error:   err.Error(),
+		Errors:  ve,
+	})
+	return m
+}
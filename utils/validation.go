@@ -0,0 +1,32 @@
+package utils
+
+import "strings"
+
+// FieldError describes why a single request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors collects the field errors of a request.
+type ValidationErrors []FieldError
+
+func (ve *ValidationErrors) Add(field string, message string) {
+	*ve = append(*ve, FieldError{Field: field, Message: message})
+}
+
+// Err returns nil when no field was rejected.
+func (ve ValidationErrors) Err() error {
+	if len(ve) == 0 {
+		return nil
+	}
+	return ve
+}
+
+func (ve ValidationErrors) Error() string {
+	messages := make([]string, len(ve))
+	for i, fe := range ve {
+		messages[i] = fe.Field + ": " + fe.Message
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
@@ -0,0 +1,163 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"main/utils"
+	"math/big"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+var ErrWrongPassphrase = errors.New("keystore: wrong passphrase")
+
+// keystoreFile is the on-disk form of a wallet. The private key is encrypted
+// with AES-256-GCM under a key derived from the passphrase with scrypt.
+type keystoreFile struct {
+	BlockchainAddress string `json:"blockchain_address"`
+	PublicKey         string `json:"public_key"`
+	Crypto            struct {
+		KDF        string `json:"kdf"`
+		N          int    `json:"n"`
+		R          int    `json:"r"`
+		P          int    `json:"p"`
+		Salt       string `json:"salt"`
+		Nonce      string `json:"nonce"`
+		Ciphertext string `json:"ciphertext"`
+	} `json:"crypto"`
+}
+
+func keystoreCipher(passphrase string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SaveKeystore writes w to path, encrypted with passphrase.
+func SaveKeystore(w *Wallet, path string, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("keystore: a passphrase is required")
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := keystoreCipher(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	ks := keystoreFile{BlockchainAddress: w.BlockchainAddress(), PublicKey: w.PublicKeyStr()}
+	ks.Crypto.KDF = "scrypt"
+	ks.Crypto.N, ks.Crypto.R, ks.Crypto.P = scryptN, scryptR, scryptP
+	ks.Crypto.Salt = hex.EncodeToString(salt)
+	ks.Crypto.Nonce = hex.EncodeToString(nonce)
+	ks.Crypto.Ciphertext = hex.EncodeToString(aead.Seal(nil, nonce, w.privateKey.D.FillBytes(make([]byte, 32)), nil))
+
+	m, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, m, 0600)
+}
+
+// LoadKeystore decrypts the wallet stored at path.
+func LoadKeystore(path string, passphrase string) (*Wallet, error) {
+	m, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ks keystoreFile
+	if err := json.Unmarshal(m, &ks); err != nil {
+		return nil, fmt.Errorf("keystore: %v", err)
+	}
+	if ks.Crypto.KDF != "scrypt" {
+		return nil, fmt.Errorf("keystore: unsupported kdf %q", ks.Crypto.KDF)
+	}
+
+	salt, err := hex.DecodeString(ks.Crypto.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("keystore: %v", err)
+	}
+	nonce, err := hex.DecodeString(ks.Crypto.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("keystore: %v", err)
+	}
+	ciphertext, err := hex.DecodeString(ks.Crypto.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("keystore: %v", err)
+	}
+
+	aead, err := keystoreCipher(passphrase, salt, ks.Crypto.N, ks.Crypto.R, ks.Crypto.P)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("keystore: invalid nonce")
+	}
+	d, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	privateKey := utils.PrivateKeyFromString(hex.EncodeToString(d), utils.PublicKeyFromString(ks.PublicKey))
+	x, y := elliptic.P256().ScalarBaseMult(d)
+	if x.Cmp(privateKey.X) != 0 || y.Cmp(privateKey.Y) != 0 || new(big.Int).SetBytes(d).Sign() == 0 {
+		return nil, errors.New("keystore: private key does not match public key")
+	}
+
+	version, _, err := utils.DecodeAddress(ks.BlockchainAddress)
+	if err != nil {
+		return nil, fmt.Errorf("keystore: %v", err)
+	}
+	w := NewWalletFromPrivateKey(privateKey, version)
+	if w.BlockchainAddress() != ks.BlockchainAddress {
+		return nil, errors.New("keystore: address does not match key")
+	}
+	return w, nil
+}
+
+// LoadOrCreateKeystore loads the wallet at path, creating and saving a new
+// one with the given address version on first use. The second return value
+// reports whether it was created.
+func LoadOrCreateKeystore(path string, passphrase string, version byte) (*Wallet, bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		w, err := LoadKeystore(path, passphrase)
+		return w, false, err
+	} else if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	w := NewWalletWithVersion(version)
+	if err := SaveKeystore(w, path, passphrase); err != nil {
+		return nil, false, err
+	}
+	return w, true, nil
+}
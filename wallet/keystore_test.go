@@ -0,0 +1,75 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadOrCreateKeystore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "miner.json")
+	created, ok, err := LoadOrCreateKeystore(path, "secret", 0x1e)
+	if err != nil || !ok {
+		t.Fatalf("LoadOrCreateKeystore() = %v, %v, want a new wallet", ok, err)
+	}
+	loaded, ok, err := LoadOrCreateKeystore(path, "secret", 0x1e)
+	if err != nil || ok {
+		t.Fatalf("LoadOrCreateKeystore() = %v, %v, want the saved wallet", ok, err)
+	}
+	if loaded.BlockchainAddress() != created.BlockchainAddress() || loaded.PrivateKeyStr() != created.PrivateKeyStr() {
+		t.Errorf("loaded %s, want %s", loaded.BlockchainAddress(), created.BlockchainAddress())
+	}
+}
+
+func TestLoadKeystore(t *testing.T) {
+	w := NewWalletWithVersion(0x1e)
+	other := NewWalletWithVersion(0x1e)
+	tests := []struct {
+		name       string
+		passphrase string
+		edit       func(ks *keystoreFile)
+		wantErr    bool
+		is         error
+	}{
+		{"valid", "secret", nil, false, nil},
+		{"wrong passphrase", "guess", nil, true, ErrWrongPassphrase},
+		{"tampered ciphertext", "secret", func(ks *keystoreFile) {
+			ks.Crypto.Ciphertext = "00" + ks.Crypto.Ciphertext[2:]
+		}, true, ErrWrongPassphrase},
+		{"other public key", "secret", func(ks *keystoreFile) { ks.PublicKey = other.PublicKeyStr() }, true, nil},
+		{"other address", "secret", func(ks *keystoreFile) { ks.BlockchainAddress = other.BlockchainAddress() }, true, nil},
+		{"unsupported kdf", "secret", func(ks *keystoreFile) { ks.Crypto.KDF = "pbkdf2" }, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "wallet.json")
+			if err := SaveKeystore(w, path, "secret"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.edit != nil {
+				var ks keystoreFile
+				m, _ := ioutil.ReadFile(path)
+				json.Unmarshal(m, &ks)
+				tt.edit(&ks)
+				m, _ = json.Marshal(ks)
+				ioutil.WriteFile(path, m, 0600)
+			}
+
+			loaded, err := LoadKeystore(path, tt.passphrase)
+			if (err != nil) != tt.wantErr || (tt.is != nil && !errors.Is(err, tt.is)) {
+				t.Fatalf("LoadKeystore() = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && loaded.BlockchainAddress() != w.BlockchainAddress() {
+				t.Errorf("loaded %s, want %s", loaded.BlockchainAddress(), w.BlockchainAddress())
+			}
+		})
+	}
+}
+
+func TestSaveKeystoreNeedsPassphrase(t *testing.T) {
+	if err := SaveKeystore(NewWallet(), filepath.Join(t.TempDir(), "wallet.json"), ""); err == nil {
+		t.Error("SaveKeystore() without a passphrase succeeded")
+	}
+}
@@ -4,16 +4,12 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"log"
 	"main/block"
 	"main/utils"
 
-	"github.com/btcsuite/btcutil/base58"
 	"github.com/shopspring/decimal"
-	"golang.org/x/crypto/ripemd160"
 )
 
 type Wallet struct {
@@ -34,42 +30,24 @@ func (w *Wallet) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// NewWallet creates a wallet with a main network (0x00) address.
 func NewWallet() *Wallet {
+	return NewWalletWithVersion(0x00)
+}
+
+// NewWalletWithVersion creates a wallet whose address carries the given
+// network version byte.
+func NewWalletWithVersion(version byte) *Wallet {
 	// 1. Creating ECDSA private key (32 bytes) public key (64 bytes)
-	w := new(Wallet)
 	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return NewWalletFromPrivateKey(privateKey, version)
+}
+
+func NewWalletFromPrivateKey(privateKey *ecdsa.PrivateKey, version byte) *Wallet {
+	w := new(Wallet)
 	w.privateKey = privateKey
 	w.publicKey = &w.privateKey.PublicKey
-	// 2. Perform SHA-256 hashing on the public key (32 bytes).
-	h2 := sha256.New()
-	h2.Write(w.publicKey.X.Bytes())
-	h2.Write(w.publicKey.Y.Bytes())
-	digest2 := h2.Sum(nil)
-	// 3. Perform RIPEMD-160 hashing on the result of SHA-256 (20 bytes).
-	h3 := ripemd160.New()
-	h3.Write(digest2)
-	digest3 := h3.Sum(nil)
-	// 4. Add version byte in front of RIPEMD-160 hash (0x00 for Main Network).
-	vd4 := make([]byte, 21)
-	vd4[0] = 0x00
-	copy(vd4[1:], digest3[:])
-	// 5. Perform SHA-256 hash on the extended RIPEMD-160 result.
-	h5 := sha256.New()
-	h5.Write(vd4)
-	digest5 := h5.Sum(nil)
-	// 6. Perform SHA-256 hash on the result of the previous SHA-256 hash.
-	h6 := sha256.New()
-	h6.Write(digest5)
-	digest6 := h6.Sum(nil)
-	// 7. Take the first 4 bytes of the second SHA-256 hash for checksum.
-	chsum := digest6[:4]
-	// 8. Add the 4 checksum bytes from 7 at the end of extended RIPEMD-160 hash from 4 (25 bytes).
-	dc8 := make([]byte, 25)
-	copy(dc8[:21], vd4[:])
-	copy(dc8[21:], chsum[:])
-	// 9. Convert the result from a byte string into base58.
-	address := base58.Encode(dc8)
-	w.blockchainAddress = address
+	w.blockchainAddress = utils.AddressFromPublicKey(w.publicKey, version)
 	return w
 }
 
@@ -99,50 +77,355 @@ type Transaction struct {
 	senderBlockchainAddress    string
 	recipientBlockchainAddress string
 	token                      block.Token
+	decimals                   uint8
+	txType                     string
+	issue                      *block.TokenIssue
+	outputs                    []*block.Output
+	swap                       *block.Swap
+	htlc                       *block.HTLC
+	settle                     *block.HTLCSettle
+	escrow                     *block.Escrow
+	escrowID                   string
+	deploy                     *block.ContractDeploy
+	call                       *block.ContractCall
+	nonce                      uint64
+	chainID                    uint64
+	expiryHeight               int64
+	expiresAt                  int64
+	lockHeight                 int64
+	lockedUntil                int64
 }
 
-func NewTransaction(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey,
+// NewTransaction prepares a transfer for the chain with the given ID. The
+// chain ID is part of the signature, so the transaction is only valid there.
+func NewTransaction(chainID uint64, privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey,
 	sender string, recipient string, token block.Token) *Transaction {
-	return &Transaction{privateKey, publicKey, sender, recipient, token}
+	return &Transaction{
+		chainID:                    chainID,
+		senderPrivateKey:           privateKey,
+		senderPublicKey:            publicKey,
+		senderBlockchainAddress:    sender,
+		recipientBlockchainAddress: recipient,
+		token:                      token,
+	}
+}
+
+// SetExpiry limits the block height and unix time up to which the
+// transaction may be mined. It must be called before GenerateSignature.
+func (t *Transaction) SetExpiry(height int64, timestamp int64) {
+	t.expiryHeight = height
+	t.expiresAt = timestamp
+}
+
+// SetLock sets the block height and unix time from which on the transaction
+// may be mined. It must be called before GenerateSignature.
+func (t *Transaction) SetLock(height int64, timestamp int64) {
+	t.lockHeight = height
+	t.lockedUntil = timestamp
+}
+
+// SetDecimals sets the number of decimals of the token. It must be called
+// before GenerateSignature.
+func (t *Transaction) SetDecimals(decimals uint8) {
+	t.decimals = decimals
+}
+
+// SetType sets the transaction type, e.g. block.TxMint or block.TxBurn. It
+// must be called before GenerateSignature.
+func (t *Transaction) SetType(txType string) {
+	t.txType = txType
+}
+
+// SetOutputs turns t into a multi_transfer paying outputs under a single
+// signature. It must be called before GenerateSignature.
+func (t *Transaction) SetOutputs(outputs []*block.Output) {
+	t.txType = block.TxMultiTransfer
+	t.outputs = outputs
+}
+
+func (t *Transaction) SetNonce(nonce uint64) {
+	t.nonce = nonce
 }
 
+// SetSwap turns t into a swap in which the recipient pays swap back. It must
+// be called before GenerateSignature.
+func (t *Transaction) SetSwap(swap *block.Swap) {
+	t.txType = block.TxSwap
+	t.swap = swap
+}
+
+// SetHTLC turns t into an htlc_lock transaction. It must be called before
+// GenerateSignature.
+func (t *Transaction) SetHTLC(htlc *block.HTLC) {
+	t.txType = block.TxHTLCLock
+	t.htlc = htlc
+}
+
+// SetHTLCSettle turns t into an htlc_claim or htlc_refund transaction. It
+// must be called before GenerateSignature.
+func (t *Transaction) SetHTLCSettle(txType string, settle *block.HTLCSettle) {
+	t.txType = txType
+	t.settle = settle
+}
+
+// SetEscrow turns t into an escrow_lock transaction. It must be called before
+// GenerateSignature.
+func (t *Transaction) SetEscrow(escrow *block.Escrow) {
+	t.txType = block.TxEscrowLock
+	t.escrow = escrow
+}
+
+// SetEscrowSettle turns t into an escrow_release or escrow_refund. It must be
+// called before GenerateSignature.
+func (t *Transaction) SetEscrowSettle(txType string, id string) {
+	t.txType = txType
+	t.escrowID = id
+}
+
+// SetDeploy turns t into the deploy of a contract. It must be called before
+// GenerateSignature.
+func (t *Transaction) SetDeploy(deploy *block.ContractDeploy) {
+	t.txType = block.TxDeploy
+	t.deploy = deploy
+}
+
+// SetCall turns t into a call of the contract at its recipient address. It
+// must be called before GenerateSignature.
+func (t *Transaction) SetCall(call *block.ContractCall) {
+	t.txType = block.TxCall
+	t.call = call
+}
+
+// SetIssue turns t into an issue_token transaction registering its token. It
+// must be called before GenerateSignature.
+func (t *Transaction) SetIssue(issue *block.TokenIssue) {
+	t.txType = block.TxIssueToken
+	t.issue = issue
+}
+
+// GenerateSignature signs the same payload the node verifies, see
+// block.Transaction.SigningHash.
 func (t *Transaction) GenerateSignature() *utils.Signature {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
-	r, s, _ := ecdsa.Sign(rand.Reader, t.senderPrivateKey, h[:])
-	return &utils.Signature{R: r, S: s}
+	return SignProposal(t.senderPrivateKey, t.request())
 }
 
-func (t *Transaction) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		Sender    string      `json:"sender_blockchain_address"`
-		Recipient string      `json:"recipient_blockchain_address"`
-		Token     block.Token `json:"token"`
-	}{
-		Sender:    t.senderBlockchainAddress,
-		Recipient: t.recipientBlockchainAddress,
-		Token:     t.token,
-	})
+// SignProposal signs a transaction proposed by someone else, as the recipient
+// of a swap or as one key of a multisig sender. The proposal is signed as it
+// is, so the signer must have checked its terms before.
+func SignProposal(privateKey *ecdsa.PrivateKey, proposal *block.TransactionRequest) *utils.Signature {
+	h := proposal.Transaction().SigningHash()
+	return utils.Sign(privateKey, h[:])
+}
+
+// request converts t to the form the node accepts, without a signature.
+func (t *Transaction) request() *block.TransactionRequest {
+	return &block.TransactionRequest{
+		ChainID:                    &t.chainID,
+		Type:                       &t.txType,
+		Nonce:                      &t.nonce,
+		SenderBlockchainAddress:    &t.senderBlockchainAddress,
+		RecipientBlockchainAddress: &t.recipientBlockchainAddress,
+		TokenName:                  &t.token.TokenName,
+		TokenValue:                 &t.token.TokenValue,
+		TokenDecimals:              &t.decimals,
+		Outputs:                    t.outputs,
+		Swap:                       t.swap,
+		HTLC:                       t.htlc,
+		Settle:                     t.settle,
+		Escrow:                     t.escrow,
+		EscrowID:                   &t.escrowID,
+		Deploy:                     t.deploy,
+		Call:                       t.call,
+		Issue:                      t.issue,
+		ExpiryHeight:               &t.expiryHeight,
+		ExpiresAt:                  &t.expiresAt,
+		LockHeight:                 &t.lockHeight,
+		LockedUntil:                &t.lockedUntil,
+	}
 }
 
 type TransactionRequest struct {
-	SenderPrivateKey           *string          `json:"sender_private_key"`
-	SenderBlockchainAddress    *string          `json:"sender_blockchain_address"`
-	RecipientBlockchainAddress *string          `json:"recipient_blockchain_address"`
-	SenderPublicKey            *string          `json:"sender_public_key"`
-	TokenName                  *string          `json:"token_name"`
-	TokenValue                 *decimal.Decimal `json:"token_value"`
+	SenderPrivateKey           *string               `json:"sender_private_key"`
+	SenderBlockchainAddress    *string               `json:"sender_blockchain_address"`
+	RecipientBlockchainAddress *string               `json:"recipient_blockchain_address"`
+	SenderPublicKey            *string               `json:"sender_public_key"`
+	TokenName                  *string               `json:"token_name"`
+	TokenValue                 *decimal.Decimal      `json:"token_value"`
+	TokenDecimals              *uint8                `json:"token_decimals,omitempty"`
+	Type                       *string               `json:"type,omitempty"`
+	Issue                      *block.TokenIssue     `json:"issue,omitempty"`
+	Outputs                    []*block.Output       `json:"outputs,omitempty"`
+	Swap                       *block.Swap           `json:"swap,omitempty"`
+	HTLC                       *block.HTLC           `json:"htlc,omitempty"`
+	Settle                     *block.HTLCSettle     `json:"htlc_settle,omitempty"`
+	Escrow                     *block.Escrow         `json:"escrow,omitempty"`
+	EscrowID                   *string               `json:"escrow_id,omitempty"`
+	Deploy                     *block.ContractDeploy `json:"deploy,omitempty"`
+	Call                       *block.ContractCall   `json:"call,omitempty"`
+	Nonce                      *uint64               `json:"nonce,omitempty"`
+	ExpiryHeight               *int64                `json:"expiry_height,omitempty"`
+	ExpiresAt                  *int64                `json:"expires_at,omitempty"`
+	LockHeight                 *int64                `json:"lock_height,omitempty"`
+	LockedUntil                *int64                `json:"locked_until,omitempty"`
 }
 
-func (tr *TransactionRequest) Validate() bool {
-	log.Println(tr)
-	if tr.SenderPrivateKey == nil ||
-		tr.SenderBlockchainAddress == nil ||
-		tr.RecipientBlockchainAddress == nil ||
-		tr.SenderPublicKey == nil ||
-		tr.TokenName == nil || tr.TokenValue == nil {
-		return false
+// Validate checks the form of the request. TokenDecimals is optional, the
+// wallet server looks up the decimals of registered tokens.
+func (tr *TransactionRequest) Validate() error {
+	var errs utils.ValidationErrors
+
+	if tr.SenderPrivateKey == nil {
+		errs.Add("sender_private_key", "is required")
+	}
+	if tr.SenderBlockchainAddress == nil {
+		errs.Add("sender_blockchain_address", "is required")
+	}
+	if tr.SenderPublicKey == nil {
+		errs.Add("sender_public_key", "is required")
+	}
+	isIssue := tr.Type != nil && *tr.Type == block.TxIssueToken
+	if tr.Type != nil && *tr.Type == block.TxMultiTransfer {
+		if len(tr.Outputs) == 0 || len(tr.Outputs) > block.MaxOutputs {
+			errs.Add("outputs", fmt.Sprintf("must contain between 1 and %d outputs", block.MaxOutputs))
+		}
+		for i, o := range tr.Outputs {
+			switch {
+			case o == nil:
+				errs.Add(fmt.Sprintf("outputs[%d]", i), "is required")
+			case o.RecipientBlockchainAddress == "":
+				errs.Add(fmt.Sprintf("outputs[%d].recipient_blockchain_address", i), "is required")
+			case o.TokenName == "":
+				errs.Add(fmt.Sprintf("outputs[%d].token_name", i), "is required")
+			case !o.TokenValue.IsPositive():
+				errs.Add(fmt.Sprintf("outputs[%d].token_value", i), "must be positive")
+			}
+		}
+		return errs.Err()
+	}
+	if tr.Type != nil && (*tr.Type == block.TxHTLCClaim || *tr.Type == block.TxHTLCRefund) {
+		// Recipient and amount are taken from the contract.
+		if tr.Settle == nil || tr.Settle.ID == "" {
+			errs.Add("htlc_settle.htlc_id", "is required")
+		} else if *tr.Type == block.TxHTLCClaim && tr.Settle.Preimage == "" {
+			errs.Add("htlc_settle.preimage", "is required for htlc_claim")
+		}
+		return errs.Err()
+	}
+	if tr.Type != nil && *tr.Type == block.TxDeploy {
+		// Deploys move no token and have no recipient.
+		if tr.Deploy == nil || tr.Deploy.Code == "" {
+			errs.Add("deploy.code", "is required for deploy")
+		}
+		return errs.Err()
+	}
+	if tr.Type != nil && *tr.Type == block.TxCall {
+		if tr.RecipientBlockchainAddress == nil {
+			errs.Add("recipient_blockchain_address", "is required")
+		}
+		if tr.Call == nil || tr.Call.GasLimit == 0 {
+			errs.Add("call.gas_limit", "is required for call")
+		}
+		return errs.Err()
+	}
+	if tr.Type != nil && (*tr.Type == block.TxEscrowRelease || *tr.Type == block.TxEscrowRefund) {
+		// Recipient and amount are taken from the escrow.
+		if tr.EscrowID == nil || *tr.EscrowID == "" {
+			errs.Add("escrow_id", "is required")
+		}
+		return errs.Err()
+	}
+	if tr.Type != nil && *tr.Type == block.TxEscrowLock && tr.Escrow == nil {
+		errs.Add("escrow", "is required for escrow_lock")
+	}
+	if tr.Type != nil && *tr.Type == block.TxHTLCLock && tr.HTLC == nil {
+		errs.Add("htlc", "is required for htlc_lock")
+	}
+	if tr.RecipientBlockchainAddress == nil {
+		errs.Add("recipient_blockchain_address", "is required")
+	}
+	if tr.TokenName == nil || *tr.TokenName == "" {
+		errs.Add("token_name", "is required")
+	}
+	switch {
+	case tr.TokenValue == nil:
+		errs.Add("token_value", "is required")
+	case tr.TokenValue.IsNegative(), tr.TokenValue.IsZero() && !isIssue:
+		errs.Add("token_value", "must be positive")
+	}
+	if tr.TokenDecimals != nil && *tr.TokenDecimals > block.MaxTokenDecimals {
+		errs.Add("token_decimals", fmt.Sprintf("must be at most %d", block.MaxTokenDecimals))
+	}
+	if isIssue && tr.Issue == nil {
+		errs.Add("issue", "is required for issue_token")
+	}
+	if tr.Type != nil && *tr.Type == block.TxSwap {
+		switch {
+		case tr.Swap == nil:
+			errs.Add("swap", "is required for swap")
+		case tr.Swap.TokenName == "":
+			errs.Add("swap.token_name", "is required")
+		case !tr.Swap.TokenValue.IsPositive():
+			errs.Add("swap.token_value", "must be positive")
+		}
+	}
+
+	return errs.Err()
+}
+
+// CosignRequest carries a swap or escrow settlement proposal and the keys of
+// the party co-signing it.
+type CosignRequest struct {
+	Proposal               *block.TransactionRequest `json:"proposal"`
+	CounterpartyPrivateKey *string                   `json:"counterparty_private_key"`
+	CounterpartyPublicKey  *string                   `json:"counterparty_public_key"`
+}
+
+func (cr *CosignRequest) Validate() error {
+	var errs utils.ValidationErrors
+
+	if cr.Proposal == nil {
+		errs.Add("proposal", "is required")
+	} else if err := cr.Proposal.ValidateProposal(); err != nil {
+		for _, fe := range err.(utils.ValidationErrors) {
+			errs.Add("proposal."+fe.Field, fe.Message)
+		}
+	}
+	if cr.CounterpartyPrivateKey == nil {
+		errs.Add("counterparty_private_key", "is required")
+	}
+	if cr.CounterpartyPublicKey == nil || len(*cr.CounterpartyPublicKey) != 128 {
+		errs.Add("counterparty_public_key", "must be 128 hex characters")
+	}
+
+	return errs.Err()
+}
+
+// MultisigSignRequest carries a multisig proposal and the keys of one of its
+// signers.
+type MultisigSignRequest struct {
+	Proposal   *block.TransactionRequest `json:"proposal"`
+	PrivateKey *string                   `json:"private_key"`
+	PublicKey  *string                   `json:"public_key"`
+}
+
+func (sr *MultisigSignRequest) Validate() error {
+	var errs utils.ValidationErrors
+
+	if sr.Proposal == nil {
+		errs.Add("proposal", "is required")
+	} else if sr.Proposal.Multisig == nil {
+		errs.Add("proposal.multisig", "is required")
+	} else if err := sr.Proposal.ValidateProposal(); err != nil {
+		for _, fe := range err.(utils.ValidationErrors) {
+			errs.Add("proposal."+fe.Field, fe.Message)
+		}
+	}
+	if sr.PrivateKey == nil {
+		errs.Add("private_key", "is required")
+	}
+	if sr.PublicKey == nil || len(*sr.PublicKey) != 128 {
+		errs.Add("public_key", "must be 128 hex characters")
 	}
 
-	return true
+	return errs.Err()
 }
@@ -0,0 +1,79 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"main/block"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestGenerateSignature(t *testing.T) {
+	sender := NewWalletWithVersion(0x1e)
+	recipient := NewWalletWithVersion(0x1e)
+	chainID := uint64(7)
+	senderAddr, recipientAddr := sender.BlockchainAddress(), recipient.BlockchainAddress()
+	value := decimal.NewFromInt(3)
+	name := "GOLD"
+	decimals := uint8(2)
+	nonce := uint64(4)
+	multi, deploy, release := block.TxMultiTransfer, block.TxDeploy, block.TxEscrowRelease
+	escrowID := "e1"
+	outputs := []*block.Output{{RecipientBlockchainAddress: recipientAddr, TokenName: name, TokenValue: value}}
+	code := &block.ContractDeploy{Code: "00"}
+	empty := ""
+
+	tests := []struct {
+		name  string
+		setup func(tx *Transaction)
+		// req is the request the node receives for tx.
+		req block.TransactionRequest
+	}{
+		{"transfer", func(tx *Transaction) {}, block.TransactionRequest{
+			RecipientBlockchainAddress: &recipientAddr,
+			TokenName:                  &name,
+			TokenValue:                 &value,
+		}},
+		{"multi_transfer", func(tx *Transaction) { tx.SetOutputs(outputs) }, block.TransactionRequest{
+			Type:                       &multi,
+			RecipientBlockchainAddress: &empty,
+			Outputs:                    outputs,
+		}},
+		{"deploy", func(tx *Transaction) { tx.SetDeploy(code) }, block.TransactionRequest{
+			Type:                       &deploy,
+			RecipientBlockchainAddress: &empty,
+			Deploy:                     code,
+		}},
+		{"escrow_release", func(tx *Transaction) { tx.SetEscrowSettle(release, escrowID) }, block.TransactionRequest{
+			Type:                       &release,
+			RecipientBlockchainAddress: &recipientAddr,
+			TokenName:                  &name,
+			TokenValue:                 &value,
+			EscrowID:                   &escrowID,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var recipient string
+			if tt.req.RecipientBlockchainAddress != nil {
+				recipient = *tt.req.RecipientBlockchainAddress
+			}
+			tx := NewTransaction(chainID, sender.PrivateKey(), sender.PublicKey(), senderAddr, recipient,
+				block.Token{TokenName: name, TokenValue: value})
+			tx.SetDecimals(decimals)
+			tx.SetNonce(nonce)
+			tt.setup(tx)
+			s := tx.GenerateSignature()
+
+			req := tt.req
+			req.ChainID = &chainID
+			req.SenderBlockchainAddress = &senderAddr
+			req.TokenDecimals = &decimals
+			req.Nonce = &nonce
+			h := req.Transaction().SigningHash()
+			if !ecdsa.Verify(sender.PublicKey(), h[:], s.R, s.S) {
+				t.Errorf("signature does not verify against the node's signing hash")
+			}
+		})
+	}
+}
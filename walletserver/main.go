@@ -2,8 +2,11 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"main/config"
 	"main/walletserver/server"
+	"strings"
 )
 
 func init() {
@@ -11,11 +14,30 @@ func init() {
 }
 
 func main() {
-	port := flag.Uint("port", 8080, "TCP Port for Wallet Server")
-	gateway := flag.String("gateway", "http://localhost:3000", "Blockchain Gateway")
+	port := flag.Uint("port", 0, "TCP Port for Wallet Server (defaults to the network's wallet port)")
+	gateway := flag.String("gateway", "", "Blockchain Gateway (defaults to the local node of the network)")
+	network := flag.String("network", "devnet", "Network profile: devnet, testnet or mainnet")
+	light := flag.Bool("light", false, "Light client mode: sync headers and verify proofs instead of trusting the gateway")
+	nodes := flag.String("nodes", "", "Comma separated nodes a light client follows besides the gateway")
 	flag.Parse()
 
-	app := server.NewWalletServer(uint16(*port), *gateway)
+	n, err := config.GetNetwork(*network)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *port == 0 {
+		*port = uint(n.WalletPort)
+	}
+	if *gateway == "" {
+		*gateway = fmt.Sprintf("http://localhost:%d", n.DefaultPort)
+	}
+
+	app := server.NewWalletServer(uint16(*port), *gateway, n)
+	if *light {
+		if err := app.UseLightClient(strings.Split(*nodes, ",")); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	app.Run()
 }
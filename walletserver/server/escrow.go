@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"main/block"
+	"main/utils"
+	"main/wallet"
+	"net/http"
+	"net/url"
+)
+
+// Escrows are locked with a plain escrow_lock transaction. Releasing or
+// refunding one takes two of its parties: one proposes and signs the
+// settlement, a second one co-signs it and either submits it with
+// SubmitSigned.
+
+// ProposeEscrowSettle signs an escrow_release or escrow_refund as one party
+// of the escrow and returns the proposal for a second party to co-sign. The
+// payee and the amount are taken from the escrow.
+func (ws *WalletServer) ProposeEscrowSettle(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		var t wallet.TransactionRequest
+		if err := json.NewDecoder(req.Body).Decode(&t); err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if t.Type == nil || (*t.Type != block.TxEscrowRelease && *t.Type != block.TxEscrowRefund) {
+			err := fmt.Errorf("%w: type must be %s or %s", block.ErrInvalidEscrow, block.TxEscrowRelease, block.TxEscrowRefund)
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		if err := t.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		info, err := ws.Escrow(*t.EscrowID)
+		if err == nil && info.State != block.EscrowLocked {
+			err = block.ErrEscrowSettled
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		if s := *t.SenderBlockchainAddress; s != info.Sender && s != info.Recipient && s != info.Arbiter {
+			err := fmt.Errorf("%w: %s is not a party of the escrow", block.ErrInvalidEscrow, s)
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		payee := info.Recipient
+		if *t.Type == block.TxEscrowRefund {
+			payee = info.Sender
+		}
+
+		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
+		privateKey := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
+
+		transaction := wallet.NewTransaction(ws.network.ChainID, privateKey, publicKey, *t.SenderBlockchainAddress, payee, block.Token{TokenName: info.TokenName, TokenValue: info.Amount})
+		var expiryHeight, expiresAt int64
+		if t.ExpiryHeight != nil {
+			expiryHeight = *t.ExpiryHeight
+		}
+		if t.ExpiresAt != nil {
+			expiresAt = *t.ExpiresAt
+		}
+		transaction.SetExpiry(expiryHeight, expiresAt)
+		var lockHeight, lockedUntil int64
+		if t.LockHeight != nil {
+			lockHeight = *t.LockHeight
+		}
+		if t.LockedUntil != nil {
+			lockedUntil = *t.LockedUntil
+		}
+		transaction.SetLock(lockHeight, lockedUntil)
+		transaction.SetDecimals(info.Decimals)
+		transaction.SetEscrowSettle(*t.Type, info.ID)
+		if t.Nonce == nil {
+			nonce, err := ws.Nonce(*t.SenderBlockchainAddress)
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+			t.Nonce = &nonce
+		}
+		transaction.SetNonce(*t.Nonce)
+		signatureStr := transaction.GenerateSignature().String()
+		chainID := ws.network.ChainID
+
+		proposal := &block.TransactionRequest{
+			ChainID:                    &chainID,
+			Type:                       t.Type,
+			EscrowID:                   &info.ID,
+			SenderBlockchainAddress:    t.SenderBlockchainAddress,
+			RecipientBlockchainAddress: &payee,
+			SenderPublicKey:            t.SenderPublicKey,
+			TokenName:                  &info.TokenName,
+			TokenValue:                 &info.Amount,
+			TokenDecimals:              &info.Decimals,
+			Nonce:                      t.Nonce,
+			Signature:                  &signatureStr,
+			ExpiryHeight:               t.ExpiryHeight,
+			ExpiresAt:                  t.ExpiresAt,
+			LockHeight:                 t.LockHeight,
+			LockedUntil:                t.LockedUntil,
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(proposal)
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// CosignEscrowSettle adds the signature of a second party of the escrow to a
+// settlement proposal and returns the signed settlement.
+func (ws *WalletServer) CosignEscrowSettle(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		var c wallet.CosignRequest
+		if err := json.NewDecoder(req.Body).Decode(&c); err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if err := c.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		if c.Proposal.EscrowID == nil {
+			err := fmt.Errorf("%w: proposal is not an escrow settlement", block.ErrInvalidEscrow)
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		info, err := ws.Escrow(*c.Proposal.EscrowID)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		publicKey := utils.PublicKeyFromString(*c.CounterpartyPublicKey)
+		address := utils.AddressFromPublicKey(publicKey, ws.network.AddressVersion)
+		if (address != info.Sender && address != info.Recipient && address != info.Arbiter) || address == *c.Proposal.SenderBlockchainAddress {
+			err := fmt.Errorf("%w: co-signer must be another party of the escrow", block.ErrInvalidEscrow)
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		privateKey := utils.PrivateKeyFromString(*c.CounterpartyPrivateKey, publicKey)
+
+		signatureStr := wallet.SignProposal(privateKey, c.Proposal).String()
+		c.Proposal.CounterpartyPublicKey = c.CounterpartyPublicKey
+		c.Proposal.CounterpartySignature = &signatureStr
+
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(c.Proposal)
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// Escrow asks the gateway for the state of an escrow.
+func (ws *WalletServer) Escrow(id string) (*block.EscrowInfo, error) {
+	response, err := http.Get(ws.Gateway() + "/escrow/" + url.PathEscape(id))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", block.ErrUnknownEscrow, id)
+	}
+
+	var info block.EscrowInfo
+	if err := json.NewDecoder(response.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
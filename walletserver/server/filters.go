@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"main/block"
+	"main/utils"
+	"net/http"
+)
+
+// HistoryEntry is a transaction of the wallet with the height of its block.
+type HistoryEntry struct {
+	BlockHeight int64              `json:"block_height"`
+	Transaction *block.Transaction `json:"transaction"`
+}
+
+// History lists the mined transactions of the blockchain_address query
+// parameter. The block filters are matched here, so the gateway only learns
+// which blocks are fetched, never the address. Downloaded blocks must match
+// the hash their filter was built for and their transactions root, in light
+// client mode that hash must also be in the verified header chain. A block
+// pruned by the gateway is asked from the other light client nodes, blocks no
+// node has kept are reported as unavailable and left out of the history.
+func (ws *WalletServer) History(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		if err := utils.ValidateAddress(blockchainAddress, ws.network.AddressVersion, ws.network.MultisigVersion); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		history, scanned, fetched, unavailable, err := ws.history(blockchainAddress)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadGateway)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		m, _ := json.Marshal(struct {
+			Transactions      []*HistoryEntry `json:"transactions"`
+			BlocksScanned     int             `json:"blocks_scanned"`
+			BlocksFetched     int             `json:"blocks_fetched"`
+			BlocksUnavailable []int64         `json:"blocks_unavailable"`
+		}{
+			Transactions:      history,
+			BlocksScanned:     scanned,
+			BlocksFetched:     fetched,
+			BlocksUnavailable: unavailable,
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+func (ws *WalletServer) history(address string) ([]*HistoryEntry, int, int, []int64, error) {
+	history := make([]*HistoryEntry, 0)
+	unavailable := make([]int64, 0)
+	var previous *block.BlockFilter
+	scanned, fetched := 0, 0
+	for {
+		filters, err := ws.fetchFilters(int64(scanned))
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+		for _, f := range filters {
+			if f.Height != int64(scanned) {
+				return nil, 0, 0, nil, fmt.Errorf("%w: expected height %d, got %d", block.ErrInvalidFilter, scanned, f.Height)
+			}
+			if err := f.Verify(previous); err != nil {
+				return nil, 0, 0, nil, err
+			}
+			previous = f
+			scanned++
+
+			if ws.light != nil {
+				if err := ws.light.checkHeader(f.Height, f.BlockHash); err != nil {
+					return nil, 0, 0, nil, err
+				}
+			}
+			match, err := f.Match(address)
+			if err != nil {
+				return nil, 0, 0, nil, err
+			}
+			if !match {
+				continue
+			}
+
+			b, err := ws.fetchBlock(f)
+			if errors.Is(err, block.ErrPruned) {
+				unavailable = append(unavailable, f.Height)
+				continue
+			}
+			if err != nil {
+				return nil, 0, 0, nil, err
+			}
+			fetched++
+			for _, t := range b.Transactions() {
+				for _, a := range t.Addresses() {
+					if a == address {
+						history = append(history, &HistoryEntry{BlockHeight: f.Height, Transaction: t})
+						break
+					}
+				}
+			}
+		}
+		if len(filters) < block.MaxFilters {
+			return history, scanned, fetched, unavailable, nil
+		}
+	}
+}
+
+func (ws *WalletServer) fetchFilters(from int64) ([]*block.BlockFilter, error) {
+	response, err := http.Get(fmt.Sprintf("%s/filters?from_height=%d", ws.Gateway(), from))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("node answered %s", response.Status)
+	}
+	var page struct {
+		Filters []*block.BlockFilter `json:"filters"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return page.Filters, nil
+}
+
+// fetchBlock downloads the block f was built for from the gateway, or in light
+// client mode from the first node that still has its body. It returns
+// block.ErrPruned when every node answered that the block is pruned.
+func (ws *WalletServer) fetchBlock(f *block.BlockFilter) (*block.Block, error) {
+	nodes := []string{ws.Gateway()}
+	if ws.light != nil {
+		nodes = ws.light.Nodes()
+	}
+
+	var lastErr error
+	for _, n := range nodes {
+		b, err := fetchBlockFrom(n, f)
+		if err == nil {
+			return b, nil
+		}
+		if !errors.Is(err, block.ErrPruned) || lastErr == nil {
+			lastErr = err
+		}
+		log.Printf("ERROR: %s: %v", n, err)
+	}
+	return nil, lastErr
+}
+
+// fetchBlockFrom downloads the block f was built for from node and checks it
+// against f.
+func fetchBlockFrom(node string, f *block.BlockFilter) (*block.Block, error) {
+	response, err := http.Get(fmt.Sprintf("%s/blocks/%d", node, f.Height))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusGone {
+		return nil, fmt.Errorf("block %d: %w", f.Height, block.ErrPruned)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("block %d: node answered %s", f.Height, response.Status)
+	}
+	var b block.Block
+	if err := json.NewDecoder(response.Body).Decode(&b); err != nil {
+		return nil, err
+	}
+	if fmt.Sprintf("%x", b.Hash()) != f.BlockHash {
+		return nil, fmt.Errorf("block %d does not match its filter", f.Height)
+	}
+	if b.Header().TransactionsRoot != fmt.Sprintf("%x", block.TransactionsRoot(b.Transactions())) {
+		return nil, errors.New("block does not match its transactions root")
+	}
+	return &b, nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"main/block"
+	"net/http"
+	"net/url"
+)
+
+// HTLCSecret returns a fresh secret and its hash lock. The party starting a
+// cross-chain swap locks its funds against the hash and keeps the secret
+// until it claims the funds of the counterparty.
+func (ws *WalletServer) HTLCSecret(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		h := sha256.Sum256(secret)
+
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(struct {
+			Preimage string `json:"preimage"`
+			HashLock string `json:"hash_lock"`
+		}{
+			Preimage: hex.EncodeToString(secret),
+			HashLock: hex.EncodeToString(h[:]),
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// HTLC asks the gateway for the state of a contract.
+func (ws *WalletServer) HTLC(id string) (*block.HTLCInfo, error) {
+	response, err := http.Get(ws.Gateway() + "/htlc/" + url.PathEscape(id))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", block.ErrUnknownHTLC, id)
+	}
+
+	var info block.HTLCInfo
+	if err := json.NewDecoder(response.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
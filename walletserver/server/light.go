@@ -0,0 +1,409 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"main/block"
+	"main/config"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// In light client mode the wallet server does not take the word of its
+// gateway. It follows the chain of several nodes by their headers alone,
+// checking that they link up and carry a valid proof of work, and only shows
+// balances and transactions whose proofs match a header of that chain.
+
+const (
+	lightSyncInterval = 10 * time.Second
+	// lightReorgDepth is how far back a sync fetches headers again, so
+	// that short forks are followed without downloading all headers.
+	lightReorgDepth = 12
+	// lightProofWindow is how many blocks a balance proof may be behind the
+	// verified tip and still count as the current balance.
+	lightProofWindow = 3
+)
+
+var ErrUnknownHeader = errors.New("block is not in the verified header chain")
+
+// LightClient keeps the longest valid header chain among its nodes. The
+// chain is pinned to the genesis block embedded for the network. A network
+// without one, devnet, pins the genesis block more than half of the nodes
+// agree on. Nodes with another genesis are ignored.
+type LightClient struct {
+	nodes       []string
+	chainID     uint64
+	mux         sync.RWMutex
+	genesisHash string
+	difficulty  int
+	rewardToken string
+	headers     []*block.BlockHeader
+}
+
+func NewLightClient(nodes []string, network config.Network) (*LightClient, error) {
+	lc := &LightClient{nodes: nodes, chainID: network.ChainID}
+	m, err := network.Genesis()
+	if err != nil || m == nil {
+		return lc, err
+	}
+	genesis, err := block.ParseGenesis(m)
+	if err != nil {
+		return nil, err
+	}
+	lc.genesisHash = fmt.Sprintf("%x", genesis.Block().Hash())
+	lc.difficulty = genesis.Difficulty
+	lc.rewardToken = genesis.RewardSchedule.Token
+	return lc, nil
+}
+
+func (lc *LightClient) Nodes() []string {
+	return lc.nodes
+}
+
+// Height returns the height of the tip of the verified header chain, -1
+// before the first sync.
+func (lc *LightClient) Height() int64 {
+	lc.mux.RLock()
+	defer lc.mux.RUnlock()
+	return int64(len(lc.headers)) - 1
+}
+
+func (lc *LightClient) StartSync() {
+	lc.Sync()
+	_ = time.AfterFunc(lightSyncInterval, lc.StartSync)
+}
+
+// Sync asks every node for the headers past the verified chain and adopts
+// the longest chain that checks out.
+func (lc *LightClient) Sync() {
+	if err := lc.pinGenesis(); err != nil {
+		log.Printf("ERROR: light client: %v", err)
+		return
+	}
+	var longest []*block.BlockHeader
+	for _, n := range lc.nodes {
+		headers, err := lc.syncNode(n)
+		if err != nil {
+			log.Printf("ERROR: light client sync with %s: %v", n, err)
+			continue
+		}
+		if len(headers) > len(longest) {
+			longest = headers
+		}
+	}
+
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	if len(longest) > len(lc.headers) {
+		lc.headers = longest
+		log.Printf("light client synced to height %d", len(longest)-1)
+	}
+}
+
+// syncNode returns the header chain of node, reusing the verified headers
+// it shares with ours.
+func (lc *LightClient) syncNode(node string) ([]*block.BlockHeader, error) {
+	if err := lc.checkGenesis(node); err != nil {
+		return nil, err
+	}
+
+	lc.mux.RLock()
+	known := lc.headers
+	genesisHash, difficulty := lc.genesisHash, lc.difficulty
+	lc.mux.RUnlock()
+
+	from := len(known) - lightReorgDepth
+	if from < 0 {
+		from = 0
+	}
+	headers, err := fetchHeaders(node, int64(from))
+	if err != nil {
+		return nil, err
+	}
+	chain := append(append([]*block.BlockHeader{}, known[:from]...), headers...)
+	err = verifyHeaders(chain, from, genesisHash, difficulty)
+	if err != nil && from > 0 {
+		// The node forked off deeper than we looked, start over.
+		if chain, err = fetchHeaders(node, 0); err != nil {
+			return nil, err
+		}
+		err = verifyHeaders(chain, 0, genesisHash, difficulty)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// pinGenesis pins the genesis block more than half of the nodes report,
+// unless the genesis is pinned already.
+func (lc *LightClient) pinGenesis() error {
+	lc.mux.RLock()
+	pinned := lc.genesisHash != ""
+	lc.mux.RUnlock()
+	if pinned {
+		return nil
+	}
+
+	votes := make(map[string]int)
+	for _, n := range lc.nodes {
+		genesis, err := lc.fetchGenesis(n)
+		if err != nil {
+			log.Printf("ERROR: light client genesis of %s: %v", n, err)
+			continue
+		}
+		hash := fmt.Sprintf("%x", genesis.Block().Hash())
+		if votes[hash]++; votes[hash]*2 > len(lc.nodes) {
+			lc.mux.Lock()
+			defer lc.mux.Unlock()
+			lc.genesisHash, lc.difficulty = hash, genesis.Difficulty
+			lc.rewardToken = genesis.RewardSchedule.Token
+			log.Printf("light client pinned genesis %s, reported by %d of %d nodes", hash, votes[hash], len(lc.nodes))
+			return nil
+		}
+	}
+	return fmt.Errorf("no genesis block is reported by more than half of %d nodes", len(lc.nodes))
+}
+
+// checkGenesis makes sure node is on the chain of the pinned genesis block.
+func (lc *LightClient) checkGenesis(node string) error {
+	genesis, err := lc.fetchGenesis(node)
+	if err != nil {
+		return err
+	}
+	hash := fmt.Sprintf("%x", genesis.Block().Hash())
+
+	lc.mux.RLock()
+	defer lc.mux.RUnlock()
+	if hash != lc.genesisHash {
+		return fmt.Errorf("node is on another chain (genesis %s)", hash)
+	}
+	return nil
+}
+
+// fetchGenesis asks node for its genesis, which must be for our chain ID.
+func (lc *LightClient) fetchGenesis(node string) (*block.Genesis, error) {
+	response, err := http.Get(node + "/genesis")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var g struct {
+		ChainID uint64         `json:"chain_id"`
+		Genesis *block.Genesis `json:"genesis"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&g); err != nil {
+		return nil, err
+	}
+	if g.ChainID != lc.chainID || g.Genesis == nil {
+		return nil, fmt.Errorf("node is on chain %d, not %d", g.ChainID, lc.chainID)
+	}
+	return g.Genesis, nil
+}
+
+// fetchHeaders pages through the headers of node from height from on.
+func fetchHeaders(node string, from int64) ([]*block.BlockHeader, error) {
+	headers := make([]*block.BlockHeader, 0)
+	for {
+		response, err := http.Get(fmt.Sprintf("%s/headers?from_height=%d", node, from+int64(len(headers))))
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Headers []*block.BlockHeader `json:"headers"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, page.Headers...)
+		if len(page.Headers) < block.MaxHeaders {
+			return headers, nil
+		}
+	}
+}
+
+// verifyHeaders checks the headers of chain from height from on: the first
+// one must be the genesis block, every later one must link to its
+// predecessor and carry a valid proof of work.
+func verifyHeaders(chain []*block.BlockHeader, from int, genesisHash string, difficulty int) error {
+	if len(chain) == 0 {
+		return errors.New("no headers")
+	}
+	for height := from; height < len(chain); height++ {
+		h := chain[height]
+		if height == 0 {
+			if fmt.Sprintf("%x", h.Hash()) != genesisHash {
+				return errors.New("header 0 is not the genesis block")
+			}
+			continue
+		}
+		if h.PreviousHash != fmt.Sprintf("%x", chain[height-1].Hash()) {
+			return fmt.Errorf("header %d does not link to its predecessor", height)
+		}
+		if !h.ValidProof(difficulty) {
+			return fmt.Errorf("header %d has an invalid proof of work", height)
+		}
+	}
+	return nil
+}
+
+// checkHeader makes sure the block at height with the given hash is part of
+// the verified chain, syncing once if the chain is not that long yet.
+func (lc *LightClient) checkHeader(height int64, hash string) error {
+	if height > lc.Height() {
+		lc.Sync()
+	}
+	lc.mux.RLock()
+	defer lc.mux.RUnlock()
+	if height < 0 || height >= int64(len(lc.headers)) || fmt.Sprintf("%x", lc.headers[height].Hash()) != hash {
+		return fmt.Errorf("%w: %d %s", ErrUnknownHeader, height, hash)
+	}
+	return nil
+}
+
+// Tokens lists the reward token of the pinned genesis and every token in the
+// registry of any node, ordered by symbol. A node can add tokens that do not
+// exist, their balances are proven to be zero, but it cannot hide one the
+// other nodes know.
+func (lc *LightClient) Tokens() []string {
+	lc.mux.RLock()
+	symbols := map[string]bool{}
+	if lc.rewardToken != "" {
+		symbols[lc.rewardToken] = true
+	}
+	lc.mux.RUnlock()
+
+	for _, n := range lc.nodes {
+		tokens, err := fetchTokens(n)
+		if err != nil {
+			log.Printf("ERROR: light client tokens of %s: %v", n, err)
+			continue
+		}
+		for _, t := range tokens {
+			symbols[t.Symbol] = true
+		}
+	}
+
+	tokens := make([]string, 0, len(symbols))
+	for symbol := range symbols {
+		tokens = append(tokens, symbol)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+func fetchTokens(node string) ([]*block.TokenInfo, error) {
+	response, err := http.Get(node + "/tokens")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("node answered %s", response.Status)
+	}
+	var page struct {
+		Tokens []*block.TokenInfo `json:"tokens"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return page.Tokens, nil
+}
+
+// Balance asks the nodes in turn for the proof of the balance of address in
+// tokenName and returns the first one that verifies.
+func (lc *LightClient) Balance(address string, tokenName string) (*block.BalanceProof, error) {
+	err := errors.New("no node to ask")
+	for _, n := range lc.nodes {
+		var p *block.BalanceProof
+		if p, err = fetchBalanceProof(n, address, tokenName); err != nil {
+			continue
+		}
+		if err = p.Verify(); err != nil {
+			continue
+		}
+		if err = lc.checkHeader(p.BlockHeight, p.BlockHash); err != nil {
+			continue
+		}
+		if p.BlockchainAddress != address || p.TokenName != tokenName {
+			err = fmt.Errorf("%w: proof is for another balance", block.ErrInvalidProof)
+			continue
+		}
+		return p, nil
+	}
+	return nil, err
+}
+
+func fetchBalanceProof(node string, address string, tokenName string) (*block.BalanceProof, error) {
+	response, err := http.Get(node + "/proof/balance?blockchain_address=" + url.QueryEscape(address) + "&token_name=" + url.QueryEscape(tokenName))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("node answered %s", response.Status)
+	}
+	var p block.BalanceProof
+	if err := json.NewDecoder(response.Body).Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Transaction asks the nodes in turn for the proof that transaction id is
+// mined and returns the first one that verifies. It returns
+// block.ErrNotMined if no node knows it as mined.
+func (lc *LightClient) Transaction(id string) (*block.TransactionProof, error) {
+	err := block.ErrNotMined
+	for _, n := range lc.nodes {
+		p, fetchErr := fetchTransactionProof(n, id)
+		if fetchErr != nil {
+			if !errors.Is(fetchErr, block.ErrNotMined) {
+				err = fetchErr
+			}
+			continue
+		}
+		if err = p.Verify(); err != nil {
+			continue
+		}
+		if err = lc.checkHeader(p.BlockHeight, p.BlockHash); err != nil {
+			continue
+		}
+		if p.Transaction.ID() != id {
+			err = fmt.Errorf("%w: proof is for another transaction", block.ErrInvalidProof)
+			continue
+		}
+		return p, nil
+	}
+	return nil, err
+}
+
+func fetchTransactionProof(node string, id string) (*block.TransactionProof, error) {
+	response, err := http.Get(node + "/proof/tx/" + url.PathEscape(id))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, block.ErrNotMined
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("node answered %s", response.Status)
+	}
+	var p block.TransactionProof
+	if err := json.NewDecoder(response.Body).Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
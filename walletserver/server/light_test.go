@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"main/block"
+	"main/config"
+	"main/server/app"
+	"main/utils"
+	"main/wallet"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// newNode starts a devnet node that mined a few blocks to owner, which
+// issued 100 GOLD.
+func newNode(t *testing.T, owner *wallet.Wallet) *app.BlockchainServer {
+	t.Helper()
+	conf := config.Blockchain{
+		Network:                "devnet",
+		Difficulty:             1,
+		MiningSender:           "DENIZ",
+		DefaultRewardToken:     "DNZ",
+		MiningReward:           8,
+		DbSavePath:             t.TempDir(),
+		MempoolMaxAge:          time.Hour,
+		MempoolJanitorInterval: time.Hour,
+		MinerAddress:           owner.BlockchainAddress(),
+	}
+	bcs := app.NewBlockchainServer(0, conf)
+	bc := bcs.GetBlockchain()
+	bc.Mining()
+
+	txType, symbol, recipient := block.TxIssueToken, "GOLD", owner.BlockchainAddress()
+	value, decimals, nonce, chainID := decimal.NewFromInt(100), uint8(0), uint64(1), bc.Network().ChainID
+	publicKey := owner.PublicKeyStr()
+	req := &block.TransactionRequest{
+		SenderBlockchainAddress:    &recipient,
+		RecipientBlockchainAddress: &recipient,
+		SenderPublicKey:            &publicKey,
+		TokenName:                  &symbol,
+		TokenValue:                 &value,
+		TokenDecimals:              &decimals,
+		ChainID:                    &chainID,
+		Type:                       &txType,
+		Issue:                      &block.TokenIssue{},
+		Nonce:                      &nonce,
+	}
+	h := req.Transaction().SigningHash()
+	signature := utils.Sign(owner.PrivateKey(), h[:]).String()
+	req.Signature = &signature
+	if err := bc.AddTransaction(req.Transaction()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		bc.Mining()
+	}
+	return bcs
+}
+
+// serveNode serves the endpoints the light client uses. Tokens named in
+// hidden are left out of the token registry and of the balances.
+func serveNode(t *testing.T, bcs *app.BlockchainServer, hidden ...string) string {
+	t.Helper()
+	hide := func(name string) bool {
+		for _, h := range hidden {
+			if h == name {
+				return true
+			}
+		}
+		return false
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/genesis", bcs.GetGenesis)
+	mux.HandleFunc("/headers", bcs.GetHeaders)
+	mux.HandleFunc("/proof/balance", bcs.GetBalanceProof)
+	mux.HandleFunc("/tokens", func(w http.ResponseWriter, req *http.Request) {
+		tokens := make([]*block.TokenInfo, 0)
+		for _, info := range bcs.GetBlockchain().Tokens() {
+			if !hide(info.Symbol) {
+				tokens = append(tokens, info)
+			}
+		}
+		m, _ := json.Marshal(map[string]any{"tokens": tokens})
+		w.Write(m)
+	})
+	mux.HandleFunc("/balance_all", func(w http.ResponseWriter, req *http.Request) {
+		amounts := make([]*block.Balance, 0)
+		for _, b := range bcs.GetBlockchain().CalculateAllAmounts(req.URL.Query().Get("blockchain_address")) {
+			if !hide(b.TokenName) {
+				amounts = append(amounts, b)
+			}
+		}
+		m, _ := json.Marshal(map[string]any{"message": "success", "amount": amounts})
+		w.Write(m)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestWalletAmountProvesEveryToken(t *testing.T) {
+	network, err := config.GetNetwork("devnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := wallet.NewWalletWithVersion(network.AddressVersion)
+	bcs := newNode(t, owner)
+	honest := serveNode(t, bcs)
+	bc := bcs.GetBlockchain()
+	want := map[string]decimal.Decimal{
+		"DNZ":  bc.CalculateTotalAmount(owner.BlockchainAddress(), "DNZ"),
+		"GOLD": decimal.NewFromInt(100),
+	}
+
+	tests := []struct {
+		name   string
+		hidden []string
+	}{
+		{"honest gateway", nil},
+		{"hidden token", []string{"GOLD"}},
+		{"no tokens", []string{"DNZ", "GOLD"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := NewWalletServer(0, serveNode(t, bcs, tt.hidden...), network)
+			if err := ws.UseLightClient([]string{honest}); err != nil {
+				t.Fatal(err)
+			}
+			ws.light.Sync()
+
+			w := httptest.NewRecorder()
+			ws.WalletAmount(w, httptest.NewRequest(http.MethodGet, "/wallet/amount?blockchain_address="+owner.BlockchainAddress(), nil))
+			var got struct {
+				Amount   []*block.Balance `json:"amount"`
+				Verified bool             `json:"verified"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("%v: %s", err, w.Body)
+			}
+			if !got.Verified {
+				t.Error("balance is not verified")
+			}
+			if len(got.Amount) != len(want) {
+				t.Fatalf("%d balances, want %d", len(got.Amount), len(want))
+			}
+			for _, b := range got.Amount {
+				if !b.TokenValue.Equal(want[b.TokenName]) {
+					t.Errorf("balance of %s = %s, want %s", b.TokenName, b.TokenValue, want[b.TokenName])
+				}
+			}
+		})
+	}
+}
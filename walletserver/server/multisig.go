@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"main/block"
+	"main/utils"
+	"main/wallet"
+	"net/http"
+	"sort"
+)
+
+// Multisig accounts are created from their public keys, their transactions
+// are proposed without any key, signed by one key after the other and
+// submitted with SubmitSigned once enough keys signed.
+
+// CreateMultisig returns the address of a multisig account. The keys are
+// sorted so every owner arrives at the same address.
+func (ws *WalletServer) CreateMultisig(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		var m block.Multisig
+		if err := json.NewDecoder(req.Body).Decode(&m); err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		sort.Strings(m.PublicKeys)
+		if err := m.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		m2, _ := json.Marshal(struct {
+			BlockchainAddress string `json:"blockchain_address"`
+			*block.Multisig
+		}{
+			BlockchainAddress: m.Address(ws.network.MultisigVersion),
+			Multisig:          &m,
+		})
+		io.WriteString(w, string(m2[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// ProposeMultisig completes an unsigned transaction of a multisig account
+// with the chain ID, the decimals of its tokens, the next nonce and an empty
+// signature per key.
+func (ws *WalletServer) ProposeMultisig(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		var p block.TransactionRequest
+		if err := json.NewDecoder(req.Body).Decode(&p); err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if p.Multisig == nil || p.SenderBlockchainAddress == nil {
+			err := fmt.Errorf("%w: sender_blockchain_address and multisig are required", block.ErrInvalidMultisig)
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		chainID := ws.network.ChainID
+		p.ChainID = &chainID
+		p.SenderPublicKey, p.Signature = nil, nil
+		p.Signatures = make([]string, len(p.Multisig.PublicKeys))
+
+		var err error
+		if p.Nonce == nil {
+			var nonce uint64
+			nonce, err = ws.Nonce(*p.SenderBlockchainAddress)
+			p.Nonce = &nonce
+		}
+		for _, o := range p.Outputs {
+			if err == nil {
+				o.TokenDecimals, err = ws.TokenDecimals(o.TokenName)
+			}
+		}
+		if err == nil && p.TokenName != nil {
+			var decimals uint8
+			decimals, err = ws.TokenDecimals(*p.TokenName)
+			p.TokenDecimals = &decimals
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		if err := p.ValidateProposal(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		if p.Multisig.Address(ws.network.MultisigVersion) != *p.SenderBlockchainAddress {
+			log.Printf("ERROR: %v", block.ErrSenderMismatch)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(block.ErrSenderMismatch)))
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(p)
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// SignMultisig adds the signature of one key to a multisig proposal.
+func (ws *WalletServer) SignMultisig(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		var s wallet.MultisigSignRequest
+		if err := json.NewDecoder(req.Body).Decode(&s); err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if err := s.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		index := -1
+		for i, k := range s.Proposal.Multisig.PublicKeys {
+			if k == *s.PublicKey {
+				index = i
+			}
+		}
+		if index < 0 {
+			err := fmt.Errorf("%w: public key is not one of the multisig keys", block.ErrInvalidMultisig)
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		publicKey := utils.PublicKeyFromString(*s.PublicKey)
+		privateKey := utils.PrivateKeyFromString(*s.PrivateKey, publicKey)
+		s.Proposal.Signatures[index] = wallet.SignProposal(privateKey, s.Proposal).String()
+
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(struct {
+			Proposal  *block.TransactionRequest `json:"proposal"`
+			Signed    int                       `json:"signed"`
+			Threshold uint8                     `json:"threshold"`
+		}{
+			Proposal:  s.Proposal,
+			Signed:    s.Proposal.SignatureCount(),
+			Threshold: s.Proposal.Multisig.Threshold,
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
@@ -3,28 +3,37 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"main/block"
+	"main/config"
 	"main/utils"
 	"main/wallet"
 	"net/http"
+	"net/url"
 	"path"
 	"strconv"
 	"text/template"
+
+	"github.com/shopspring/decimal"
 )
 
 const tempDir = "./templates"
 
+// WalletServer relays to its gateway node. With a light client it verifies
+// what the nodes tell it instead, see LightClient.
 type WalletServer struct {
 	port    uint16
 	gateway string
+	network config.Network
+	light   *LightClient
 }
 
-func NewWalletServer(port uint16, gateway string) *WalletServer {
-	return &WalletServer{port: port, gateway: gateway}
+func NewWalletServer(port uint16, gateway string, network config.Network) *WalletServer {
+	return &WalletServer{port: port, gateway: gateway, network: network}
 }
 
 func (ws *WalletServer) Port() uint16 {
@@ -35,6 +44,27 @@ func (ws *WalletServer) Gateway() string {
 	return ws.gateway
 }
 
+func (ws *WalletServer) Network() config.Network {
+	return ws.network
+}
+
+// UseLightClient switches to light client mode, following the gateway and
+// nodes.
+func (ws *WalletServer) UseLightClient(nodes []string) error {
+	all := []string{ws.gateway}
+	for _, n := range nodes {
+		if n != "" && n != ws.gateway {
+			all = append(all, n)
+		}
+	}
+	light, err := NewLightClient(all, ws.network)
+	if err != nil {
+		return err
+	}
+	ws.light = light
+	return nil
+}
+
 func (ws *WalletServer) Index(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -49,7 +79,7 @@ func (ws *WalletServer) Wallet(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
 		w.Header().Add("Content-Type", "application/json")
-		myWallet := wallet.NewWallet()
+		myWallet := wallet.NewWalletWithVersion(ws.network.AddressVersion)
 		m, _ := myWallet.MarshalJSON()
 		io.WriteString(w, string(m[:]))
 	default:
@@ -69,12 +99,123 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Reque
 			io.WriteString(w, string(utils.JsonStatus("fail")))
 			return
 		}
-		if !t.Validate() {
-			log.Println("ERROR: missing field(s)")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+		if err := t.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		if t.Type != nil && (*t.Type == block.TxEscrowRelease || *t.Type == block.TxEscrowRefund) {
+			// Settlements need a second party, see /escrow/propose.
+			err := fmt.Errorf("%w: %s must be co-signed", block.ErrInvalidEscrow, *t.Type)
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
 			return
 		}
 
+		isMulti := t.Type != nil && *t.Type == block.TxMultiTransfer
+		isContract := t.Type != nil && (*t.Type == block.TxDeploy || *t.Type == block.TxCall)
+		isSettle := t.Type != nil && (*t.Type == block.TxHTLCClaim || *t.Type == block.TxHTLCRefund)
+		if isSettle {
+			// Claims and refunds pay out exactly what the contract holds.
+			info, err := ws.HTLC(t.Settle.ID)
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
+			}
+			noRecipient := ""
+			t.RecipientBlockchainAddress = &noRecipient
+			t.TokenName, t.TokenValue, t.TokenDecimals = &info.TokenName, &info.Amount, &info.Decimals
+		}
+
+		var decimals uint8
+		switch {
+		case isMulti:
+			// Every output carries the decimals of its own token.
+			for _, o := range t.Outputs {
+				o.TokenDecimals, err = ws.TokenDecimals(o.TokenName)
+				if err != nil {
+					log.Printf("ERROR: %v", err)
+					w.WriteHeader(http.StatusBadRequest)
+					io.WriteString(w, string(utils.JsonError(err)))
+					return
+				}
+			}
+		case isContract:
+			// Contract transactions move no token.
+		case t.TokenDecimals != nil:
+			decimals = *t.TokenDecimals
+		case t.Type != nil && *t.Type == block.TxIssueToken:
+			decimals = t.Issue.Decimals
+		default:
+			decimals, err = ws.TokenDecimals(*t.TokenName)
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
+			}
+		}
+
+		addresses := []string{*t.SenderBlockchainAddress}
+		switch {
+		case isMulti:
+			for _, o := range t.Outputs {
+				addresses = append(addresses, o.RecipientBlockchainAddress)
+			}
+		case t.Type != nil && *t.Type == block.TxBurn, isSettle, isContract:
+		default:
+			addresses = append(addresses, *t.RecipientBlockchainAddress)
+		}
+		if t.Escrow != nil {
+			addresses = append(addresses, t.Escrow.Arbiter)
+		}
+		for _, address := range addresses {
+			if err := utils.ValidateAddress(address, ws.network.AddressVersion, ws.network.MultisigVersion); err != nil {
+				log.Printf("ERROR: %v", err)
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+		}
+		if t.Type != nil && *t.Type == block.TxCall {
+			if err := utils.ValidateAddress(*t.RecipientBlockchainAddress, ws.network.ContractVersion); err != nil {
+				log.Printf("ERROR: %v", err)
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+		}
+
+		var nonce uint64
+		switch {
+		case t.Nonce != nil:
+			nonce = *t.Nonce
+		default:
+			nonce, err = ws.Nonce(*t.SenderBlockchainAddress)
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+		}
+
+		var recipient string
+		var token block.Token
+		switch {
+		case isMulti:
+		case isContract:
+			if *t.Type == block.TxCall {
+				recipient = *t.RecipientBlockchainAddress
+			}
+			t.TokenName, t.TokenValue = nil, nil
+		default:
+			recipient = *t.RecipientBlockchainAddress
+			token = block.Token{TokenName: *t.TokenName, TokenValue: *t.TokenValue}
+		}
+
 		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
 		privateKey := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
 
@@ -86,35 +227,193 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Reque
 		// fmt.Println(*t.TokenValue)
 
 		w.Header().Add("Content-Type", "application/json")
-		transaction := wallet.NewTransaction(privateKey, publicKey, *t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, block.Token{TokenName: *t.TokenName, TokenValue: *t.TokenValue})
+		transaction := wallet.NewTransaction(ws.network.ChainID, privateKey, publicKey, *t.SenderBlockchainAddress, recipient, token)
+		var expiryHeight, expiresAt int64
+		if t.ExpiryHeight != nil {
+			expiryHeight = *t.ExpiryHeight
+		}
+		if t.ExpiresAt != nil {
+			expiresAt = *t.ExpiresAt
+		}
+		transaction.SetExpiry(expiryHeight, expiresAt)
+		var lockHeight, lockedUntil int64
+		if t.LockHeight != nil {
+			lockHeight = *t.LockHeight
+		}
+		if t.LockedUntil != nil {
+			lockedUntil = *t.LockedUntil
+		}
+		transaction.SetLock(lockHeight, lockedUntil)
+		transaction.SetDecimals(decimals)
+		if t.Type != nil {
+			transaction.SetType(*t.Type)
+		}
+		if t.Type != nil && *t.Type == block.TxIssueToken {
+			transaction.SetIssue(t.Issue)
+		}
+		if isMulti {
+			transaction.SetOutputs(t.Outputs)
+		}
+		if t.HTLC != nil {
+			transaction.SetHTLC(t.HTLC)
+		}
+		if t.Escrow != nil {
+			transaction.SetEscrow(t.Escrow)
+		}
+		if isSettle {
+			transaction.SetHTLCSettle(*t.Type, t.Settle)
+		}
+		if t.Type != nil && *t.Type == block.TxDeploy {
+			transaction.SetDeploy(t.Deploy)
+		}
+		if t.Type != nil && *t.Type == block.TxCall {
+			transaction.SetCall(t.Call)
+		}
+		transaction.SetNonce(nonce)
 		signature := transaction.GenerateSignature()
 		signatureStr := signature.String()
+		chainID := ws.network.ChainID
 
 		bt := &block.TransactionRequest{
+			Outputs:                    t.Outputs,
+			HTLC:                       t.HTLC,
+			Settle:                     t.Settle,
+			Escrow:                     t.Escrow,
+			Deploy:                     t.Deploy,
+			Call:                       t.Call,
+			ChainID:                    &chainID,
+			Type:                       t.Type,
+			Issue:                      t.Issue,
 			SenderBlockchainAddress:    t.SenderBlockchainAddress,
-			RecipientBlockchainAddress: t.RecipientBlockchainAddress,
+			RecipientBlockchainAddress: &recipient,
 			SenderPublicKey:            t.SenderPublicKey,
 			TokenName:                  t.TokenName,
 			TokenValue:                 t.TokenValue,
+			TokenDecimals:              &decimals,
+			Nonce:                      &nonce,
 			Signature:                  &signatureStr,
+			ExpiryHeight:               t.ExpiryHeight,
+			ExpiresAt:                  t.ExpiresAt,
+			LockHeight:                 t.LockHeight,
+			LockedUntil:                t.LockedUntil,
 		}
 
-		m, _ := json.Marshal(bt)
-		buf := bytes.NewBuffer(m)
-
-		response, _ := http.Post(ws.Gateway()+"/transactions", "application/json", buf)
+		ws.postTransaction(w, bt)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
 
-		if response.StatusCode == 201 {
-			io.WriteString(w, string(utils.JsonStatus("success")))
+// SubmitSigned relays a transaction that collected the signatures of all
+// its parties, i.e. a co-signed swap or escrow settlement or a multisig
+// transaction that reached its threshold, to the node.
+func (ws *WalletServer) SubmitSigned(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		var bt block.TransactionRequest
+		if err := json.NewDecoder(req.Body).Decode(&bt); err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
 			return
 		}
-		io.WriteString(w, string(utils.JsonStatus("fail")))
+		if err := bt.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		ws.postTransaction(w, &bt)
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		log.Println("ERROR: Invalid HTTP Method")
 	}
 }
 
+// postTransaction relays bt to the gateway, or to every node in light client
+// mode. One node accepting it is enough, the node gossips it on.
+func (ws *WalletServer) postTransaction(w http.ResponseWriter, bt *block.TransactionRequest) {
+	m, _ := json.Marshal(bt)
+	nodes := []string{ws.Gateway()}
+	if ws.light != nil {
+		nodes = ws.light.Nodes()
+	}
+
+	accepted := false
+	var rejection []byte
+	for _, n := range nodes {
+		response, err := http.Post(n+"/transactions", "application/json", bytes.NewReader(m))
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode == http.StatusCreated {
+			accepted = true
+		} else if rejection == nil {
+			rejection = body
+		}
+	}
+
+	if accepted {
+		m, _ := json.Marshal(struct {
+			Message       string `json:"message"`
+			TransactionID string `json:"transaction_id"`
+		}{
+			Message:       "success",
+			TransactionID: bt.Transaction().ID(),
+		})
+		io.WriteString(w, string(m[:]))
+		return
+	}
+	if rejection == nil {
+		io.WriteString(w, string(utils.JsonStatus("fail")))
+		return
+	}
+	// Pass the reason given by the node on to the client.
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(rejection)
+}
+
+// Nonce asks the gateway for the next nonce of the sender.
+func (ws *WalletServer) Nonce(blockchainAddress string) (uint64, error) {
+	response, err := http.Get(ws.Gateway() + "/nonce?blockchain_address=" + url.QueryEscape(blockchainAddress))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	var n struct {
+		Nonce uint64 `json:"nonce"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&n); err != nil {
+		return 0, err
+	}
+	return n.Nonce, nil
+}
+
+// TokenDecimals asks the gateway for the decimals of a registered token.
+func (ws *WalletServer) TokenDecimals(symbol string) (uint8, error) {
+	response, err := http.Get(ws.Gateway() + "/tokens/" + url.PathEscape(symbol))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w: %s", block.ErrUnknownToken, symbol)
+	}
+
+	var info block.TokenInfo
+	if err := json.NewDecoder(response.Body).Decode(&info); err != nil {
+		return 0, err
+	}
+	return info.Decimals, nil
+}
+
 func (ws *WalletServer) WalletAmount(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -159,12 +458,67 @@ func (ws *WalletServer) WalletAmount(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		// In light client mode the gateway is not trusted with the list of
+		// tokens either. Every token the light client knows of is proven,
+		// tokens the gateway left out are added unless their balance is
+		// zero. Immature rewards cannot be proven, the gateway is only
+		// trusted not to claim more than the balance. Proofs older than
+		// lightProofWindow blocks, or no proof at all, leave the result
+		// unverified.
+		var verifiedHeight int64
+		verified := false
+		if ws.light != nil {
+			reported := make(map[string]*block.Balance, len(bar.Amount))
+			for _, b := range bar.Amount {
+				reported[b.TokenName] = b
+			}
+			amounts := make([]*block.Balance, 0, len(bar.Amount))
+			checked := 0
+			verified = true
+			for _, tokenName := range ws.light.Tokens() {
+				p, err := ws.light.Balance(blockchainAddress, tokenName)
+				if err != nil {
+					log.Printf("ERROR: %v", err)
+					w.WriteHeader(http.StatusBadGateway)
+					io.WriteString(w, string(utils.JsonError(err)))
+					return
+				}
+				checked++
+				if verifiedHeight == 0 || p.BlockHeight < verifiedHeight {
+					verifiedHeight = p.BlockHeight
+				}
+				if ws.light.Height()-p.BlockHeight > lightProofWindow {
+					verified = false
+				}
+
+				b, ok := reported[tokenName]
+				if !ok {
+					if p.Balance.IsZero() {
+						continue
+					}
+					b = &block.Balance{TokenName: tokenName}
+				}
+				b.TokenValue = p.Balance
+				b.Immature = decimal.Min(b.Immature, p.Balance)
+				b.Spendable = p.Balance.Sub(b.Immature)
+				amounts = append(amounts, b)
+			}
+			if checked == 0 {
+				verified = false
+			}
+			bar.Amount = amounts
+		}
+
 		m, _ := json.Marshal(struct {
-			Message string         `json:"message"`
-			Amount  []*block.Token `json:"amount"`
+			Message        string           `json:"message"`
+			Amount         []*block.Balance `json:"amount"`
+			Verified       bool             `json:"verified"`
+			VerifiedHeight int64            `json:"verified_height,omitempty"`
 		}{
-			Message: "success",
-			Amount:  bar.Amount,
+			Message:        "success",
+			Amount:         bar.Amount,
+			Verified:       verified,
+			VerifiedHeight: verifiedHeight,
 		})
 		io.WriteString(w, string(m[:]))
 
@@ -175,10 +529,73 @@ func (ws *WalletServer) WalletAmount(w http.ResponseWriter, req *http.Request) {
 
 }
 
+// TransactionStatus reports whether the transaction given by the id query
+// parameter is mined. In light client mode only a verified proof counts.
+func (ws *WalletServer) TransactionStatus(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		id := req.URL.Query().Get("id")
+
+		var p *block.TransactionProof
+		var err error
+		if ws.light != nil {
+			p, err = ws.light.Transaction(id)
+		} else {
+			p, err = fetchTransactionProof(ws.Gateway(), id)
+		}
+		if err != nil && !errors.Is(err, block.ErrNotMined) {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadGateway)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		status := struct {
+			TransactionID string `json:"transaction_id"`
+			Mined         bool   `json:"mined"`
+			BlockHeight   int64  `json:"block_height,omitempty"`
+			Confirmations int64  `json:"confirmations,omitempty"`
+			Verified      bool   `json:"verified"`
+		}{
+			TransactionID: id,
+			Verified:      ws.light != nil,
+		}
+		if p != nil {
+			status.Mined = true
+			status.BlockHeight = p.BlockHeight
+			if ws.light != nil {
+				status.Confirmations = ws.light.Height() - p.BlockHeight + 1
+			}
+		}
+		m, _ := json.Marshal(status)
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
 func (ws *WalletServer) Run() {
+	if ws.light != nil {
+		ws.light.StartSync()
+	}
 	http.HandleFunc("/", ws.Index)
 	http.HandleFunc("/wallet", ws.Wallet)
 	http.HandleFunc("/balance", ws.WalletAmount)
 	http.HandleFunc("/transaction", ws.CreateTransaction)
+	http.HandleFunc("/transaction/status", ws.TransactionStatus)
+	http.HandleFunc("/history", ws.History)
+	http.HandleFunc("/swap/propose", ws.ProposeSwap)
+	http.HandleFunc("/swap/cosign", ws.CosignSwap)
+	http.HandleFunc("/swap/submit", ws.SubmitSigned)
+	http.HandleFunc("/htlc/secret", ws.HTLCSecret)
+	http.HandleFunc("/multisig", ws.CreateMultisig)
+	http.HandleFunc("/multisig/propose", ws.ProposeMultisig)
+	http.HandleFunc("/multisig/sign", ws.SignMultisig)
+	http.HandleFunc("/multisig/submit", ws.SubmitSigned)
+	http.HandleFunc("/escrow/propose", ws.ProposeEscrowSettle)
+	http.HandleFunc("/escrow/cosign", ws.CosignEscrowSettle)
+	http.HandleFunc("/escrow/submit", ws.SubmitSigned)
 	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(ws.Port())), nil))
 }
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"main/block"
+	"main/utils"
+	"main/wallet"
+	"net/http"
+)
+
+// A swap is agreed in three steps. The sender proposes it and signs, the
+// recipient checks the returned proposal and co-signs it, and either party
+// submits the fully signed swap to the node.
+
+// ProposeSwap signs a swap as its sender and returns the proposal for the
+// recipient to co-sign. Nothing is sent to the node yet.
+func (ws *WalletServer) ProposeSwap(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		var t wallet.TransactionRequest
+		if err := json.NewDecoder(req.Body).Decode(&t); err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		txType := block.TxSwap
+		t.Type = &txType
+		if err := t.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		// Both legs are signed in the unit of their registered token.
+		decimals, err := ws.TokenDecimals(*t.TokenName)
+		if err == nil {
+			t.Swap.TokenDecimals, err = ws.TokenDecimals(t.Swap.TokenName)
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		for _, address := range []string{*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress} {
+			if err := utils.ValidateAddress(address, ws.network.AddressVersion, ws.network.MultisigVersion); err != nil {
+				log.Printf("ERROR: %v", err)
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+		}
+
+		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
+		privateKey := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
+
+		transaction := wallet.NewTransaction(ws.network.ChainID, privateKey, publicKey, *t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, block.Token{TokenName: *t.TokenName, TokenValue: *t.TokenValue})
+		var expiryHeight, expiresAt int64
+		if t.ExpiryHeight != nil {
+			expiryHeight = *t.ExpiryHeight
+		}
+		if t.ExpiresAt != nil {
+			expiresAt = *t.ExpiresAt
+		}
+		transaction.SetExpiry(expiryHeight, expiresAt)
+		var lockHeight, lockedUntil int64
+		if t.LockHeight != nil {
+			lockHeight = *t.LockHeight
+		}
+		if t.LockedUntil != nil {
+			lockedUntil = *t.LockedUntil
+		}
+		transaction.SetLock(lockHeight, lockedUntil)
+		transaction.SetDecimals(decimals)
+		transaction.SetSwap(t.Swap)
+		if t.Nonce == nil {
+			nonce, err := ws.Nonce(*t.SenderBlockchainAddress)
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+			t.Nonce = &nonce
+		}
+		transaction.SetNonce(*t.Nonce)
+		signatureStr := transaction.GenerateSignature().String()
+		chainID := ws.network.ChainID
+
+		proposal := &block.TransactionRequest{
+			ChainID:                    &chainID,
+			Type:                       t.Type,
+			Swap:                       t.Swap,
+			SenderBlockchainAddress:    t.SenderBlockchainAddress,
+			RecipientBlockchainAddress: t.RecipientBlockchainAddress,
+			SenderPublicKey:            t.SenderPublicKey,
+			TokenName:                  t.TokenName,
+			TokenValue:                 t.TokenValue,
+			TokenDecimals:              &decimals,
+			Nonce:                      t.Nonce,
+			Signature:                  &signatureStr,
+			ExpiryHeight:               t.ExpiryHeight,
+			ExpiresAt:                  t.ExpiresAt,
+			LockHeight:                 t.LockHeight,
+			LockedUntil:                t.LockedUntil,
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(proposal)
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// CosignSwap adds the signature of the recipient to a swap proposal and
+// returns the signed swap.
+func (ws *WalletServer) CosignSwap(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		var c wallet.CosignRequest
+		if err := json.NewDecoder(req.Body).Decode(&c); err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if err := c.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+
+		publicKey := utils.PublicKeyFromString(*c.CounterpartyPublicKey)
+		if address := utils.AddressFromPublicKey(publicKey, ws.network.AddressVersion); address != *c.Proposal.RecipientBlockchainAddress {
+			err := fmt.Errorf("%w: counterparty key does not belong to the recipient", block.ErrInvalidSwap)
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		privateKey := utils.PrivateKeyFromString(*c.CounterpartyPrivateKey, publicKey)
+
+		signatureStr := wallet.SignProposal(privateKey, c.Proposal).String()
+		c.Proposal.CounterpartyPublicKey = c.CounterpartyPublicKey
+		c.Proposal.CounterpartySignature = &signatureStr
+
+		w.Header().Add("Content-Type", "application/json")
+		m, _ := json.Marshal(c.Proposal)
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}